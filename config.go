@@ -1,16 +1,42 @@
 package main
 
+import (
+	"fmt"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/run"
+)
+
 // LoggingConfig описывает параметры логирования
 type LoggingConfig struct {
-	Level     string `yaml:"level"`
-	Formatter string `yaml:"formatter"`
+	Level     string          `yaml:"level"`     // Уровень по умолчанию для приёмников
+	Formatter string          `yaml:"formatter"` // Формат по умолчанию для приёмников
+	Redact    []string        `yaml:"redact"`    // Шаблоны имён ключей, значения которых не выводятся в лог
+	Sinks     []LogSinkConfig `yaml:"sinks"`     // Приёмники логов; без них - только консоль
+}
+
+// LogSinkConfig описывает приёмник логов
+type LogSinkConfig struct {
+	Type       string `yaml:"type"`        // console, file или run
+	Level      string `yaml:"level"`       // TRACE, DEBUG, INFO, WARN, ERROR
+	Formatter  string `yaml:"formatter"`   // default, json или text
+	Path       string `yaml:"path"`        // file - путь к файлу, run - каталог файлов запусков
+	MaxSizeMB  int    `yaml:"max_size_mb"` // file - размер, после которого файл ротируется
+	MaxBackups int    `yaml:"max_backups"` // file - сколько ротированных файлов хранить
 }
 
 // Plugin описывает параметры плагинов
 type PluginConfig struct {
-	PluginPath     string `yaml:"plugin_path"`
-	PluginRepoPath string `yaml:"plugin_repo_path"`
-	DefaultRepo    string `yaml:"default_repo"`
+	PluginPath      string   `yaml:"plugin_path"`
+	PluginRepoPath  string   `yaml:"plugin_repo_path"`
+	DefaultRepo     string   `yaml:"default_repo"`
+	LockFile        string   `yaml:"lock_file"`        // Файл с закреплёнными версиями плагинов
+	TrustedKeys     []string `yaml:"trusted_keys"`     // Публичные ключи ed25519 для проверки подписи плагинов
+	AllowUnverified bool     `yaml:"allow_unverified"` // Разрешить установку плагинов без 'hash' в индексе
+}
+
+// SecretsConfig описывает параметры секретов в конфигурации компонентов
+type SecretsConfig struct {
+	KeyFile string `yaml:"key_file"` // Ключ AES-256 для значений '${enc:...}'
 }
 
 type Pei struct {
@@ -21,10 +47,52 @@ type Pei struct {
 type Global struct {
 	Logging LoggingConfig `yaml:"logging"`
 	Plugin  PluginConfig  `yaml:"plugin"`
+	Secrets SecretsConfig `yaml:"secrets"`
 	Pei     Pei           `yaml:"pei"`
 }
 
 // rollerConfig структура конфигурации
 type RollerConfig struct {
-	Global Global `yaml:"global"`
+	Global  Global                            `yaml:"global"`
+	Plugins map[string]map[string]interface{} `yaml:"plugins"` // Настройки плагинов по имени плагина
+}
+
+// PluginConfig возвращает секции 'plugins' для PluginController: вложенные карты
+// приводятся к map[string]interface{}, ссылки на секреты разрешаются
+func (rc *RollerConfig) PluginConfig() (map[string]map[string]interface{}, error) {
+
+	plugins := make(map[string]map[string]interface{}, len(rc.Plugins))
+	for name, section := range rc.Plugins {
+		config, err := run.ResolveSecrets(normalizeYAML(section).(map[string]interface{}))
+		if err != nil {
+			return nil, fmt.Errorf("[Config] plugins.%s %v", name, err)
+		}
+		plugins[name] = config
+	}
+	return plugins, nil
+}
+
+// normalizeYAML заменяет map[interface{}]interface{} (yaml.v2) на map[string]interface{} на любой глубине
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[fmt.Sprint(key)] = normalizeYAML(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeYAML(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeYAML(item)
+		}
+		return normalized
+	}
+	return value
 }
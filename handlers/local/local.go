@@ -0,0 +1,498 @@
+package local
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// Встроенный исполнитель: запускает команды на машине, где работает roller.
+//
+// Компонент (config в стендах) задаёт значения по умолчанию:
+//
+//	config:
+//	  shell: "/bin/sh"
+//	  workdir: "/opt/app"
+//	  env: {APP_ENV: "prod"}
+//
+// Действие или проверка:
+//
+//	action:
+//	  command: "ls -asl /"         # выполняется через shell -c
+//	  args: ["ls", "-asl", "/"]    # или запуск без shell
+//	  workdir: "/tmp"
+//	  env: {KEY: "value"}
+//	  timeout: 30s
+//	  expect_exit_code: 0
+//	  expect_stdout: "^total"      # регулярное выражение
+//
+// Значения по умолчанию для всех компонентов задаются в config.yml:
+//
+//	plugins:
+//	  local:
+//	    shell: "/bin/bash"
+//	    env: {HTTPS_PROXY: "http://proxy:3128"}
+//	    timeout: 10m
+const (
+	PLUGIN_NAME    = "local"
+	PLUGIN_VERSION = "0.0.1"
+)
+
+var (
+	DEFAULT_SHELL     = "/bin/sh"
+	DEFAULT_TIMEOUT   = 5 * time.Minute
+	MAX_OUTPUT_IN_ERR = 2048
+
+	// ENV_EXCLUDE_PREFIXES переменные RoLLeR, которые не передаются командам:
+	// настройки плагинов (ROLLER_PLUGIN_<ИМЯ>_<КЛЮЧ>) могут содержать секреты
+	ENV_EXCLUDE_PREFIXES = []string{"ROLLER_PLUGIN_"}
+)
+
+// Component параметры запуска, общие для всех команд компонента
+type Component struct {
+	Shell   string
+	WorkDir string
+	Env     map[string]string
+}
+
+// Command команда действия или проверки
+type Command struct {
+	Command        string
+	Args           []string
+	Shell          string
+	WorkDir        string
+	Env            map[string]string
+	Timeout        time.Duration
+	ExpectExitCode int
+	ExpectStdout   *regexp.Regexp
+}
+
+// Result результат выполнения команды
+type Result struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// Executor настройки из секции 'plugins.local' применяются к компонентам и командам, где они не указаны
+type Executor struct {
+	Defaults Component
+	Timeout  time.Duration
+}
+
+// NewExecutor создаёт встроенный исполнитель 'local'
+func NewExecutor() v1.Executor {
+	return &Executor{}
+}
+
+func (e *Executor) GetInfo() (v1.PluginInfo, error) {
+	return v1.PluginInfo{
+		Name:        PLUGIN_NAME,
+		Version:     PLUGIN_VERSION,
+		Description: "Built-in executor running commands on the roller host",
+	}, nil
+}
+
+// SensitiveFields env компонента и команд часто содержит пароли и токены
+func (e *Executor) SensitiveFields() ([]string, error) {
+	return []string{"env"}, nil
+}
+
+// Configure принимает секцию 'plugins.local': shell, workdir, env и timeout
+func (e *Executor) Configure(config map[string]interface{}) error {
+
+	defaults, err := parseComponent(config)
+	if err != nil {
+		return err
+	}
+	var timeout time.Duration
+	if raw, ok := config["timeout"]; ok && raw != nil {
+		if timeout, err = parseDuration(raw); err != nil {
+			return fmt.Errorf("'timeout': %v", err)
+		}
+	}
+	e.Defaults, e.Timeout = *defaults, timeout
+	return nil
+}
+
+func (e *Executor) GetComponent(config map[string]interface{}) (v1.Component, error) {
+
+	component, err := parseComponent(config)
+	if err != nil {
+		return nil, err
+	}
+	component.Shell = firstNonEmpty(component.Shell, e.Defaults.Shell)
+	component.WorkDir = firstNonEmpty(component.WorkDir, e.Defaults.WorkDir)
+	if len(e.Defaults.Env) > 0 {
+		env := make(map[string]string, len(e.Defaults.Env)+len(component.Env))
+		for name, value := range e.Defaults.Env {
+			env[name] = value
+		}
+		for name, value := range component.Env {
+			env[name] = value
+		}
+		component.Env = env
+	}
+	return component, nil
+}
+
+func (e *Executor) GetAction(config map[string]interface{}) (v1.Action, error) {
+	return e.command(config)
+}
+
+func (e *Executor) GetCheck(config map[string]interface{}) (v1.Check, error) {
+	return e.command(config)
+}
+
+// command разбирает команду; таймаут по умолчанию берётся из настроек плагина
+func (e *Executor) command(config map[string]interface{}) (*Command, error) {
+	command, err := parseCommand(config)
+	if err != nil {
+		return nil, err
+	}
+	if command.Timeout <= 0 {
+		command.Timeout = e.Timeout
+	}
+	return command, nil
+}
+
+func (e *Executor) ValidateYAMLComponent(component v1.Component) error {
+	c, ok := component.(*Component)
+	if !ok {
+		return fmt.Errorf("unexpected component type %T", component)
+	}
+	if c.WorkDir != "" {
+		if info, err := os.Stat(c.WorkDir); err != nil || !info.IsDir() {
+			return fmt.Errorf("workdir '%s' is not a directory", c.WorkDir)
+		}
+	}
+	return nil
+}
+
+func (e *Executor) ValidateYAMLAction(ctx context.Context, action v1.Action) error {
+	return validateCommand(action)
+}
+
+func (e *Executor) ValidateYAMLCheck(ctx context.Context, check v1.Check) error {
+	return validateCommand(check)
+}
+
+// ExecAction выполняет команду; неожиданный код выхода или вывод - ошибка
+func (e *Executor) ExecAction(ctx context.Context, component v1.Component, action v1.Action) error {
+	_, err := e.ExecActionOutput(ctx, component, action)
+	return err
+}
+
+// ExecCheck выполняет команду; неожиданный код выхода или вывод - false
+func (e *Executor) ExecCheck(ctx context.Context, component v1.Component, check v1.Check) (bool, error) {
+	ok, _, err := e.ExecCheckOutput(ctx, component, check)
+	return ok, err
+}
+
+// ExecActionOutput как ExecAction, но возвращает stdout, stderr и exit_code для следующих шагов
+func (e *Executor) ExecActionOutput(ctx context.Context, component v1.Component, action v1.Action) (map[string]interface{}, error) {
+
+	command, c, err := commandAndComponent(component, action)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := Run(ctx, c, command)
+	if err != nil {
+		return nil, err
+	}
+	return result.Outputs(), command.verify(result)
+}
+
+// ExecCheckOutput как ExecCheck, но возвращает stdout, stderr и exit_code для следующих шагов
+func (e *Executor) ExecCheckOutput(ctx context.Context, component v1.Component, check v1.Check) (bool, map[string]interface{}, error) {
+
+	command, c, err := commandAndComponent(component, check)
+	if err != nil {
+		return false, nil, err
+	}
+
+	result, err := Run(ctx, c, command)
+	if err != nil {
+		return false, nil, err
+	}
+	return command.verify(result) == nil, result.Outputs(), nil
+}
+
+// Run запускает команду с параметрами компонента.
+// Ошибка возвращается, только если команду не удалось запустить или истёк таймаут.
+func Run(ctx context.Context, component *Component, command *Command) (Result, error) {
+
+	timeout := command.Timeout
+	if timeout <= 0 {
+		timeout = DEFAULT_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if len(command.Args) > 0 {
+		cmd = exec.CommandContext(ctx, command.Args[0], command.Args[1:]...)
+	} else {
+		shell := firstNonEmpty(command.Shell, component.Shell, DEFAULT_SHELL)
+		cmd = exec.CommandContext(ctx, shell, "-c", command.Command)
+	}
+	cmd.Dir = firstNonEmpty(command.WorkDir, component.WorkDir)
+	cmd.Env = mergeEnv(inheritedEnv(), component.Env, command.Env)
+	// Дочерние процессы могут держать вывод открытым после отмены
+	cmd.WaitDelay = time.Second
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return result, fmt.Errorf("command '%s' timed out after %s", command, timeout)
+	}
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("command '%s' cancelled: %v", command, ctx.Err())
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("failed to run command '%s': %v", command, err)
+	}
+	return result, nil
+}
+
+// Outputs результат команды в виде выходных значений шага
+func (r Result) Outputs() map[string]interface{} {
+	return map[string]interface{}{
+		"stdout":    r.Stdout,
+		"stderr":    r.Stderr,
+		"exit_code": r.ExitCode,
+	}
+}
+
+// verify сверяет код выхода и stdout с ожидаемыми
+func (c *Command) verify(result Result) error {
+
+	if result.ExitCode != c.ExpectExitCode {
+		return fmt.Errorf("command '%s' exited with code %d, expected %d: %s", c, result.ExitCode, c.ExpectExitCode, tail(result.Stderr, result.Stdout))
+	}
+	if c.ExpectStdout != nil && !c.ExpectStdout.MatchString(result.Stdout) {
+		return fmt.Errorf("command '%s' stdout does not match '%s': %s", c, c.ExpectStdout, tail(result.Stdout))
+	}
+	return nil
+}
+
+func (c *Command) String() string {
+	if len(c.Args) > 0 {
+		return strings.Join(c.Args, " ")
+	}
+	return c.Command
+}
+
+func parseComponent(config map[string]interface{}) (*Component, error) {
+
+	component := &Component{}
+	var err error
+	if component.Shell, err = stringField(config, "shell"); err != nil {
+		return nil, err
+	}
+	if component.WorkDir, err = stringField(config, "workdir"); err != nil {
+		return nil, err
+	}
+	if component.Env, err = envField(config, "env"); err != nil {
+		return nil, err
+	}
+	return component, nil
+}
+
+func parseCommand(config map[string]interface{}) (*Command, error) {
+
+	command := &Command{}
+	var err error
+	if command.Command, err = stringField(config, "command"); err != nil {
+		return nil, err
+	}
+	if command.Shell, err = stringField(config, "shell"); err != nil {
+		return nil, err
+	}
+	if command.WorkDir, err = stringField(config, "workdir"); err != nil {
+		return nil, err
+	}
+	if command.Env, err = envField(config, "env"); err != nil {
+		return nil, err
+	}
+
+	if raw, ok := config["args"]; ok {
+		list, ok := raw.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("'args' must be a list, got %T", raw)
+		}
+		for _, arg := range list {
+			command.Args = append(command.Args, fmt.Sprint(arg))
+		}
+	}
+
+	if raw, ok := config["timeout"]; ok {
+		if command.Timeout, err = parseDuration(raw); err != nil {
+			return nil, fmt.Errorf("invalid 'timeout': %v", err)
+		}
+	}
+
+	if raw, ok := config["expect_exit_code"]; ok {
+		code, err := strconv.Atoi(fmt.Sprint(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'expect_exit_code' %v", raw)
+		}
+		command.ExpectExitCode = code
+	}
+
+	pattern, err := stringField(config, "expect_stdout")
+	if err != nil {
+		return nil, err
+	}
+	if pattern != "" {
+		if command.ExpectStdout, err = regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid 'expect_stdout': %v", err)
+		}
+	}
+	return command, nil
+}
+
+func validateCommand(value interface{}) error {
+	command, ok := value.(*Command)
+	if !ok {
+		return fmt.Errorf("unexpected command type %T", value)
+	}
+	if command.Command == "" && len(command.Args) == 0 {
+		return errors.New("either 'command' or 'args' is required")
+	}
+	if command.Command != "" && len(command.Args) > 0 {
+		return errors.New("'command' and 'args' are mutually exclusive")
+	}
+	return nil
+}
+
+func commandAndComponent(component v1.Component, value interface{}) (*Command, *Component, error) {
+	c, ok := component.(*Component)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected component type %T", component)
+	}
+	command, ok := value.(*Command)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected command type %T", value)
+	}
+	return command, c, nil
+}
+
+func stringField(config map[string]interface{}, key string) (string, error) {
+	raw, ok := config[key]
+	if !ok || raw == nil {
+		return "", nil
+	}
+	value, ok := raw.(string)
+	if !ok {
+		return "", fmt.Errorf("'%s' must be a string, got %T", key, raw)
+	}
+	return value, nil
+}
+
+func envField(config map[string]interface{}, key string) (map[string]string, error) {
+	raw, ok := config[key]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	values, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("'%s' must be a map, got %T", key, raw)
+	}
+	env := make(map[string]string, len(values))
+	for name, value := range values {
+		env[name] = fmt.Sprint(value)
+	}
+	return env, nil
+}
+
+func parseDuration(raw interface{}) (time.Duration, error) {
+	switch value := raw.(type) {
+	case int:
+		return time.Duration(value) * time.Second, nil
+	case float64:
+		return time.Duration(value * float64(time.Second)), nil
+	case string:
+		return time.ParseDuration(value)
+	}
+	return 0, fmt.Errorf("unexpected type %T", raw)
+}
+
+// inheritedEnv окружение RoLLeR без переменных ENV_EXCLUDE_PREFIXES
+func inheritedEnv() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		excluded := false
+		for _, prefix := range ENV_EXCLUDE_PREFIXES {
+			if strings.HasPrefix(variable, prefix) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			env = append(env, variable)
+		}
+	}
+	return env
+}
+
+// mergeEnv дополняет окружение roller переменными компонента и команды
+func mergeEnv(base []string, overrides ...map[string]string) []string {
+	env := append([]string{}, base...)
+	for _, override := range overrides {
+		names := make([]string, 0, len(override))
+		for name := range override {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			env = append(env, name+"="+override[name])
+		}
+	}
+	return env
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// tail возвращает конец вывода для сообщения об ошибке
+func tail(outputs ...string) string {
+	for _, output := range outputs {
+		output = strings.TrimSpace(output)
+		if output == "" {
+			continue
+		}
+		if len(output) > MAX_OUTPUT_IN_ERR {
+			output = "..." + output[len(output)-MAX_OUTPUT_IN_ERR:]
+		}
+		return output
+	}
+	return "no output"
+}
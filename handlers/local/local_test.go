@@ -0,0 +1,260 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func mustCommand(t *testing.T, config map[string]interface{}) *Command {
+	t.Helper()
+	command, err := parseCommand(config)
+	if err != nil {
+		t.Fatalf("parseCommand(%v) error: %v", config, err)
+	}
+	return command
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	component := &Component{
+		WorkDir: dir,
+		Env:     map[string]string{"A": "component", "B": "component"},
+	}
+
+	tests := []struct {
+		name       string
+		config     map[string]interface{}
+		wantStdout string
+		wantCode   int
+	}{
+		{name: "command", config: map[string]interface{}{"command": "echo hello"}, wantStdout: "hello\n"},
+		{name: "args", config: map[string]interface{}{"args": []interface{}{"echo", "a b", 1}}, wantStdout: "a b 1\n"},
+		{name: "args without shell", config: map[string]interface{}{"args": []interface{}{"echo", "$A"}}, wantStdout: "$A\n"},
+		{name: "exit code", config: map[string]interface{}{"command": "echo out; exit 3"}, wantStdout: "out\n", wantCode: 3},
+
+		// Переменные команды дополняют и переопределяют переменные компонента
+		{name: "env", config: map[string]interface{}{"command": "echo $A-$B", "env": map[string]interface{}{"B": "command"}}, wantStdout: "component-command\n"},
+		{name: "component workdir", config: map[string]interface{}{"command": "pwd"}, wantStdout: dir + "\n"},
+		{name: "command workdir", config: map[string]interface{}{"command": "pwd", "workdir": os.TempDir()}, wantStdout: os.TempDir() + "\n"},
+		{name: "shell", config: map[string]interface{}{"command": "echo $0", "shell": "/bin/sh"}, wantStdout: "/bin/sh\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Run(context.Background(), component, mustCommand(t, tt.config))
+			if err != nil {
+				t.Fatalf("Run() error: %v", err)
+			}
+			if result.Stdout != tt.wantStdout || result.ExitCode != tt.wantCode {
+				t.Errorf("Run() = %q (exit %d), want %q (exit %d)", result.Stdout, result.ExitCode, tt.wantStdout, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestRunInheritedEnv(t *testing.T) {
+	t.Setenv("ROLLER_TEST_VISIBLE", "yes")
+	t.Setenv("ROLLER_PLUGIN_SSH_PASSWORD", "secret")
+
+	// Настройки плагинов из окружения RoLLeR не передаются командам
+	command := mustCommand(t, map[string]interface{}{"command": "echo $ROLLER_TEST_VISIBLE-$ROLLER_PLUGIN_SSH_PASSWORD"})
+	result, err := Run(context.Background(), &Component{}, command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stdout != "yes-\n" {
+		t.Errorf("Run() stdout = %q, want %q", result.Stdout, "yes-\n")
+	}
+}
+
+func TestRunTimeout(t *testing.T) {
+	command := mustCommand(t, map[string]interface{}{"command": "sleep 5", "timeout": "100ms"})
+
+	start := time.Now()
+	_, err := Run(context.Background(), &Component{}, command)
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Run() error = %v, want timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Run() took %s after the timeout", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Run(ctx, &Component{}, mustCommand(t, map[string]interface{}{"command": "true"})); err == nil {
+		t.Error("Run() with cancelled context: want error")
+	}
+}
+
+func TestExecOutput(t *testing.T) {
+	executor := &Executor{}
+	component, err := executor.GetComponent(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{name: "ok", config: map[string]interface{}{"command": "echo 1.2.3"}},
+		{name: "expected exit code", config: map[string]interface{}{"command": "exit 2", "expect_exit_code": 2}},
+		{name: "exit code mismatch", config: map[string]interface{}{"command": "echo boom >&2; exit 1"}, wantErr: "exited with code 1, expected 0: boom"},
+		{name: "expected code not returned", config: map[string]interface{}{"command": "true", "expect_exit_code": "2"}, wantErr: "exited with code 0, expected 2"},
+		{name: "stdout", config: map[string]interface{}{"command": "echo version 1.2.3", "expect_stdout": `^version \d+`}},
+		{name: "stdout mismatch", config: map[string]interface{}{"command": "echo 0.9", "expect_stdout": `^1\.`}, wantErr: "stdout does not match '^1\\.': 0.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, err := executor.GetAction(tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			outputs, err := executor.ExecActionOutput(context.Background(), component, action)
+			ok, _, checkErr := executor.ExecCheckOutput(context.Background(), component, action)
+			if checkErr != nil {
+				t.Fatalf("ExecCheckOutput() error: %v", checkErr)
+			}
+			if tt.wantErr == "" {
+				if err != nil || !ok {
+					t.Fatalf("ExecActionOutput() = %v, check %v, want success", err, ok)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ExecActionOutput() error = %v, want %q", err, tt.wantErr)
+			}
+			// Неуспешная проверка - false, а не ошибка; вывод доступен следующим шагам
+			if ok {
+				t.Error("ExecCheckOutput() = true, want false")
+			}
+			if _, found := outputs["exit_code"]; !found {
+				t.Errorf("ExecActionOutput() outputs = %v, want exit_code", outputs)
+			}
+		})
+	}
+}
+
+func TestConfigureDefaults(t *testing.T) {
+	dir := t.TempDir()
+	executor := &Executor{}
+	err := executor.Configure(map[string]interface{}{
+		"workdir": dir,
+		"env":     map[string]interface{}{"A": "plugin", "B": "plugin"},
+		"timeout": 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	component, err := executor.GetComponent(map[string]interface{}{"env": map[string]interface{}{"B": "component"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := component.(*Component)
+	if c.WorkDir != dir || c.Env["A"] != "plugin" || c.Env["B"] != "component" {
+		t.Errorf("GetComponent() = %+v, want plugin defaults under component values", c)
+	}
+
+	action, err := executor.GetAction(map[string]interface{}{"command": "true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if timeout := action.(*Command).Timeout; timeout != time.Second {
+		t.Errorf("command timeout = %s, want plugin default 1s", timeout)
+	}
+
+	if err := executor.Configure(map[string]interface{}{"timeout": "soon"}); err == nil {
+		t.Error("Configure() with invalid timeout: want error")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{name: "command", config: map[string]interface{}{"command": "true"}},
+		{name: "args", config: map[string]interface{}{"args": []interface{}{"true"}}},
+		{name: "neither", config: map[string]interface{}{"workdir": "/tmp"}, wantErr: "either 'command' or 'args' is required"},
+		{name: "both", config: map[string]interface{}{"command": "true", "args": []interface{}{"true"}}, wantErr: "mutually exclusive"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateCommand(mustCommand(t, tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateCommand() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateCommand() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if err := validateCommand("true"); err == nil {
+		t.Error("validateCommand(string): want error")
+	}
+	executor := &Executor{}
+	if err := executor.ValidateYAMLComponent(&Component{WorkDir: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("ValidateYAMLComponent() with missing workdir: want error")
+	}
+}
+
+func TestParseCommandErrors(t *testing.T) {
+	tests := []struct {
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{config: map[string]interface{}{"command": 1}, wantErr: "'command' must be a string"},
+		{config: map[string]interface{}{"args": "ls -l"}, wantErr: "'args' must be a list"},
+		{config: map[string]interface{}{"env": []interface{}{"A=1"}}, wantErr: "'env' must be a map"},
+		{config: map[string]interface{}{"timeout": "1 minute"}, wantErr: "invalid 'timeout'"},
+		{config: map[string]interface{}{"expect_exit_code": "zero"}, wantErr: "invalid 'expect_exit_code'"},
+		{config: map[string]interface{}{"expect_stdout": "("}, wantErr: "invalid 'expect_stdout'"},
+	}
+
+	for _, tt := range tests {
+		if _, err := parseCommand(tt.config); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("parseCommand(%v) error = %v, want %q", tt.config, err, tt.wantErr)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		value   interface{}
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: 30, want: 30 * time.Second},
+		{value: 1.5, want: 1500 * time.Millisecond},
+		{value: "2m", want: 2 * time.Minute},
+		{value: "250ms", want: 250 * time.Millisecond},
+		{value: "2", wantErr: true},
+		{value: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseDuration(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseDuration(%v) = %s, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%v) = %s, %v, want %s", tt.value, got, err, tt.want)
+		}
+	}
+}
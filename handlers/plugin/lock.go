@@ -0,0 +1,145 @@
+package plugin
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// LockedPlugin версия плагина, закреплённая в lock-файле
+type LockedPlugin struct {
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Repository   string   `json:"repository"`
+	URL          string   `json:"url"`
+	Hash         string   `json:"hash"`
+	Signature    string   `json:"signature,omitempty"`
+	Dependencies []string `json:"dependencies,omitempty"`
+}
+
+// LockFile содержимое lock-файла плагинов
+type LockFile struct {
+	Plugins []LockedPlugin `json:"plugins"`
+}
+
+// ReadLockFile читает lock-файл; отсутствующий файл - пустой lock
+func ReadLockFile(path string) (*LockFile, error) {
+
+	lock := &LockFile{}
+	if path == "" {
+		return lock, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock file %s: %v", path, err)
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to decode lock file %s: %v", path, err)
+	}
+	return lock, nil
+}
+
+// Get возвращает закреплённую запись плагина
+func (l *LockFile) Get(name string) (LockedPlugin, bool) {
+	for _, locked := range l.Plugins {
+		if locked.Name == name {
+			return locked, true
+		}
+	}
+	return LockedPlugin{}, false
+}
+
+// Put закрепляет версию плагина, заменяя прежнюю запись
+func (l *LockFile) Put(entry Plugin, repository string) {
+
+	locked := LockedPlugin{
+		Name:         entry.Name,
+		Version:      entry.Version,
+		Repository:   repository,
+		URL:          entry.URL,
+		Hash:         entry.Hash,
+		Signature:    entry.Signature,
+		Dependencies: entry.Dependencies,
+	}
+
+	for i := range l.Plugins {
+		if l.Plugins[i].Name == entry.Name {
+			l.Plugins[i] = locked
+			return
+		}
+	}
+	l.Plugins = append(l.Plugins, locked)
+	sort.Slice(l.Plugins, func(i, j int) bool { return l.Plugins[i].Name < l.Plugins[j].Name })
+}
+
+// Write атомарно сохраняет lock-файл
+func (l *LockFile) Write(path string) error {
+
+	if path == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lock file: %v", err)
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create lock file directory: %v", err)
+	}
+	tmpFile, err := os.CreateTemp(dir, ".plugins-*.lock.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create lock file: %v", err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
+
+	_, err = tmpFile.Write(append(data, '\n'))
+	closeErr := tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write lock file: %v", err)
+	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to write lock file: %v", closeErr)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write lock file %s: %v", path, err)
+	}
+	return nil
+}
+
+// Plugin возвращает запись индекса для закреплённой версии
+func (l LockedPlugin) Plugin() Plugin {
+	return Plugin{
+		Name:         l.Name,
+		Version:      l.Version,
+		URL:          l.URL,
+		Hash:         l.Hash,
+		Signature:    l.Signature,
+		Dependencies: l.Dependencies,
+	}
+}
+
+// fileSHA256 считает SHA-256 файла
+func fileSHA256(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, file); err != nil {
+		return nil, err
+	}
+	return hasher.Sum(nil), nil
+}
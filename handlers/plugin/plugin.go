@@ -1,6 +1,9 @@
 package plugin
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,9 +14,13 @@ import (
 	"path/filepath"
 	"plugin"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/local"
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/rpcplugin"
 	v1 "github.com/laplasd/roller-epi/v1"
 )
 
@@ -29,6 +36,16 @@ const (
 
 var (
 	ROOT_INDEX_FILE_NAME = "_index.json"
+	DEFAULT_LOCK_FILE    = "./plugins.lock"
+
+	// PLUGIN_ENV_PREFIX префикс переменных окружения с настройками плагинов без Configure:
+	// ROLLER_PLUGIN_<ИМЯ>_<КЛЮЧ>, например ROLLER_PLUGIN_SSH_PLUGIN_TIMEOUT_MS
+	PLUGIN_ENV_PREFIX = rpcplugin.ENV_PLUGIN_PREFIX
+
+	// BUILTIN_PLUGINS исполнители, доступные без файлов плагинов
+	BUILTIN_PLUGINS = map[string]func() v1.Executor{
+		local.PLUGIN_NAME: local.NewExecutor,
+	}
 )
 
 type Plugin struct {
@@ -37,7 +54,8 @@ type Plugin struct {
 	Description  string   `json:"description"`
 	URL          string   `json:"url"`
 	Dependencies []string `json:"dependencies"`
-	Hash         string   `json:"hash"`
+	Hash         string   `json:"hash"`      // SHA-256 файла плагина в hex
+	Signature    string   `json:"signature"` // URL отсоединённой подписи ed25519 (base64)
 }
 
 type Repo struct {
@@ -58,10 +76,49 @@ type RepoIndex struct {
 type PluginController struct {
 	ControllerVersion      string
 	ExecutorPluginRegistry map[string]v1.Executor
+	PluginFiles            map[string]string // Имя плагина -> путь к его файлу
 	PluginRepositoryMap    map[string]string
+	PluginPath             string
+	LockFile               string              // Файл с закреплёнными версиями установленных плагинов
+	TrustedKeys            []ed25519.PublicKey // Ключи для проверки подписи плагинов
+	AllowUnverified        bool                // Разрешить установку плагинов без 'hash' в индексе
 	LocalRepositoryPath    string
 	RootRepositoryIndex    string
 	DefaultRepository      string
+	PluginConfig           map[string]map[string]interface{}       // Секции 'plugins' из config.yml по имени плагина
+	OnPluginLoad           func(name string, executor v1.Executor) // Вызывается для каждого загруженного или установленного плагина
+
+	registryMu sync.RWMutex // Защищает ExecutorPluginRegistry и PluginFiles при установке плагина во время работы
+}
+
+// InstalledPlugin описывает установленный плагин
+type InstalledPlugin struct {
+	Name        string
+	Version     string
+	Description string
+	File        string
+	Builtin     bool  // Встроенный исполнитель без файла
+	LoadError   error // Ошибка загрузки, если плагин не удалось открыть
+}
+
+// OutputExecutor необязательное расширение v1.Executor: исполнитель возвращает
+// выходные значения шага (например, stdout), доступные следующим шагам через шаблоны
+type OutputExecutor interface {
+	ExecActionOutput(ctx context.Context, component v1.Component, action v1.Action) (map[string]interface{}, error)
+	ExecCheckOutput(ctx context.Context, component v1.Component, check v1.Check) (bool, map[string]interface{}, error)
+}
+
+// ConfigurableExecutor необязательное расширение v1.Executor: исполнитель получает свою секцию
+// 'plugins.<имя>' из config.yml при загрузке. Остальные плагины читают настройки из окружения (PLUGIN_ENV_PREFIX):
+// внепроцессный плагин получает только свои переменные в окружении своего процесса.
+type ConfigurableExecutor interface {
+	Configure(config map[string]interface{}) error
+}
+
+// SchemaExecutor необязательное расширение v1.Executor: исполнитель объявляет поля своей схемы
+// (config компонента, action, check), значения которых нельзя выводить в лог
+type SchemaExecutor interface {
+	SensitiveFields() ([]string, error)
 }
 
 func (pc *PluginController) NewPluginController(pluginsPath string, repoPath string, defaultRepo string) (*PluginController, error) {
@@ -70,6 +127,22 @@ func (pc *PluginController) NewPluginController(pluginsPath string, repoPath str
 		pc = &PluginController{}
 	}
 
+	// Встроенные исполнители регистрируются первыми, плагин с тем же именем их заменяет
+	pc.ExecutorPluginRegistry = make(map[string]v1.Executor)
+	for name, newExecutor := range BUILTIN_PLUGINS {
+		executorInstance := newExecutor()
+		fallback, err := pc.configurePlugin(name, executorInstance)
+		if err != nil {
+			fmt.Printf("WARNING: Ошибка настройки плагина %s: %v\n", name, err)
+			continue
+		}
+		if fallback {
+			pc.setPluginEnv(name)
+		}
+		pc.ExecutorPluginRegistry[name] = executorInstance
+		pc.pluginLoaded(name, executorInstance)
+	}
+
 	executorPluginRegistry, err := pc.loadExecutorPlugins(pluginsPath)
 	if err != nil {
 		return nil, err
@@ -80,7 +153,12 @@ func (pc *PluginController) NewPluginController(pluginsPath string, repoPath str
 	newPC := &PluginController{
 		ControllerVersion:      "0.0.1",
 		ExecutorPluginRegistry: executorPluginRegistry,
+		PluginFiles:            pc.PluginFiles,
 		PluginRepositoryMap:    make(map[string]string),
+		PluginPath:             pluginsPath,
+		PluginConfig:           pc.PluginConfig,
+		OnPluginLoad:           pc.OnPluginLoad,
+		LockFile:               DEFAULT_LOCK_FILE,
 		LocalRepositoryPath:    repoPath,
 		RootRepositoryIndex:    rootIndexPath,
 		DefaultRepository:      defaultRepo,
@@ -113,16 +191,83 @@ func (pc *PluginController) FindExecutorPlugin(data interface{}) (v1.Executor, e
 	return executor, nil
 }
 
-func (pc *PluginController) InstallPlugin(pluginName string) error {
+// InstallPlugin устанавливает плагин вместе с зависимостями.
+// spec - имя плагина или 'name@constraint'; версии, закреплённые в lock-файле, предпочтительны.
+func (pc *PluginController) InstallPlugin(spec string) error {
+	return pc.installSpec(spec, false)
+}
+
+// UpdatePlugin устанавливает самую новую подходящую версию плагина и зависимостей, не глядя на lock-файл
+func (pc *PluginController) UpdatePlugin(spec string) error {
+	return pc.installSpec(spec, true)
+}
+
+func (pc *PluginController) installSpec(spec string, update bool) error {
+
+	lock, err := ReadLockFile(pc.LockFile)
+	if err != nil {
+		return err
+	}
+
+	resolved, err := pc.ResolvePlugin(spec, lock, update)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range resolved {
+		fmt.Printf("INFO: Installing plugin %s@%s\n", entry.Name, entry.Version)
+		if err := pc.installEntry(entry); err != nil {
+			return fmt.Errorf("failed to install plugin %s@%s: %v", entry.Name, entry.Version, err)
+		}
+		lock.Put(entry, pc.DefaultRepository)
+	}
+
+	return lock.Write(pc.LockFile)
+}
+
+// InstallLocked устанавливает все плагины ровно тех версий, что закреплены в lock-файле
+func (pc *PluginController) InstallLocked() error {
 
-	_, _, _, pluginURL, err := pc.SearchPlugin(pluginName, pc.DefaultRepository)
+	lock, err := ReadLockFile(pc.LockFile)
 	if err != nil {
 		return err
 	}
+	if len(lock.Plugins) == 0 {
+		return fmt.Errorf("lock file %s has no plugins", pc.LockFile)
+	}
+
+	for _, locked := range lock.Plugins {
+		fmt.Printf("INFO: Installing plugin %s@%s\n", locked.Name, locked.Version)
+		if err := pc.installEntry(locked.Plugin()); err != nil {
+			return fmt.Errorf("failed to install plugin %s@%s: %v", locked.Name, locked.Version, err)
+		}
+	}
+	return nil
+}
+
+// installEntry загружает, проверяет и устанавливает файл плагина по записи индекса.
+// Если файл уже установлен и совпадает по контрольной сумме, загрузка пропускается.
+func (pc *PluginController) installEntry(entry Plugin) error {
+
+	pluginName := entry.Name
 
-	resp, err := http.Get(pluginURL)
+	pluginDir := pc.PluginPath
+	if pluginDir == "" {
+		pluginDir = "./plugins"
+	}
+	pluginFilePath := filepath.Join(pluginDir, fmt.Sprintf("%s.so", pluginName))
+
+	if sum, err := fileSHA256(pluginFilePath); err == nil && entry.Hash != "" && pc.verifyChecksum(entry, sum) == nil {
+		fmt.Printf("INFO: Plugin %s@%s is already installed\n", entry.Name, entry.Version)
+		if _, loaded := pc.Executor(pluginName); loaded {
+			return nil
+		}
+		return pc.loadInstalledPlugin(entry, pluginFilePath)
+	}
+
+	resp, err := http.Get(entry.URL)
 	if err != nil {
-		return fmt.Errorf("failed to fetch plugin from URL %s: %v", pluginURL, err)
+		return fmt.Errorf("failed to fetch plugin from URL %s: %v", entry.URL, err)
 	}
 	defer resp.Body.Close()
 
@@ -136,47 +281,240 @@ func (pc *PluginController) InstallPlugin(pluginName string) error {
 		return errors.New("downloaded file is not a valid binary plugin")
 	}
 
-	// Создаём директорию ./plugin, если её нет
-	pluginDir := "./plugins"
+	// Создаём каталог плагинов, если его нет
 	if err := os.MkdirAll(pluginDir, os.ModePerm); err != nil {
 		return fmt.Errorf("failed to create plugin directory: %v", err)
 	}
 
-	// Сохраняем плагин в файл
-	pluginFilePath := filepath.Join(pluginDir, fmt.Sprintf("%s.so", pluginName))
-	file, err := os.Create(pluginFilePath)
+	// Загружаем во временный файл того же каталога, чтобы rename был атомарным
+	tmpFile, err := os.CreateTemp(pluginDir, fmt.Sprintf(".%s-*.so.tmp", pluginName))
 	if err != nil {
 		return fmt.Errorf("failed to create plugin file: %v", err)
 	}
-	defer file.Close()
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
 
-	_, err = io.Copy(file, resp.Body)
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmpFile, hasher), resp.Body)
+	closeErr := tmpFile.Close()
 	if err != nil {
 		return fmt.Errorf("failed to save plugin: %v", err)
 	}
+	if closeErr != nil {
+		return fmt.Errorf("failed to save plugin: %v", closeErr)
+	}
 
 	// Проверяем размер файла
-	info, err := file.Stat()
-	if err != nil || info.Size() == 0 {
+	if size == 0 {
 		return errors.New("downloaded plugin is empty or corrupted")
 	}
 
+	// Проверяем контрольную сумму и подпись до появления файла в каталоге плагинов
+	if err := pc.verifyChecksum(entry, hasher.Sum(nil)); err != nil {
+		return err
+	}
+	if err := pc.verifySignature(entry, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, pluginFilePath); err != nil {
+		return fmt.Errorf("failed to install plugin file %s: %v", pluginFilePath, err)
+	}
+
+	return pc.loadInstalledPlugin(entry, pluginFilePath)
+}
+
+// loadInstalledPlugin загружает установленный файл плагина в реестр тем же путём, что и при запуске,
+// проверяя, что файл предоставляет плагин из записи индекса
+func (pc *PluginController) loadInstalledPlugin(entry Plugin, pluginFilePath string) error {
+
+	if _, err := pc.loadExecutorPlugin(pluginFilePath, entry.Name); err != nil {
+		return fmt.Errorf("plugin %s is installed but not loaded: %v", entry.Name, err)
+	}
 	return nil
 }
 
+// DeletePlugin удаляет файл плагина по имени из реестра или по имени файла (без '.so')
 func (pc *PluginController) DeletePlugin(pluginName string) error {
 
+	// Реестр может читаться во время работы: поиск, остановка и удаление выполняются под одной блокировкой
+	pc.registryMu.Lock()
+	defer pc.registryMu.Unlock()
+
+	installed, err := pc.GetPluginInfo(pluginName)
+	if err != nil {
+		return err
+	}
+	if installed.Builtin {
+		return fmt.Errorf("plugin %s is built in and cannot be deleted", pluginName)
+	}
+
+	// Процесс внепроцессного плагина останавливается до удаления файла
+	if closer, ok := pc.ExecutorPluginRegistry[installed.Name].(io.Closer); ok && installed.Name != "" {
+		closer.Close()
+	}
+
+	if err := os.Remove(installed.File); err != nil {
+		return fmt.Errorf("failed to delete plugin file %s: %v", installed.File, err)
+	}
+
+	if installed.Name != "" {
+		delete(pc.ExecutorPluginRegistry, installed.Name)
+		delete(pc.PluginFiles, installed.Name)
+	}
 	return nil
 }
 
+// ListPlugins возвращает все файлы '.so' и исполняемые плагины каталога плагинов с информацией из GetInfo
+func (pc *PluginController) ListPlugins() ([]InstalledPlugin, error) {
+
+	entries, err := os.ReadDir(pc.PluginPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list plugins in %s: %v", pc.PluginPath, err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		file := filepath.Join(pc.PluginPath, entry.Name())
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if (strings.HasSuffix(entry.Name(), ".so") && !info.IsDir()) || rpcplugin.IsPluginExecutable(file, info) {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	var plugins []InstalledPlugin
+	for _, name := range pc.builtinPlugins() {
+		plugins = append(plugins, pc.describeBuiltinPlugin(name))
+	}
+	for _, file := range files {
+		plugins = append(plugins, pc.describePluginFile(file))
+	}
+	return plugins, nil
+}
+
+// GetPluginInfo ищет установленный плагин по имени из реестра или по имени файла (без '.so')
+func (pc *PluginController) GetPluginInfo(pluginName string) (InstalledPlugin, error) {
+
+	if file, ok := pc.PluginFiles[pluginName]; ok {
+		return pc.describePluginFile(file), nil
+	}
+	for _, name := range pc.builtinPlugins() {
+		if name == pluginName {
+			return pc.describeBuiltinPlugin(name), nil
+		}
+	}
+
+	for _, file := range []string{filepath.Join(pc.PluginPath, fmt.Sprintf("%s.so", pluginName)), filepath.Join(pc.PluginPath, pluginName)} {
+		if info, err := os.Stat(file); err == nil && !info.IsDir() {
+			return pc.describePluginFile(file), nil
+		}
+	}
+	return InstalledPlugin{}, fmt.Errorf("plugin %s is not installed in %s", pluginName, pc.PluginPath)
+}
+
+// builtinPlugins возвращает имена встроенных исполнителей, не заменённых плагинами
+func (pc *PluginController) builtinPlugins() []string {
+	var names []string
+	for name := range BUILTIN_PLUGINS {
+		if _, replaced := pc.PluginFiles[name]; !replaced && pc.ExecutorPluginRegistry[name] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (pc *PluginController) describeBuiltinPlugin(name string) InstalledPlugin {
+	installed := InstalledPlugin{Name: name, Builtin: true}
+	info, err := pc.ExecutorPluginRegistry[name].GetInfo()
+	if err != nil {
+		installed.LoadError = err
+		return installed
+	}
+	installed.Version = info.Version
+	installed.Description = info.Description
+	return installed
+}
+
+// describePluginFile возвращает информацию о плагине по пути к его файлу
+func (pc *PluginController) describePluginFile(file string) InstalledPlugin {
+
+	for name, path := range pc.PluginFiles {
+		if path != file {
+			continue
+		}
+		installed := InstalledPlugin{Name: name, File: file}
+		info, err := pc.ExecutorPluginRegistry[name].GetInfo()
+		if err != nil {
+			installed.LoadError = err
+			return installed
+		}
+		installed.Version = info.Version
+		installed.Description = info.Description
+		return installed
+	}
+
+	return InstalledPlugin{File: file, LoadError: errors.New("plugin is not loaded")}
+}
+
 func (pc *PluginController) SearchPlugin(pluginName string, repository string) (string, string, string, string, error) {
 
+	found, err := pc.FindPlugin(pluginName, repository)
+	if err != nil {
+		return "", "", "", "", err
+	}
+	return found.Name, found.Version, found.Description, found.URL, nil
+}
+
+// FindPlugin ищет запись плагина в индексе репозитория
+func (pc *PluginController) FindPlugin(pluginName string, repository string) (Plugin, error) {
+
 	fmt.Printf("PluginController: Searching for plugin: %s\n", pluginName)
 
+	indexFile, err := pc.localIndexFile(repository)
+	if err != nil {
+		return Plugin{}, err
+	}
+	return findInLocalIndex(indexFile, pluginName)
+}
+
+// FindPluginVersions возвращает все записи плагина из индекса репозитория
+func (pc *PluginController) FindPluginVersions(pluginName string, repository string) ([]Plugin, error) {
+
+	indexFile, err := pc.localIndexFile(repository)
+	if err != nil {
+		return nil, err
+	}
+
+	index, err := readLocalIndex(indexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []Plugin
+	for _, plugin := range index.Plugins {
+		if plugin.Name == pluginName {
+			versions = append(versions, plugin)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, fmt.Errorf("plugin %s not found in index.json", pluginName)
+	}
+	return versions, nil
+}
+
+// localIndexFile возвращает путь к актуальному локальному индексу репозитория,
+// при необходимости загружая его заново
+func (pc *PluginController) localIndexFile(repository string) (string, error) {
+
 	// Убедимся, что каталог для кэша существует
 
 	if err := os.MkdirAll(pc.LocalRepositoryPath, os.ModePerm); err != nil {
-		return "", "", "", "", fmt.Errorf("failed to create local cache directory: %v", err)
+		return "", fmt.Errorf("failed to create local cache directory: %v", err)
 	}
 
 	fmt.Printf("PluginController: Check %s\n", pc.RootRepositoryIndex)
@@ -186,7 +524,7 @@ func (pc *PluginController) SearchPlugin(pluginName string, repository string) (
 
 		file, err := os.Open(pc.RootRepositoryIndex)
 		if err != nil {
-			return "", "", "", "", fmt.Errorf("failed to open local '_index.json': %v", err)
+			return "", fmt.Errorf("failed to open local '_index.json': %v", err)
 		}
 		defer file.Close()
 
@@ -194,7 +532,7 @@ func (pc *PluginController) SearchPlugin(pluginName string, repository string) (
 		var repoIndex RepoIndex
 		decoder := json.NewDecoder(file)
 		if err := decoder.Decode(&repoIndex); err != nil {
-			return "", "", "", "", fmt.Errorf("failed to decode local '_index.json': %v", err)
+			return "", fmt.Errorf("failed to decode local '_index.json': %v", err)
 		}
 
 		// Поиск репозитория
@@ -206,34 +544,32 @@ func (pc *PluginController) SearchPlugin(pluginName string, repository string) (
 				if err == nil {
 					// Если файл существует и моложе 5 минут, используем его
 					if time.Since(fileInfo.ModTime()) <= 5*time.Minute {
-						fmt.Println("INFO: Using cached %s", Repo.LocalIndexFile)
-						return searchInLocalIndex(Repo.LocalIndexFile, pluginName)
+						fmt.Printf("INFO: Using cached %s\n", Repo.LocalIndexFile)
+						return Repo.LocalIndexFile, nil
 					}
 					// Если файл старше 5 минут, удаляем его
 					fmt.Println("INFO: Cached index.json is outdated, downloading a new version...")
 					err := pc.UpdateRepoFile(Repo.Name)
 					if err != nil {
-						return "", "", "", "", err
+						return "", err
 					}
 
-					// Выполняем поиск в загруженном файле
-					return searchInLocalIndex(Repo.LocalIndexFile, pluginName)
+					return Repo.LocalIndexFile, nil
 
 				} else {
 					// Скачиваем свежую версию index.json
 					if err := pc.downloadIndexFile(Repo.URL, repository); err != nil {
-						return "", "", "", "", err
+						return "", err
 					}
-					// Выполняем поиск в загруженном файле
-					return searchInLocalIndex(Repo.LocalIndexFile, pluginName)
+					return Repo.LocalIndexFile, nil
 				}
 
 			}
 		}
-
+		return "", fmt.Errorf("repository %s not found in %s", repository, pc.RootRepositoryIndex)
 	}
 	// Если произошла ошибка при доступе к файлу, кроме его отсутствия
-	return "", "", "", "", fmt.Errorf("failed to access local _index.json: %v", statErr)
+	return "", fmt.Errorf("failed to access local _index.json: %v", statErr)
 }
 
 func (pc *PluginController) AddRepo(repoJsonURL string) error {
@@ -362,6 +698,9 @@ func (pc *PluginController) loadExecutorPlugins(pluginsPath string) (map[string]
 	if pc.ExecutorPluginRegistry == nil {
 		pc.ExecutorPluginRegistry = make(map[string]v1.Executor)
 	}
+	if pc.PluginFiles == nil {
+		pc.PluginFiles = make(map[string]string)
+	}
 
 	err := filepath.Walk(pluginsPath, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -369,55 +708,261 @@ func (pc *PluginController) loadExecutorPlugins(pluginsPath string) (map[string]
 			return nil
 		}
 
+		// Исполняемые файлы - внепроцессные плагины
+		if rpcplugin.IsPluginExecutable(path, info) {
+			pc.loadRPCPlugin(path)
+			return nil
+		}
+
 		// Пропускаем директории и файлы, не оканчивающиеся на ".so"
 		if info.IsDir() || !strings.HasSuffix(info.Name(), ".so") {
 			return nil
 		}
 
-		// Загружаем плагин
-		p, err := plugin.Open(path)
-		if err != nil {
-			fmt.Printf("WARNING: Ошибка загрузки плагина %s: %v\n", path, err)
-			return nil
+		if _, err := pc.loadExecutorPlugin(path, ""); err != nil {
+			fmt.Printf("WARNING: %v\n", err)
 		}
+		return nil
+	})
+
+	// Если произошли ошибки обхода, логируем их, но возвращаем реестр
+	if err != nil {
+		fmt.Printf("WARNING: Ошибки при обходе плагинов в директории %s: %v\n", pluginsPath, err)
+	}
+
+	return pc.ExecutorPluginRegistry, nil
+}
+
+// loadExecutorPlugin загружает плагин '.so', передаёт ему настройки и добавляет в реестр.
+// Используется при запуске и после установки плагина из репозитория; если expectedName
+// не пуст, плагин с другим именем не регистрируется.
+func (pc *PluginController) loadExecutorPlugin(path string, expectedName string) (v1.PluginInfo, error) {
+
+	// Загружаем плагин
+	p, err := plugin.Open(path)
+	if err != nil {
+		return v1.PluginInfo{}, fmt.Errorf("Ошибка загрузки плагина %s: %v", path, err)
+	}
+
+	// Ищем функцию NewExecutor
+	symbol, err := p.Lookup("NewExecutor")
+	if err != nil {
+		return v1.PluginInfo{}, fmt.Errorf("Функция NewExecutor не найдена в плагине %s: %v", path, err)
+	}
+
+	// Преобразуем символ в функцию
+	newExecutorFunc, ok := symbol.(func() v1.Executor)
+	if !ok {
+		return v1.PluginInfo{}, fmt.Errorf("NewExecutor в плагине %s не соответствует интерфейсу Executor", path)
+	}
+
+	// Создаем экземпляр плагина
+	executorInstance := newExecutorFunc()
+
+	// Получаем информацию о плагине
+	pluginInfo, err := executorInstance.GetInfo()
+	if err != nil {
+		return v1.PluginInfo{}, fmt.Errorf("Ошибка получения информации о плагине %s: %v", path, err)
+	}
+	if expectedName != "" && pluginInfo.Name != expectedName {
+		return pluginInfo, fmt.Errorf("Плагин %s предоставляет '%s', ожидался '%s'", path, pluginInfo.Name, expectedName)
+	}
+
+	// Передаём плагину его секцию настроек; без Configure плагин читает её из окружения
+	fallback, err := pc.configurePlugin(pluginInfo.Name, executorInstance)
+	if err != nil {
+		return pluginInfo, fmt.Errorf("Ошибка настройки плагина %s: %v", path, err)
+	}
+	if fallback {
+		pc.setPluginEnv(pluginInfo.Name)
+	}
+
+	// Добавляем плагин в реестр
+	pc.registerPlugin(pluginInfo.Name, path, executorInstance)
+	fmt.Printf("Плагин %s успешно загружен.\n", pluginInfo.Name)
+
+	return pluginInfo, nil
+}
+
+// registerPlugin добавляет исполнителя в реестр под блокировкой: плагин может быть
+// установлен во время проверки миграции, когда реестр уже читают
+func (pc *PluginController) registerPlugin(name string, path string, executor v1.Executor) {
+	pc.registryMu.Lock()
+	if pc.ExecutorPluginRegistry == nil {
+		pc.ExecutorPluginRegistry = make(map[string]v1.Executor)
+	}
+	if pc.PluginFiles == nil {
+		pc.PluginFiles = make(map[string]string)
+	}
+	pc.ExecutorPluginRegistry[name] = executor
+	pc.PluginFiles[name] = path
+	pc.registryMu.Unlock()
+
+	pc.pluginLoaded(name, executor)
+}
 
-		// Ищем функцию NewExecutor
-		symbol, err := p.Lookup("NewExecutor")
+// pluginLoaded сообщает OnPluginLoad о новом исполнителе; вызывается без блокировки реестра
+func (pc *PluginController) pluginLoaded(name string, executor v1.Executor) {
+	if pc.OnPluginLoad != nil {
+		pc.OnPluginLoad(name, executor)
+	}
+}
+
+// Executor возвращает исполнителя из реестра по типу плагина
+func (pc *PluginController) Executor(name string) (v1.Executor, bool) {
+	pc.registryMu.RLock()
+	defer pc.registryMu.RUnlock()
+
+	executor, ok := pc.ExecutorPluginRegistry[name]
+	return executor, ok && executor != nil
+}
+
+// loadRPCPlugin запускает внепроцессный плагин и регистрирует его рядом с плагинами '.so'
+func (pc *PluginController) loadRPCPlugin(path string) {
+
+	executorInstance, err := rpcplugin.Start(path)
+	if err != nil {
+		fmt.Printf("WARNING: Ошибка запуска плагина %s: %v\n", path, err)
+		return
+	}
+
+	pluginInfo, err := executorInstance.GetInfo()
+	if err != nil {
+		fmt.Printf("WARNING: Ошибка получения информации о плагине %s: %v\n", path, err)
+		executorInstance.Close()
+		return
+	}
+
+	if _, exists := pc.PluginFiles[pluginInfo.Name]; exists {
+		fmt.Printf("WARNING: Плагин %s уже загружен, %s пропущен\n", pluginInfo.Name, path)
+		executorInstance.Close()
+		return
+	}
+
+	fallback, err := pc.configurePlugin(pluginInfo.Name, executorInstance)
+	if err != nil {
+		fmt.Printf("WARNING: Ошибка настройки плагина %s: %v\n", path, err)
+		executorInstance.Close()
+		return
+	}
+
+	// Плагин без Configure перезапускается с переменными своей секции в окружении процесса
+	if fallback {
+		executorInstance.Close()
+		executorInstance, err = rpcplugin.Start(path, pc.pluginEnv(pluginInfo.Name)...)
 		if err != nil {
-			fmt.Printf("WARNING: Функция NewExecutor не найдена в плагине %s: %v\n", path, err)
-			return nil
+			fmt.Printf("WARNING: Ошибка запуска плагина %s: %v\n", path, err)
+			return
 		}
+	}
 
-		// Преобразуем символ в функцию
-		newExecutorFunc, ok := symbol.(func() v1.Executor)
-		if !ok {
-			fmt.Printf("WARNING: NewExecutor в плагине %s не соответствует интерфейсу Executor\n", path)
-			return nil
+	pc.registerPlugin(pluginInfo.Name, path, executorInstance)
+	fmt.Printf("Плагин %s успешно запущен.\n", pluginInfo.Name)
+}
+
+// configurePlugin передаёт исполнителю его секцию PluginConfig, если она есть и исполнитель поддерживает Configure.
+// fallback сообщает, что секция есть, а Configure нет: настройки передаются через окружение.
+func (pc *PluginController) configurePlugin(name string, executor v1.Executor) (fallback bool, err error) {
+
+	config, ok := pc.PluginConfig[name]
+	if !ok {
+		return false, nil
+	}
+	configurable, ok := executor.(ConfigurableExecutor)
+	if !ok {
+		return true, nil
+	}
+	err = configurable.Configure(config)
+	if errors.Is(err, rpcplugin.ErrNotImplemented) {
+		return true, nil
+	}
+	return false, err
+}
+
+// pluginEnv возвращает переменные PLUGIN_ENV_PREFIX<ИМЯ>_<КЛЮЧ> секции плагина name
+// в виде "ИМЯ=значение". Карты и списки передаются в JSON.
+func (pc *PluginController) pluginEnv(name string) []string {
+
+	config := pc.PluginConfig[name]
+	keys := make([]string, 0, len(config))
+	for key := range config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	env := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value := config[key]
+		envValue := fmt.Sprint(value)
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			if data, err := json.Marshal(value); err == nil {
+				envValue = string(data)
+			}
 		}
+		env = append(env, PluginEnvName(name, key)+"="+envValue)
+	}
+	return env
+}
 
-		// Создаем экземпляр плагина
-		executorInstance := newExecutorFunc()
+// setPluginEnv задаёт переменные секции плагина name в окружении RoLLeR. Используется только
+// для плагинов '.so' без Configure: они работают в процессе RoLLeR. Дочерним процессам
+// (командам local и внепроцессным плагинам) переменные PLUGIN_ENV_PREFIX не передаются.
+func (pc *PluginController) setPluginEnv(name string) {
+	for _, variable := range pc.pluginEnv(name) {
+		key, value, _ := strings.Cut(variable, "=")
+		if err := os.Setenv(key, value); err != nil {
+			fmt.Printf("WARNING: Ошибка передачи настройки плагина %s: %v\n", name, err)
+		}
+	}
+}
 
-		// Получаем информацию о плагине
-		pluginInfo, err := executorInstance.GetInfo()
-		if err != nil {
-			fmt.Printf("WARNING: Ошибка получения информации о плагине %s: %v\n", path, err)
-			return nil
+// PluginEnvName возвращает имя переменной окружения для настройки key плагина pluginName
+func PluginEnvName(pluginName string, key string) string {
+	name := strings.ToUpper(pluginName + "_" + key)
+	return PLUGIN_ENV_PREFIX + strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
 		}
+		return '_'
+	}, name)
+}
 
-		// Добавляем плагин в реестр
-		pc.ExecutorPluginRegistry[pluginInfo.Name] = executorInstance
-		fmt.Printf("Плагин %s успешно загружен.\n", pluginInfo.Name)
+// SensitiveFields возвращает чувствительные поля, объявленные загруженными плагинами
+func (pc *PluginController) SensitiveFields() []string {
+	pc.registryMu.RLock()
+	defer pc.registryMu.RUnlock()
 
-		return nil
-	})
+	var fields []string
+	for _, executor := range pc.ExecutorPluginRegistry {
+		fields = append(fields, ExecutorSensitiveFields(executor)...)
+	}
+	return fields
+}
 
-	// Если произошли ошибки обхода, логируем их, но возвращаем реестр
+// ExecutorSensitiveFields возвращает чувствительные поля, объявленные исполнителем (SchemaExecutor)
+func ExecutorSensitiveFields(executor v1.Executor) []string {
+	schema, ok := executor.(SchemaExecutor)
+	if !ok {
+		return nil
+	}
+	// Плагины старых версий не поддерживают вызов: поля не объявлены
+	fields, err := schema.SensitiveFields()
 	if err != nil {
-		fmt.Printf("WARNING: Ошибки при обходе плагинов в директории %s: %v\n", pluginsPath, err)
+		return nil
 	}
+	return fields
+}
 
-	return pc.ExecutorPluginRegistry, nil
+// Close завершает процессы внепроцессных плагинов
+func (pc *PluginController) Close() {
+	for name, executor := range pc.ExecutorPluginRegistry {
+		if closer, ok := executor.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				fmt.Printf("WARNING: Ошибка остановки плагина %s: %v\n", name, err)
+			}
+		}
+	}
 }
 
 func (pc *PluginController) createAndCheckDir(dir string) error {
@@ -458,18 +1003,27 @@ func (pc *PluginController) downloadIndexFile(indexFileURL string, name string)
 	return nil
 }
 
-// searchInLocalIndex выполняет поиск плагина в локальном файле index.json
-func searchInLocalIndex(localIndexPath, pluginName string) (string, string, string, string, error) {
+// readLocalIndex читает локальный файл index.json
+func readLocalIndex(localIndexPath string) (Index, error) {
 	file, err := os.Open(localIndexPath)
 	if err != nil {
-		return "", "", "", "", fmt.Errorf("failed to open local index.json: %v", err)
+		return Index{}, fmt.Errorf("failed to open local index.json: %v", err)
 	}
 	defer file.Close()
 
 	var index Index
 	decoder := json.NewDecoder(file)
 	if err := decoder.Decode(&index); err != nil {
-		return "", "", "", "", fmt.Errorf("failed to decode local index.json: %v", err)
+		return Index{}, fmt.Errorf("failed to decode local index.json: %v", err)
+	}
+	return index, nil
+}
+
+// findInLocalIndex выполняет поиск плагина в локальном файле index.json
+func findInLocalIndex(localIndexPath, pluginName string) (Plugin, error) {
+	index, err := readLocalIndex(localIndexPath)
+	if err != nil {
+		return Plugin{}, err
 	}
 
 	// Поиск плагина
@@ -486,11 +1040,11 @@ func searchInLocalIndex(localIndexPath, pluginName string) (string, string, stri
 			} else {
 				fmt.Printf("  Dependencies: None\n")
 			}
-			return plugin.Name, plugin.Version, plugin.Description, plugin.URL, nil
+			return plugin, nil
 		}
 	}
 
-	return "", "", "", "", errors.New("plugin not found in index.json")
+	return Plugin{}, errors.New("plugin not found in index.json")
 }
 
 // validateAndFixURL проверяет URL и исправляет его при необходимости
@@ -0,0 +1,87 @@
+package plugin
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/local"
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+func TestOnPluginLoad(t *testing.T) {
+	var loaded []string
+	var fields []string
+	pc := &PluginController{OnPluginLoad: func(name string, executor v1.Executor) {
+		loaded = append(loaded, name)
+		fields = append(fields, ExecutorSensitiveFields(executor)...)
+	}}
+
+	// Встроенные исполнители сообщаются при создании контроллера
+	pc, err := pc.NewPluginController(t.TempDir(), t.TempDir(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"local"}; !reflect.DeepEqual(loaded, want) || !reflect.DeepEqual(fields, []string{"env"}) {
+		t.Fatalf("loaded = %v, fields = %v, want %v and [env]", loaded, fields, want)
+	}
+
+	// Плагин, установленный во время работы, сообщается при регистрации
+	pc.registerPlugin("installed", "/plugins/installed.so", local.NewExecutor())
+	sort.Strings(loaded)
+	if want := []string{"installed", "local"}; !reflect.DeepEqual(loaded, want) {
+		t.Fatalf("loaded = %v, want %v", loaded, want)
+	}
+	if executor, ok := pc.Executor("installed"); !ok || executor == nil {
+		t.Fatal("installed plugin is not in the registry")
+	}
+}
+
+// closingExecutor исполнитель внепроцессного плагина для тестов: считает вызовы Close
+type closingExecutor struct {
+	v1.Executor
+	closed int
+}
+
+func (e *closingExecutor) Close() error {
+	e.closed++
+	return nil
+}
+
+func TestDeletePlugin(t *testing.T) {
+	pc := &PluginController{PluginPath: t.TempDir()}
+	file := filepath.Join(pc.PluginPath, "rpc")
+	if err := os.WriteFile(file, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	executor := &closingExecutor{Executor: local.NewExecutor()}
+	pc.registerPlugin("rpc", file, executor)
+
+	// Реестр читается параллельно с удалением плагина
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			pc.Executor("rpc")
+		}
+	}()
+	if err := pc.DeletePlugin("rpc"); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if executor.closed != 1 {
+		t.Errorf("Close() called %d times, want 1", executor.closed)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("plugin file exists: %v", err)
+	}
+	if _, ok := pc.Executor("rpc"); ok {
+		t.Error("deleted plugin is in the registry")
+	}
+	if err := pc.DeletePlugin("rpc"); err == nil {
+		t.Error("DeletePlugin() of deleted plugin: want error")
+	}
+}
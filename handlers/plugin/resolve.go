@@ -0,0 +1,143 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
+)
+
+// ParsePluginSpec разбирает 'name' или 'name@constraint'
+func ParsePluginSpec(spec string) (string, semver.Constraint, error) {
+
+	name, rawConstraint, _ := strings.Cut(strings.TrimSpace(spec), "@")
+	if name == "" {
+		return "", semver.Constraint{}, fmt.Errorf("invalid plugin spec '%s': empty name", spec)
+	}
+
+	constraint, err := semver.ParseConstraint(rawConstraint)
+	if err != nil {
+		return "", semver.Constraint{}, fmt.Errorf("invalid plugin spec '%s': %v", spec, err)
+	}
+	return name, constraint, nil
+}
+
+// resolver подбирает версии плагина и его зависимостей
+type resolver struct {
+	pc           *PluginController
+	lock         *LockFile
+	update       bool
+	selected     map[string]Plugin
+	requirements map[string][]string // Имя плагина -> кем и с каким ограничением он запрошен
+	order        []Plugin
+}
+
+// ResolvePlugin возвращает плагин и все его зависимости в порядке установки (зависимости первыми).
+// Для каждого плагина выбирается самая новая версия индекса, удовлетворяющая ограничению;
+// если update не задан, предпочитается версия из lock-файла.
+func (pc *PluginController) ResolvePlugin(spec string, lock *LockFile, update bool) ([]Plugin, error) {
+
+	name, constraint, err := ParsePluginSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	if lock == nil {
+		lock = &LockFile{}
+	}
+	r := &resolver{
+		pc:           pc,
+		lock:         lock,
+		update:       update,
+		selected:     make(map[string]Plugin),
+		requirements: make(map[string][]string),
+	}
+	if err := r.resolve(name, constraint, "command line"); err != nil {
+		return nil, err
+	}
+	return r.order, nil
+}
+
+func (r *resolver) resolve(name string, constraint semver.Constraint, requiredBy string) error {
+
+	r.requirements[name] = append(r.requirements[name], fmt.Sprintf("%s requires %s@%s", requiredBy, name, constraint))
+
+	// Плагин уже выбран: новое ограничение должно быть совместимо с выбранной версией
+	if selected, ok := r.selected[name]; ok {
+		version, err := semver.Parse(selected.Version)
+		if err != nil || !constraint.Check(version) {
+			return fmt.Errorf("dependency conflict: %s@%s is selected, but %s", name, selected.Version, strings.Join(r.requirements[name], "; "))
+		}
+		return nil
+	}
+
+	entry, err := r.choose(name, constraint)
+	if err != nil {
+		return err
+	}
+	r.selected[name] = entry
+
+	for _, dependency := range entry.Dependencies {
+		depName, depConstraint, err := ParsePluginSpec(dependency)
+		if err != nil {
+			return fmt.Errorf("plugin %s@%s: %v", entry.Name, entry.Version, err)
+		}
+		if err := r.resolve(depName, depConstraint, fmt.Sprintf("%s@%s", entry.Name, entry.Version)); err != nil {
+			return err
+		}
+	}
+
+	r.order = append(r.order, entry)
+	return nil
+}
+
+// choose выбирает версию плагина из индекса
+func (r *resolver) choose(name string, constraint semver.Constraint) (Plugin, error) {
+
+	entries, err := r.pc.FindPluginVersions(name, r.pc.DefaultRepository)
+	if err != nil {
+		return Plugin{}, err
+	}
+
+	type candidate struct {
+		entry   Plugin
+		version semver.Version
+	}
+	var candidates []candidate
+	for _, entry := range entries {
+		version, err := semver.Parse(entry.Version)
+		if err != nil {
+			fmt.Printf("WARNING: Skip %s: %v\n", entry.Name, err)
+			continue
+		}
+		if constraint.Check(version) {
+			candidates = append(candidates, candidate{entry: entry, version: version})
+		}
+	}
+	if len(candidates) == 0 {
+		return Plugin{}, fmt.Errorf("no version of plugin %s matches %s", name, constraint)
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return semver.Compare(candidates[i].version, candidates[j].version) > 0
+	})
+
+	// Закреплённая версия используется, пока она удовлетворяет ограничению
+	if locked, ok := r.lock.Get(name); ok && !r.update {
+		lockedVersion, err := semver.Parse(locked.Version)
+		if err != nil {
+			return Plugin{}, fmt.Errorf("lock file: plugin %s: %v", name, err)
+		}
+		for _, c := range candidates {
+			if semver.Compare(c.version, lockedVersion) == 0 {
+				if locked.Hash != "" && !strings.EqualFold(strings.TrimPrefix(c.entry.Hash, "sha256:"), strings.TrimPrefix(locked.Hash, "sha256:")) {
+					return Plugin{}, fmt.Errorf("plugin %s@%s hash in index differs from lock file", name, locked.Version)
+				}
+				return c.entry, nil
+			}
+		}
+		fmt.Printf("INFO: Locked version %s@%s does not match %s, selecting a new one\n", name, locked.Version, constraint)
+	}
+
+	return candidates[0].entry, nil
+}
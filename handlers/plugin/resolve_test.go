@@ -0,0 +1,142 @@
+package plugin
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testRepository = "TestHub"
+
+// testIndex индекс репозитория для проверки выбора версий:
+// d зависит от b и c, которые требуют несовместимые версии a
+var testIndex = []Plugin{
+	{Name: "a", Version: "1.0.0", Hash: "sha256:a100"},
+	{Name: "a", Version: "1.1.0", Hash: "sha256:a110"},
+	{Name: "a", Version: "2.0.0-rc.1", Hash: "sha256:a200rc1"},
+	{Name: "a", Version: "2.0.0", Hash: "sha256:a200"},
+	{Name: "b", Version: "1.0.0", Dependencies: []string{"a@^1.0.0"}},
+	{Name: "c", Version: "1.0.0", Dependencies: []string{"a@>= 2.0.0"}},
+	{Name: "d", Version: "1.0.0", Dependencies: []string{"b", "c"}},
+	{Name: "e", Version: "1.0.0", Dependencies: []string{"b@>=1.0.0", "a@~1.1.0"}},
+	{Name: "f", Version: "1.0.0", Dependencies: []string{"a@>=bad"}},
+}
+
+// newTestController создаёт контроллер с локальным индексом testIndex
+func newTestController(t *testing.T) *PluginController {
+	t.Helper()
+
+	dir := t.TempDir()
+	indexFile := filepath.Join(dir, testRepository+".json")
+	writeJSON(t, indexFile, Index{Plugins: testIndex})
+
+	rootIndex := filepath.Join(dir, ROOT_INDEX_FILE_NAME)
+	writeJSON(t, rootIndex, RepoIndex{Repos: []Repo{{Name: testRepository, LocalIndexFile: indexFile}}})
+
+	return &PluginController{
+		LocalRepositoryPath: dir,
+		RootRepositoryIndex: rootIndex,
+		DefaultRepository:   testRepository,
+	}
+}
+
+func writeJSON(t *testing.T, path string, value interface{}) {
+	t.Helper()
+	data, err := json.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResolvePlugin(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		lock    []LockedPlugin
+		update  bool
+		want    []string
+		wantErr string
+	}{
+		{name: "latest", spec: "a", want: []string{"a@2.0.0"}},
+		{name: "caret", spec: "a@^1.0.0", want: []string{"a@1.1.0"}},
+		{name: "tilde", spec: "a@~1.0.0", want: []string{"a@1.0.0"}},
+		{name: "range with spaces", spec: "a@>= 1.0.0, < 1.1.0", want: []string{"a@1.0.0"}},
+		{name: "prerelease", spec: "a@>=2.0.0-rc.1 <2.0.0", want: []string{"a@2.0.0-rc.1"}},
+		{name: "dependencies first", spec: "b", want: []string{"a@1.1.0", "b@1.0.0"}},
+		{name: "shared dependency", spec: "e", want: []string{"a@1.1.0", "b@1.0.0", "e@1.0.0"}},
+		{name: "no matching version", spec: "a@>=3.0.0", wantErr: "no version of plugin a matches"},
+		{name: "unknown plugin", spec: "zz", wantErr: "plugin zz not found"},
+		{name: "invalid spec", spec: "@1.0.0", wantErr: "empty name"},
+		{name: "invalid dependency", spec: "f", wantErr: "plugin f@1.0.0"},
+		{name: "conflict", spec: "d", wantErr: "dependency conflict: a@1.1.0 is selected, but b@1.0.0 requires a@^1.0.0; c@1.0.0 requires a@>= 2.0.0"},
+
+		// Закреплённая версия используется, пока удовлетворяет ограничению
+		{
+			name: "locked",
+			spec: "a",
+			lock: []LockedPlugin{{Name: "a", Version: "1.0.0", Hash: "sha256:a100"}},
+			want: []string{"a@1.0.0"},
+		},
+		{
+			name: "locked dependency",
+			spec: "b",
+			lock: []LockedPlugin{{Name: "a", Version: "1.0.0"}},
+			want: []string{"a@1.0.0", "b@1.0.0"},
+		},
+		{
+			name:   "locked with update",
+			spec:   "a@^1.0.0",
+			lock:   []LockedPlugin{{Name: "a", Version: "1.0.0"}},
+			update: true,
+			want:   []string{"a@1.1.0"},
+		},
+		{
+			name: "locked outside constraint",
+			spec: "a@>=1.1.0",
+			lock: []LockedPlugin{{Name: "a", Version: "1.0.0"}},
+			want: []string{"a@2.0.0"},
+		},
+		{
+			name:    "locked hash differs",
+			spec:    "a",
+			lock:    []LockedPlugin{{Name: "a", Version: "1.0.0", Hash: "sha256:other"}},
+			wantErr: "hash in index differs from lock file",
+		},
+		{
+			name:    "invalid locked version",
+			spec:    "a",
+			lock:    []LockedPlugin{{Name: "a", Version: "bad"}},
+			wantErr: "lock file: plugin a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pc := newTestController(t)
+
+			resolved, err := pc.ResolvePlugin(tt.spec, &LockFile{Plugins: tt.lock}, tt.update)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ResolvePlugin(%q) error = %v, want %q", tt.spec, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ResolvePlugin(%q) error: %v", tt.spec, err)
+			}
+
+			var got []string
+			for _, entry := range resolved {
+				got = append(got, entry.Name+"@"+entry.Version)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("ResolvePlugin(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package plugin
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// SetVerification задаёт доверенные ключи подписи и политику для плагинов без контрольной суммы.
+// Ключ - публичный ключ ed25519 в base64 или путь к файлу с ним.
+func (pc *PluginController) SetVerification(trustedKeys []string, allowUnverified bool) error {
+
+	keys := make([]ed25519.PublicKey, 0, len(trustedKeys))
+	for _, trustedKey := range trustedKeys {
+		key, err := parsePublicKey(trustedKey)
+		if err != nil {
+			return fmt.Errorf("invalid trusted key '%s': %v", trustedKey, err)
+		}
+		keys = append(keys, key)
+	}
+
+	pc.TrustedKeys = keys
+	pc.AllowUnverified = allowUnverified
+	return nil
+}
+
+// verifyChecksum сравнивает SHA-256 загруженного файла с 'hash' из индекса
+func (pc *PluginController) verifyChecksum(entry Plugin, sum []byte) error {
+
+	expected := strings.ToLower(strings.TrimPrefix(strings.TrimSpace(entry.Hash), "sha256:"))
+	if expected == "" {
+		if pc.AllowUnverified {
+			fmt.Printf("WARNING: plugin %s has no hash in index, checksum is not verified\n", entry.Name)
+			return nil
+		}
+		return fmt.Errorf("plugin %s has no hash in index, refusing to install unverified plugin", entry.Name)
+	}
+
+	actual := hex.EncodeToString(sum)
+	if actual != expected {
+		return fmt.Errorf("plugin %s checksum mismatch: expected %s, got %s", entry.Name, expected, actual)
+	}
+	return nil
+}
+
+// verifySignature проверяет отсоединённую подпись файла плагина.
+// Если доверенные ключи не заданы, проверка не выполняется; иначе подпись обязательна.
+func (pc *PluginController) verifySignature(entry Plugin, pluginFile string) error {
+
+	if len(pc.TrustedKeys) == 0 {
+		return nil
+	}
+	if entry.Signature == "" {
+		return fmt.Errorf("plugin %s has no signature in index, but trusted keys are configured", entry.Name)
+	}
+
+	signature, err := downloadSignature(entry.Signature)
+	if err != nil {
+		return fmt.Errorf("plugin %s: %v", entry.Name, err)
+	}
+
+	data, err := os.ReadFile(pluginFile)
+	if err != nil {
+		return fmt.Errorf("failed to read plugin file %s: %v", pluginFile, err)
+	}
+
+	for _, key := range pc.TrustedKeys {
+		if ed25519.Verify(key, data, signature) {
+			return nil
+		}
+	}
+	return fmt.Errorf("plugin %s signature is not valid for any trusted key", entry.Name)
+}
+
+// downloadSignature загружает подпись ed25519 в base64
+func downloadSignature(signatureURL string) ([]byte, error) {
+
+	resp, err := http.Get(signatureURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch signature from URL %s: %v", signatureURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch signature from URL %s: status %d", signatureURL, resp.StatusCode)
+	}
+
+	encoded, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signature: %v", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode signature: %v", err)
+	}
+	if len(signature) != ed25519.SignatureSize {
+		return nil, errors.New("signature has invalid size")
+	}
+	return signature, nil
+}
+
+// parsePublicKey разбирает публичный ключ ed25519 из base64 или из файла
+func parsePublicKey(value string) (ed25519.PublicKey, error) {
+
+	encoded := value
+	if data, err := os.ReadFile(value); err == nil {
+		encoded = string(data)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("public key has invalid size")
+	}
+	return ed25519.PublicKey(key), nil
+}
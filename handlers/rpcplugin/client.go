@@ -0,0 +1,422 @@
+package rpcplugin
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// DEFAULT_CALL_TIMEOUT ограничивает вызовы без контекста (GetInfo, GetComponent и т.п.)
+var DEFAULT_CALL_TIMEOUT = 30 * time.Second
+
+// Handle ссылка на компонент, действие или проверку, созданные в процессе плагина
+type Handle struct {
+	Plugin string
+	ID     string
+}
+
+// Executor реализует v1.Executor, передавая вызовы процессу плагина.
+// Падение процесса плагина превращается в ошибку вызова, а не в падение RoLLeR.
+type Executor struct {
+	Path string
+
+	cmd       *exec.Cmd
+	client    *rpc.Client
+	socketDir string
+	nextID    uint64
+
+	exited   chan struct{}
+	exitErr  error
+	stopOnce sync.Once
+}
+
+// Start запускает исполняемый файл плагина и устанавливает соединение по протоколу PROTOCOL_VERSION.
+// env добавляется к окружению процесса плагина; переменные ENV_PLUGIN_PREFIX RoLLeR не наследуются.
+func Start(path string, env ...string) (*Executor, error) {
+
+	socketDir, err := os.MkdirTemp("", "roller-plugin-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %v", err)
+	}
+
+	// Собственные каналы вместо StdinPipe/StdoutPipe: Wait не закрывает их под читающим клиентом
+	stdinReader, stdinWriter, err := os.Pipe()
+	if err != nil {
+		os.RemoveAll(socketDir)
+		return nil, err
+	}
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		stdinReader.Close()
+		stdinWriter.Close()
+		os.RemoveAll(socketDir)
+		return nil, err
+	}
+
+	cmd := exec.Command(path)
+	cmd.Env = append(inheritedEnv(), env...)
+	cmd.Env = append(cmd.Env,
+		ENV_MAGIC_COOKIE+"="+MAGIC_COOKIE,
+		ENV_PROTOCOL_VERSION+"="+strconv.Itoa(PROTOCOL_VERSION),
+		ENV_TRANSPORT+"="+DEFAULT_TRANSPORT,
+		ENV_SOCKET_DIR+"="+socketDir,
+	)
+	cmd.Stdin = stdinReader
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = os.Stderr
+
+	startErr := cmd.Start()
+	stdinReader.Close()
+	stdoutWriter.Close()
+	if startErr != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		os.RemoveAll(socketDir)
+		return nil, fmt.Errorf("failed to start plugin %s: %v", path, startErr)
+	}
+
+	e := &Executor{
+		Path:      path,
+		cmd:       cmd,
+		socketDir: socketDir,
+		exited:    make(chan struct{}),
+	}
+	go func() {
+		e.exitErr = cmd.Wait()
+		close(e.exited)
+	}()
+
+	stdout := bufio.NewReader(stdoutReader)
+	h, err := e.readHandshake(stdout)
+	if err == nil {
+		err = e.connect(h, stdout, stdinWriter, stdoutReader)
+	}
+	if err != nil {
+		stdinWriter.Close()
+		stdoutReader.Close()
+		e.Close()
+		return nil, fmt.Errorf("plugin %s: %v", path, err)
+	}
+	return e, nil
+}
+
+// readHandshake ждёт строку готовности плагина не дольше DEFAULT_HANDSHAKE_TIMEOUT
+func (e *Executor) readHandshake(stdout *bufio.Reader) (handshake, error) {
+
+	type result struct {
+		line string
+		err  error
+	}
+	lines := make(chan result, 1)
+	go func() {
+		line, err := stdout.ReadString('\n')
+		lines <- result{line: line, err: err}
+	}()
+
+	select {
+	case r := <-lines:
+		if r.err != nil {
+			return handshake{}, fmt.Errorf("failed to read handshake: %v", r.err)
+		}
+		return parseHandshake(r.line)
+	case <-e.exited:
+		return handshake{}, fmt.Errorf("process exited before handshake: %v", e.exitErr)
+	case <-time.After(DEFAULT_HANDSHAKE_TIMEOUT):
+		return handshake{}, fmt.Errorf("no handshake within %s", DEFAULT_HANDSHAKE_TIMEOUT)
+	}
+}
+
+func (e *Executor) connect(h handshake, stdout *bufio.Reader, stdin io.WriteCloser, stdoutPipe io.Closer) error {
+
+	switch h.Transport {
+	case TRANSPORT_STDIO:
+		conn := &pipeConn{Reader: stdout, Writer: stdin, closers: []io.Closer{stdin, stdoutPipe}}
+		e.client = rpc.NewClientWithCodec(jsonrpc.NewClientCodec(conn))
+		return nil
+
+	case TRANSPORT_UNIX:
+		conn, err := net.DialTimeout("unix", h.Address, DEFAULT_HANDSHAKE_TIMEOUT)
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", h.Address, err)
+		}
+		e.client = jsonrpc.NewClient(conn)
+		// stdout плагина больше не нужен протоколу, но его нужно вычитывать
+		go io.Copy(os.Stderr, stdout)
+		return nil
+	}
+	return fmt.Errorf("unsupported transport '%s'", h.Transport)
+}
+
+// pipeConn соединение поверх stdin/stdout процесса плагина
+type pipeConn struct {
+	io.Reader
+	io.Writer
+	closers []io.Closer
+}
+
+func (c *pipeConn) Close() error {
+	var err error
+	for _, closer := range c.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// Close закрывает соединение и завершает процесс плагина
+func (e *Executor) Close() error {
+	e.stopOnce.Do(func() {
+		if e.client != nil {
+			e.client.Close()
+		}
+		select {
+		case <-e.exited:
+		case <-time.After(2 * time.Second):
+			e.cmd.Process.Kill()
+			<-e.exited
+		}
+		os.RemoveAll(e.socketDir)
+	})
+	return nil
+}
+
+func (e *Executor) GetInfo() (v1.PluginInfo, error) {
+	var info v1.PluginInfo
+	err := e.call(context.Background(), "GetInfo", &Empty{}, &info)
+	return info, err
+}
+
+// Configure передаёт плагину его секцию 'plugins' из config.yml.
+// Если плагин не реализует Configure, возвращается ErrNotImplemented: его настраивают через окружение процесса.
+func (e *Executor) Configure(config map[string]interface{}) error {
+	err := e.call(context.Background(), "Configure", &ConfigArgs{Config: config}, &Empty{})
+	if err != nil && (strings.Contains(err.Error(), "rpc: can't find method") || strings.Contains(err.Error(), ErrNotImplemented.Error())) {
+		return ErrNotImplemented
+	}
+	return err
+}
+
+// SensitiveFields возвращает поля, которые плагин объявил чувствительными
+func (e *Executor) SensitiveFields() ([]string, error) {
+	var reply SensitiveFieldsReply
+	err := e.call(context.Background(), "SensitiveFields", &Empty{}, &reply)
+	return reply.Fields, err
+}
+
+func (e *Executor) GetComponent(config map[string]interface{}) (v1.Component, error) {
+	return e.getHandle("GetComponent", config)
+}
+
+func (e *Executor) GetAction(config map[string]interface{}) (v1.Action, error) {
+	return e.getHandle("GetAction", config)
+}
+
+func (e *Executor) GetCheck(config map[string]interface{}) (v1.Check, error) {
+	return e.getHandle("GetCheck", config)
+}
+
+func (e *Executor) ValidateYAMLComponent(component v1.Component) error {
+	handle, err := e.handleID(component)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	return e.call(ctx, "ValidateYAMLComponent", &ValidateArgs{Call: e.callInfo(ctx), Handle: handle}, &Empty{})
+}
+
+func (e *Executor) ValidateYAMLAction(ctx context.Context, action v1.Action) error {
+	handle, err := e.handleID(action)
+	if err != nil {
+		return err
+	}
+	return e.call(ctx, "ValidateYAMLAction", &ValidateArgs{Call: e.callInfo(ctx), Handle: handle}, &Empty{})
+}
+
+func (e *Executor) ValidateYAMLCheck(ctx context.Context, check v1.Check) error {
+	handle, err := e.handleID(check)
+	if err != nil {
+		return err
+	}
+	return e.call(ctx, "ValidateYAMLCheck", &ValidateArgs{Call: e.callInfo(ctx), Handle: handle}, &Empty{})
+}
+
+func (e *Executor) ExecAction(ctx context.Context, component v1.Component, action v1.Action) error {
+	args, err := e.execArgs(ctx, component, action)
+	if err != nil {
+		return err
+	}
+	return e.call(ctx, "ExecAction", args, &Empty{})
+}
+
+func (e *Executor) ExecCheck(ctx context.Context, component v1.Component, check v1.Check) (bool, error) {
+	args, err := e.execArgs(ctx, component, check)
+	if err != nil {
+		return false, err
+	}
+	var reply ExecCheckReply
+	if err := e.call(ctx, "ExecCheck", args, &reply); err != nil {
+		return false, err
+	}
+	return reply.OK, nil
+}
+
+// ExecActionOutput выполняет действие и возвращает выходные значения, которые сообщил плагин
+func (e *Executor) ExecActionOutput(ctx context.Context, component v1.Component, action v1.Action) (map[string]interface{}, error) {
+	args, err := e.execArgs(ctx, component, action)
+	if err != nil {
+		return nil, err
+	}
+	var reply ExecOutputReply
+	err = e.call(ctx, "ExecActionOutput", args, &reply)
+	return reply.Outputs, err
+}
+
+// ExecCheckOutput выполняет проверку и возвращает выходные значения, которые сообщил плагин
+func (e *Executor) ExecCheckOutput(ctx context.Context, component v1.Component, check v1.Check) (bool, map[string]interface{}, error) {
+	args, err := e.execArgs(ctx, component, check)
+	if err != nil {
+		return false, nil, err
+	}
+	var reply ExecOutputReply
+	if err := e.call(ctx, "ExecCheckOutput", args, &reply); err != nil {
+		return false, nil, err
+	}
+	return reply.OK, reply.Outputs, nil
+}
+
+func (e *Executor) getHandle(method string, config map[string]interface{}) (interface{}, error) {
+	var reply HandleReply
+	if err := e.call(context.Background(), method, &ConfigArgs{Config: config}, &reply); err != nil {
+		return nil, err
+	}
+	return &Handle{Plugin: e.Path, ID: reply.Handle}, nil
+}
+
+func (e *Executor) execArgs(ctx context.Context, component interface{}, value interface{}) (*ExecArgs, error) {
+	componentID, err := e.handleID(component)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := e.handleID(value)
+	if err != nil {
+		return nil, err
+	}
+	return &ExecArgs{Call: e.callInfo(ctx), Component: componentID, Handle: handle}, nil
+}
+
+// handleID возвращает идентификатор объекта, созданного этим плагином
+func (e *Executor) handleID(value interface{}) (string, error) {
+	var handle *Handle
+	switch v := value.(type) {
+	case *Handle:
+		handle = v
+	case Handle:
+		handle = &v
+	}
+	if handle == nil || handle.Plugin != e.Path {
+		return "", fmt.Errorf("plugin %s: object %v was not created by this plugin", e.Path, value)
+	}
+	return handle.ID, nil
+}
+
+// callInfo передаёт плагину идентификатор вызова и оставшееся время контекста
+func (e *Executor) callInfo(ctx context.Context) CallInfo {
+	call := CallInfo{ID: atomic.AddUint64(&e.nextID, 1)}
+	if deadline, ok := ctx.Deadline(); ok {
+		call.TimeoutMs = time.Until(deadline).Milliseconds()
+		if call.TimeoutMs <= 0 {
+			call.TimeoutMs = 1
+		}
+	}
+	return call
+}
+
+// call выполняет вызов; при отмене контекста просит плагин отменить его у себя
+func (e *Executor) call(ctx context.Context, method string, args interface{}, reply interface{}) error {
+
+	// Время выполнения действий и проверок ограничивает сам шаг миграции
+	if _, ok := ctx.Deadline(); !ok && !strings.HasPrefix(method, "Exec") {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, DEFAULT_CALL_TIMEOUT)
+		defer cancel()
+	}
+
+	call := e.client.Go(SERVICE_NAME+"."+method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		return e.callError(method, call.Error)
+	case <-ctx.Done():
+		if id, ok := callID(args); ok {
+			e.client.Go(SERVICE_NAME+".Cancel", &CancelArgs{ID: id}, &Empty{}, make(chan *rpc.Call, 1))
+		}
+		return fmt.Errorf("plugin %s: %s: %v", e.Path, method, ctx.Err())
+	case <-e.exited:
+		return fmt.Errorf("plugin %s: %s: plugin process exited: %v", e.Path, method, e.exitErr)
+	}
+}
+
+func (e *Executor) callError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var serverErr rpc.ServerError
+	if errors.As(err, &serverErr) {
+		// Ошибка самого плагина возвращается как есть
+		return errors.New(string(serverErr))
+	}
+	// Обрыв соединения обычно означает падение плагина: даём процессу завершиться, чтобы сообщить код выхода
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, rpc.ErrShutdown) {
+		select {
+		case <-e.exited:
+			return fmt.Errorf("plugin %s: %s: plugin process exited: %v", e.Path, method, e.exitErr)
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("plugin %s: %s: %v", e.Path, method, err)
+}
+
+func callID(args interface{}) (uint64, bool) {
+	switch a := args.(type) {
+	case *ValidateArgs:
+		return a.Call.ID, true
+	case *ExecArgs:
+		return a.Call.ID, true
+	}
+	return 0, false
+}
+
+// IsPluginExecutable сообщает, похож ли файл на исполняемый внепроцессный плагин
+func IsPluginExecutable(path string, info os.FileInfo) bool {
+	name := filepath.Base(path)
+	return info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 &&
+		!strings.HasPrefix(name, ".") && filepath.Ext(name) != ".so"
+}
+
+// inheritedEnv окружение RoLLeR без переменных ENV_PLUGIN_PREFIX: настройки одного плагина
+// и параметры протокола не должны попадать в другие плагины
+func inheritedEnv() []string {
+	var env []string
+	for _, variable := range os.Environ() {
+		if strings.HasPrefix(variable, ENV_PLUGIN_PREFIX) {
+			continue
+		}
+		env = append(env, variable)
+	}
+	return env
+}
@@ -0,0 +1,199 @@
+package rpcplugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// testExecutor плагин для тестов: действия и проверки - карты с ключом 'cmd'
+type testExecutor struct {
+	cancelled chan struct{}
+}
+
+func (e *testExecutor) GetInfo() (v1.PluginInfo, error) {
+	return v1.PluginInfo{Name: "test", Version: "1.2.3", Description: "test plugin"}, nil
+}
+
+func (e *testExecutor) SensitiveFields() ([]string, error) {
+	return []string{"password", "env"}, nil
+}
+
+func (e *testExecutor) GetComponent(config map[string]interface{}) (v1.Component, error) {
+	if config["host"] == nil {
+		return nil, fmt.Errorf("'host' is required")
+	}
+	return config, nil
+}
+
+func (e *testExecutor) GetAction(config map[string]interface{}) (v1.Action, error) {
+	return config, nil
+}
+
+func (e *testExecutor) GetCheck(config map[string]interface{}) (v1.Check, error) {
+	return config, nil
+}
+
+func (e *testExecutor) ValidateYAMLComponent(component v1.Component) error {
+	return nil
+}
+
+func (e *testExecutor) ValidateYAMLAction(ctx context.Context, action v1.Action) error {
+	if action.(map[string]interface{})["cmd"] == nil {
+		return fmt.Errorf("'cmd' is required")
+	}
+	return nil
+}
+
+func (e *testExecutor) ValidateYAMLCheck(ctx context.Context, check v1.Check) error {
+	return e.ValidateYAMLAction(ctx, check)
+}
+
+// ExecAction выполняет 'fail' с ошибкой, а 'wait' - до отмены контекста
+func (e *testExecutor) ExecAction(ctx context.Context, component v1.Component, action v1.Action) error {
+	switch action.(map[string]interface{})["cmd"] {
+	case "fail":
+		return fmt.Errorf("action failed on %v", component.(map[string]interface{})["host"])
+	case "wait":
+		<-ctx.Done()
+		close(e.cancelled)
+		return ctx.Err()
+	}
+	return nil
+}
+
+func (e *testExecutor) ExecCheck(ctx context.Context, component v1.Component, check v1.Check) (bool, error) {
+	return check.(map[string]interface{})["cmd"] == "ok", nil
+}
+
+// startTestExecutor соединяет клиент и сервер протокола через net.Pipe
+func startTestExecutor(t *testing.T, executor v1.Executor) *Executor {
+	t.Helper()
+
+	server := rpc.NewServer()
+	if err := server.RegisterName(SERVICE_NAME, newService(executor)); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(jsonrpc.NewServerCodec(serverConn))
+
+	e := &Executor{Path: "test", client: jsonrpc.NewClient(clientConn), exited: make(chan struct{})}
+	t.Cleanup(func() { e.client.Close() })
+	return e
+}
+
+func TestRoundTrip(t *testing.T) {
+	e := startTestExecutor(t, &testExecutor{})
+	ctx := context.Background()
+
+	info, err := e.GetInfo()
+	if err != nil || info.Name != "test" || info.Version != "1.2.3" {
+		t.Fatalf("GetInfo() = %+v, %v", info, err)
+	}
+
+	fields, err := e.SensitiveFields()
+	if err != nil || !reflect.DeepEqual(fields, []string{"password", "env"}) {
+		t.Fatalf("SensitiveFields() = %v, %v", fields, err)
+	}
+
+	// Объекты плагина остаются в его процессе, клиент получает ссылки на них
+	component, err := e.GetComponent(map[string]interface{}{"host": "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if handle, ok := component.(*Handle); !ok || handle.Plugin != "test" || handle.ID == "" {
+		t.Fatalf("GetComponent() = %#v, want a handle", component)
+	}
+	if err := e.ValidateYAMLComponent(component); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.GetComponent(map[string]interface{}{}); err == nil || err.Error() != "'host' is required" {
+		t.Errorf("GetComponent() error = %v, want the plugin error as is", err)
+	}
+
+	action, err := e.GetAction(map[string]interface{}{"cmd": "deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ValidateYAMLAction(ctx, action); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ExecAction(ctx, component, action); err != nil {
+		t.Fatalf("ExecAction() error: %v", err)
+	}
+
+	failing, err := e.GetAction(map[string]interface{}{"cmd": "fail"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ExecAction(ctx, component, failing); err == nil || err.Error() != "action failed on db" {
+		t.Errorf("ExecAction() error = %v, want 'action failed on db'", err)
+	}
+	invalid, err := e.GetAction(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.ValidateYAMLAction(ctx, invalid); err == nil || err.Error() != "'cmd' is required" {
+		t.Errorf("ValidateYAMLAction() error = %v", err)
+	}
+
+	for cmd, want := range map[string]bool{"ok": true, "down": false} {
+		check, err := e.GetCheck(map[string]interface{}{"cmd": cmd})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := e.ValidateYAMLCheck(ctx, check); err != nil {
+			t.Fatal(err)
+		}
+		if ok, err := e.ExecCheck(ctx, component, check); err != nil || ok != want {
+			t.Errorf("ExecCheck(%s) = %v, %v, want %v", cmd, ok, err, want)
+		}
+		// Без выходных значений у плагина выполняется обычная проверка
+		if ok, outputs, err := e.ExecCheckOutput(ctx, component, check); err != nil || ok != want || outputs != nil {
+			t.Errorf("ExecCheckOutput(%s) = %v, %v, %v, want %v", cmd, ok, outputs, err, want)
+		}
+	}
+
+	// Объект другого плагина не передаётся
+	if err := e.ExecAction(ctx, map[string]interface{}{"host": "db"}, action); err == nil || !strings.Contains(err.Error(), "was not created by this plugin") {
+		t.Errorf("ExecAction() with foreign component error = %v", err)
+	}
+	// Configure не реализован плагином
+	if err := e.Configure(map[string]interface{}{"timeout": 1}); err != ErrNotImplemented {
+		t.Errorf("Configure() = %v, want ErrNotImplemented", err)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	executor := &testExecutor{cancelled: make(chan struct{})}
+	e := startTestExecutor(t, executor)
+
+	component, err := e.GetComponent(map[string]interface{}{"host": "db"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	action, err := e.GetAction(map[string]interface{}{"cmd": "wait"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Отмена контекста RoLLeR отменяет вызов в процессе плагина
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := e.ExecAction(ctx, component, action); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("ExecAction() error = %v, want deadline exceeded", err)
+	}
+	select {
+	case <-executor.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("plugin call was not cancelled")
+	}
+}
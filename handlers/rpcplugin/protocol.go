@@ -0,0 +1,143 @@
+package rpcplugin
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Протокол внепроцессных плагинов.
+//
+// RoLLeR запускает исполняемый файл плагина с переменными окружения ENV_MAGIC_COOKIE,
+// ENV_PROTOCOL_VERSION, ENV_TRANSPORT и ENV_SOCKET_DIR. Плагин первой строкой stdout
+// сообщает о готовности:
+//
+//	ROLLER_PLUGIN|<версия протокола>|stdio
+//	ROLLER_PLUGIN|<версия протокола>|unix|<путь к сокету>
+//
+// Дальше по stdin/stdout или по unix-сокету идут вызовы JSON-RPC (net/rpc/jsonrpc)
+// к сервису SERVICE_NAME. Логи плагин пишет в stderr.
+const (
+	PROTOCOL_VERSION = 1
+	HANDSHAKE_PREFIX = "ROLLER_PLUGIN"
+	SERVICE_NAME     = "Executor"
+
+	// ENV_PLUGIN_PREFIX общий префикс переменных протокола и настроек плагинов
+	ENV_PLUGIN_PREFIX    = "ROLLER_PLUGIN_"
+	ENV_MAGIC_COOKIE     = "ROLLER_PLUGIN_MAGIC_COOKIE"
+	ENV_PROTOCOL_VERSION = "ROLLER_PLUGIN_PROTOCOL_VERSION"
+	ENV_TRANSPORT        = "ROLLER_PLUGIN_TRANSPORT"
+	ENV_SOCKET_DIR       = "ROLLER_PLUGIN_SOCKET_DIR"
+
+	MAGIC_COOKIE = "b1d5e3a0-roller-executor"
+
+	TRANSPORT_STDIO = "stdio"
+	TRANSPORT_UNIX  = "unix"
+)
+
+var (
+	DEFAULT_TRANSPORT         = TRANSPORT_STDIO
+	DEFAULT_HANDSHAKE_TIMEOUT = 10 * time.Second
+
+	// ErrNotImplemented необязательный метод протокола не реализован плагином
+	ErrNotImplemented = errors.New("method is not implemented by plugin")
+)
+
+// CallInfo общие параметры вызова: идентификатор для отмены и оставшееся время контекста
+type CallInfo struct {
+	ID        uint64 `json:"id"`
+	TimeoutMs int64  `json:"timeout_ms,omitempty"`
+}
+
+// Empty пустые аргументы или ответ
+type Empty struct{}
+
+// ConfigArgs аргументы Configure, GetComponent, GetAction и GetCheck
+type ConfigArgs struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// HandleReply ссылка на объект, созданный плагином
+type HandleReply struct {
+	Handle string `json:"handle"`
+}
+
+// ValidateArgs аргументы ValidateYAML*
+type ValidateArgs struct {
+	Call   CallInfo `json:"call"`
+	Handle string   `json:"handle"`
+}
+
+// ExecArgs аргументы ExecAction и ExecCheck
+type ExecArgs struct {
+	Call      CallInfo `json:"call"`
+	Component string   `json:"component"`
+	Handle    string   `json:"handle"`
+}
+
+// ExecCheckReply результат ExecCheck
+type ExecCheckReply struct {
+	OK bool `json:"ok"`
+}
+
+// ExecOutputReply результат ExecActionOutput и ExecCheckOutput
+type ExecOutputReply struct {
+	OK      bool                   `json:"ok"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// SensitiveFieldsReply поля схемы плагина, значения которых не выводятся в лог
+type SensitiveFieldsReply struct {
+	Fields []string `json:"fields,omitempty"`
+}
+
+// CancelArgs отмена выполняющегося вызова
+type CancelArgs struct {
+	ID uint64 `json:"id"`
+}
+
+// handshake строка готовности плагина
+type handshake struct {
+	Version   int
+	Transport string
+	Address   string
+}
+
+func (h handshake) String() string {
+	parts := []string{HANDSHAKE_PREFIX, strconv.Itoa(h.Version), h.Transport}
+	if h.Address != "" {
+		parts = append(parts, h.Address)
+	}
+	return strings.Join(parts, "|")
+}
+
+func parseHandshake(line string) (handshake, error) {
+
+	parts := strings.Split(strings.TrimSpace(line), "|")
+	if len(parts) < 3 || parts[0] != HANDSHAKE_PREFIX {
+		return handshake{}, fmt.Errorf("invalid handshake '%s'", strings.TrimSpace(line))
+	}
+
+	version, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return handshake{}, fmt.Errorf("invalid protocol version '%s'", parts[1])
+	}
+	if version != PROTOCOL_VERSION {
+		return handshake{}, fmt.Errorf("unsupported protocol version %d, expected %d", version, PROTOCOL_VERSION)
+	}
+
+	h := handshake{Version: version, Transport: parts[2]}
+	switch h.Transport {
+	case TRANSPORT_STDIO:
+	case TRANSPORT_UNIX:
+		if len(parts) < 4 || parts[3] == "" {
+			return handshake{}, fmt.Errorf("handshake '%s' has no socket path", strings.TrimSpace(line))
+		}
+		h.Address = parts[3]
+	default:
+		return handshake{}, fmt.Errorf("unsupported transport '%s'", h.Transport)
+	}
+	return h, nil
+}
@@ -0,0 +1,38 @@
+package rpcplugin
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseHandshake(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    handshake
+		wantErr string
+	}{
+		{line: "ROLLER_PLUGIN|1|stdio\n", want: handshake{Version: 1, Transport: TRANSPORT_STDIO}},
+		{line: "ROLLER_PLUGIN|1|unix|/tmp/p.sock", want: handshake{Version: 1, Transport: TRANSPORT_UNIX, Address: "/tmp/p.sock"}},
+		{line: "hello", wantErr: "invalid handshake 'hello'"},
+		{line: "ROLLER_PLUGIN|x|stdio", wantErr: "invalid protocol version 'x'"},
+		{line: "ROLLER_PLUGIN|2|stdio", wantErr: "unsupported protocol version 2"},
+		{line: "ROLLER_PLUGIN|1|unix", wantErr: "has no socket path"},
+		{line: "ROLLER_PLUGIN|1|tcp|:80", wantErr: "unsupported transport 'tcp'"},
+	}
+
+	for _, tt := range tests {
+		got, err := parseHandshake(tt.line)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseHandshake(%q) error = %v, want %q", tt.line, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseHandshake(%q) = %+v, %v, want %+v", tt.line, got, err, tt.want)
+		}
+		if parsed, _ := parseHandshake(got.String()); parsed != got {
+			t.Errorf("parseHandshake(%q) = %+v, want %+v", got.String(), parsed, got)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package rpcplugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// Serve обслуживает вызовы RoLLeR к executor. Вызывается из main исполняемого файла плагина.
+func Serve(executor v1.Executor) error {
+
+	if os.Getenv(ENV_MAGIC_COOKIE) != MAGIC_COOKIE {
+		return errors.New("this binary is a RoLLeR plugin and must be started by roller")
+	}
+	if version := os.Getenv(ENV_PROTOCOL_VERSION); version != "" && version != strconv.Itoa(PROTOCOL_VERSION) {
+		return fmt.Errorf("roller requested protocol version %s, plugin supports %d", version, PROTOCOL_VERSION)
+	}
+
+	server := rpc.NewServer()
+	if err := server.RegisterName(SERVICE_NAME, newService(executor)); err != nil {
+		return err
+	}
+
+	transport := os.Getenv(ENV_TRANSPORT)
+	if transport == "" {
+		transport = DEFAULT_TRANSPORT
+	}
+
+	switch transport {
+	case TRANSPORT_STDIO:
+		// stdout занят протоколом: случайный вывод плагина уходит в stderr
+		out := os.Stdout
+		os.Stdout = os.Stderr
+		fmt.Fprintln(out, handshake{Version: PROTOCOL_VERSION, Transport: TRANSPORT_STDIO})
+		server.ServeCodec(jsonrpc.NewServerCodec(stdioConn{Reader: os.Stdin, Writer: out}))
+		return nil
+
+	case TRANSPORT_UNIX:
+		dir := os.Getenv(ENV_SOCKET_DIR)
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		socketPath := filepath.Join(dir, fmt.Sprintf("roller-plugin-%d.sock", os.Getpid()))
+		listener, err := net.Listen("unix", socketPath)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %v", socketPath, err)
+		}
+		defer listener.Close()
+
+		fmt.Fprintln(os.Stdout, handshake{Version: PROTOCOL_VERSION, Transport: TRANSPORT_UNIX, Address: socketPath})
+
+		// RoLLeR держит одно соединение на процесс плагина
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept connection on %s: %v", socketPath, err)
+		}
+		server.ServeCodec(jsonrpc.NewServerCodec(conn))
+		return nil
+	}
+	return fmt.Errorf("unsupported transport '%s'", transport)
+}
+
+// stdioConn объединяет stdin и stdout в одно соединение
+type stdioConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (c stdioConn) Close() error {
+	return nil
+}
+
+// outputExecutor исполнитель, возвращающий выходные значения шага (stdout и т.п.)
+type outputExecutor interface {
+	ExecActionOutput(ctx context.Context, component v1.Component, action v1.Action) (map[string]interface{}, error)
+	ExecCheckOutput(ctx context.Context, component v1.Component, check v1.Check) (bool, map[string]interface{}, error)
+}
+
+// configurableExecutor исполнитель, принимающий секцию настроек из config.yml
+type configurableExecutor interface {
+	Configure(config map[string]interface{}) error
+}
+
+// schemaExecutor исполнитель, объявляющий чувствительные поля своей схемы
+type schemaExecutor interface {
+	SensitiveFields() ([]string, error)
+}
+
+// service экспортирует методы executor для net/rpc.
+// Объекты, созданные плагином, остаются в процессе плагина; RoLLeR получает на них ссылки.
+type service struct {
+	executor v1.Executor
+
+	mu      sync.Mutex
+	next    uint64
+	handles map[string]interface{}
+	cancels map[uint64]context.CancelFunc
+}
+
+func newService(executor v1.Executor) *service {
+	return &service{
+		executor: executor,
+		handles:  make(map[string]interface{}),
+		cancels:  make(map[uint64]context.CancelFunc),
+	}
+}
+
+func (s *service) GetInfo(args *Empty, reply *v1.PluginInfo) error {
+	info, err := s.executor.GetInfo()
+	if err != nil {
+		return err
+	}
+	*reply = info
+	return nil
+}
+
+// Configure передаёт executor его секцию настроек; executor без Configure настраивается через окружение
+func (s *service) Configure(args *ConfigArgs, reply *Empty) error {
+	if executor, ok := s.executor.(configurableExecutor); ok {
+		return executor.Configure(args.Config)
+	}
+	return ErrNotImplemented
+}
+
+// SensitiveFields возвращает чувствительные поля схемы; пустой список, если executor их не объявляет
+func (s *service) SensitiveFields(args *Empty, reply *SensitiveFieldsReply) error {
+	executor, ok := s.executor.(schemaExecutor)
+	if !ok {
+		return nil
+	}
+	fields, err := executor.SensitiveFields()
+	if err != nil {
+		return err
+	}
+	reply.Fields = fields
+	return nil
+}
+
+func (s *service) GetComponent(args *ConfigArgs, reply *HandleReply) error {
+	component, err := s.executor.GetComponent(args.Config)
+	if err != nil {
+		return err
+	}
+	reply.Handle = s.store("component", component)
+	return nil
+}
+
+func (s *service) GetAction(args *ConfigArgs, reply *HandleReply) error {
+	action, err := s.executor.GetAction(args.Config)
+	if err != nil {
+		return err
+	}
+	reply.Handle = s.store("action", action)
+	return nil
+}
+
+func (s *service) GetCheck(args *ConfigArgs, reply *HandleReply) error {
+	check, err := s.executor.GetCheck(args.Config)
+	if err != nil {
+		return err
+	}
+	reply.Handle = s.store("check", check)
+	return nil
+}
+
+func (s *service) ValidateYAMLComponent(args *ValidateArgs, reply *Empty) error {
+	component, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	return s.executor.ValidateYAMLComponent(asComponent(component))
+}
+
+func (s *service) ValidateYAMLAction(args *ValidateArgs, reply *Empty) error {
+	action, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	return s.executor.ValidateYAMLAction(ctx, asAction(action))
+}
+
+func (s *service) ValidateYAMLCheck(args *ValidateArgs, reply *Empty) error {
+	check, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	return s.executor.ValidateYAMLCheck(ctx, asCheck(check))
+}
+
+func (s *service) ExecAction(args *ExecArgs, reply *Empty) error {
+	component, err := s.load(args.Component)
+	if err != nil {
+		return err
+	}
+	action, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	return s.executor.ExecAction(ctx, asComponent(component), asAction(action))
+}
+
+func (s *service) ExecCheck(args *ExecArgs, reply *ExecCheckReply) error {
+	component, err := s.load(args.Component)
+	if err != nil {
+		return err
+	}
+	check, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	ok, err := s.executor.ExecCheck(ctx, asComponent(component), asCheck(check))
+	reply.OK = ok
+	return err
+}
+
+// ExecActionOutput выполняет действие и возвращает его выходные значения.
+// Если executor их не поддерживает, выполняется обычный ExecAction.
+func (s *service) ExecActionOutput(args *ExecArgs, reply *ExecOutputReply) error {
+	component, err := s.load(args.Component)
+	if err != nil {
+		return err
+	}
+	action, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	if executor, ok := s.executor.(outputExecutor); ok {
+		reply.Outputs, err = executor.ExecActionOutput(ctx, asComponent(component), asAction(action))
+		reply.OK = err == nil
+		return err
+	}
+	err = s.executor.ExecAction(ctx, asComponent(component), asAction(action))
+	reply.OK = err == nil
+	return err
+}
+
+// ExecCheckOutput выполняет проверку и возвращает её выходные значения.
+// Если executor их не поддерживает, выполняется обычный ExecCheck.
+func (s *service) ExecCheckOutput(args *ExecArgs, reply *ExecOutputReply) error {
+	component, err := s.load(args.Component)
+	if err != nil {
+		return err
+	}
+	check, err := s.load(args.Handle)
+	if err != nil {
+		return err
+	}
+	ctx, done := s.context(args.Call)
+	defer done()
+	if executor, ok := s.executor.(outputExecutor); ok {
+		reply.OK, reply.Outputs, err = executor.ExecCheckOutput(ctx, asComponent(component), asCheck(check))
+		return err
+	}
+	reply.OK, err = s.executor.ExecCheck(ctx, asComponent(component), asCheck(check))
+	return err
+}
+
+// Cancel отменяет контекст выполняющегося вызова
+func (s *service) Cancel(args *CancelArgs, reply *Empty) error {
+	s.mu.Lock()
+	cancel, ok := s.cancels[args.ID]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+	}
+	return nil
+}
+
+func (s *service) store(kind string, value interface{}) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	handle := fmt.Sprintf("%s-%d", kind, s.next)
+	s.handles[handle] = value
+	return handle
+}
+
+func (s *service) load(handle string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.handles[handle]
+	if !ok {
+		return nil, fmt.Errorf("unknown handle '%s'", handle)
+	}
+	return value, nil
+}
+
+// context создаёт контекст вызова с таймаутом RoLLeR, который можно отменить через Cancel
+func (s *service) context(call CallInfo) (context.Context, func()) {
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if call.TimeoutMs > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), time.Duration(call.TimeoutMs)*time.Millisecond)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	s.mu.Lock()
+	s.cancels[call.ID] = cancel
+	s.mu.Unlock()
+
+	return ctx, func() {
+		s.mu.Lock()
+		delete(s.cancels, call.ID)
+		s.mu.Unlock()
+		cancel()
+	}
+}
+
+// asComponent, asAction и asCheck не паникуют на пустых объектах плагина
+func asComponent(value interface{}) v1.Component {
+	component, _ := value.(v1.Component)
+	return component
+}
+
+func asAction(value interface{}) v1.Action {
+	action, _ := value.(v1.Action)
+	return action
+}
+
+func asCheck(value interface{}) v1.Check {
+	check, _ := value.(v1.Check)
+	return check
+}
@@ -0,0 +1,252 @@
+package run
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
+)
+
+var (
+	DEFAULT_MIGRATIONS_DIR = "./migrations"
+)
+
+// MigrationChain цепочка миграций от текущего релиза стендов до целевого.
+// Каждая миграция (файл в каталоге) - один переход 'from_release' => 'to_release'.
+type MigrationChain struct {
+	Dir         string
+	FromRelease string
+	ToRelease   string
+	Migrations  []*MigrationSet // Миграции в порядке выполнения
+}
+
+// NewMigrationChain читает все миграции каталога dir и находит кратчайшую
+// цепочку от текущего релиза стендов до toRelease. standName - стенд (--stand),
+// релиз которого начинает цепочку; пусто - все стенды.
+func (mc *MigrationChain) NewMigrationChain(dir string, toRelease string, standName string, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) (*MigrationChain, error) {
+
+	migrations, err := IndexMigrations(dir, pc, logMessage)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("[MigrationChain]>[New] no migrations found in '%s'", dir)
+	}
+
+	// Все миграции цепочки применяются к одним стендам
+	standsFile := filepath.Clean(migrations[0].YAMLStandFile)
+	for _, migration := range migrations[1:] {
+		if filepath.Clean(migration.YAMLStandFile) != standsFile {
+			return nil, fmt.Errorf("[MigrationChain]>[New] '%s' uses stands '%s', expected '%s'", migration.MigrationFile, migration.YAMLStandFile, standsFile)
+		}
+	}
+	if standName != "" {
+		if err := migrations[0].StandsFile.SelectStand(standName); err != nil {
+			return nil, fmt.Errorf("[MigrationChain]>[New] %v", err)
+		}
+	}
+	fromRelease, err := migrations[0].StandsFile.CurrentRelease()
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationChain]>[New] %v", err)
+	}
+
+	chain, err := FindChain(migrations, fromRelease, toRelease)
+	if err != nil {
+		return nil, err
+	}
+	for i, migration := range chain {
+		logMessage("INFO", fmt.Sprintf("[MigrationChain]>[New] %d. '%s' => '%s' (%s)", i+1, migration.FromRelease, migration.ToRelease, migration.MigrationFile))
+	}
+
+	return &MigrationChain{
+		Dir:         dir,
+		FromRelease: fromRelease,
+		ToRelease:   toRelease,
+		Migrations:  chain,
+	}, nil
+}
+
+// IndexMigrations загружает миграции из файлов *.yml и *.yaml каталога dir.
+// Файлы без 'from_release' и 'to_release' (например, файлы стендов) пропускаются.
+func IndexMigrations(dir string, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) ([]*MigrationSet, error) {
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationChain]>[Index] %v", err)
+	}
+
+	var files []string
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".yml" && ext != ".yaml") || strings.HasSuffix(entry.Name(), DEFAULT_STATE_SUFFIX) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(files)
+
+	var migrations []*MigrationSet
+	for _, file := range files {
+		header := &MigrationSet{}
+		if err := unmarshalYamlFile(file, header); err != nil {
+			return nil, fmt.Errorf("[MigrationChain]>[Index] %v", err)
+		}
+		if header.FromRelease == "" || header.ToRelease == "" {
+			logMessage("DEBUG", fmt.Sprintf("[MigrationChain]>[Index] '%s' is not a migration, skip", file))
+			continue
+		}
+
+		var migration *MigrationSet
+		migration, err := migration.NewMigrationSet(file, pc, logMessage)
+		if err != nil {
+			return nil, err
+		}
+		logMessage("DEBUG", fmt.Sprintf("[MigrationChain]>[Index] '%s': '%s' => '%s'", file, migration.FromRelease, migration.ToRelease))
+		migrations = append(migrations, migration)
+	}
+	return migrations, nil
+}
+
+// FindChain находит кратчайшую цепочку миграций from => to (поиск в ширину).
+// Релизы сравниваются как semver: 'v1.0' и '1.0.0' - один релиз.
+func FindChain(migrations []*MigrationSet, from string, to string) ([]*MigrationSet, error) {
+
+	start, err := releaseKey(from)
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationChain]>[Find] stands release: %v", err)
+	}
+	target, err := releaseKey(to)
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationChain]>[Find] target release: %v", err)
+	}
+
+	// Граф переходов: релиз => миграции из него
+	edges := make(map[string][]*MigrationSet)
+	hops := make(map[string]string)
+	for _, migration := range migrations {
+		fromKey, err := releaseKey(migration.FromRelease)
+		if err != nil {
+			return nil, fmt.Errorf("[MigrationChain]>[Find] '%s' 'from_release': %v", migration.MigrationFile, err)
+		}
+		toKey, err := releaseKey(migration.ToRelease)
+		if err != nil {
+			return nil, fmt.Errorf("[MigrationChain]>[Find] '%s' 'to_release': %v", migration.MigrationFile, err)
+		}
+		if other, ok := hops[fromKey+"=>"+toKey]; ok {
+			return nil, fmt.Errorf("[MigrationChain]>[Find] '%s' and '%s' both migrate '%s' => '%s'", other, migration.MigrationFile, migration.FromRelease, migration.ToRelease)
+		}
+		hops[fromKey+"=>"+toKey] = migration.MigrationFile
+		edges[fromKey] = append(edges[fromKey], migration)
+	}
+
+	if start == target {
+		return nil, nil
+	}
+
+	previous := map[string]*MigrationSet{start: nil}
+	queue := []string{start}
+	for len(queue) != 0 && previous[target] == nil {
+		release := queue[0]
+		queue = queue[1:]
+		for _, migration := range edges[release] {
+			next, _ := releaseKey(migration.ToRelease)
+			if _, seen := previous[next]; seen {
+				continue
+			}
+			previous[next] = migration
+			queue = append(queue, next)
+		}
+	}
+
+	if previous[target] == nil {
+		return nil, fmt.Errorf("[MigrationChain]>[Find] no chain of migrations from '%s' to '%s'", from, to)
+	}
+	var chain []*MigrationSet
+	for release := target; release != start; {
+		migration := previous[release]
+		chain = append([]*MigrationSet{migration}, chain...)
+		release, _ = releaseKey(migration.FromRelease)
+	}
+	return chain, nil
+}
+
+// CascadeValidation проверяет все миграции цепочки до выполнения. Каждая следующая
+// миграция проверяется так, будто предыдущие уже выполнены.
+func (mc *MigrationChain) CascadeValidation(install bool, logMessage func(string, string, ...interface{})) error {
+
+	for i, migration := range mc.Migrations {
+		if i > 0 {
+			migration.StandsFile.SetRelease(mc.Migrations[i-1].ToRelease)
+		}
+		logMessage("INFO", fmt.Sprintf("[MigrationChain]>[Valid] Validate '%s'", migration.MigrationFile))
+		if err := migration.CascadeValidation(*migration, install, logMessage); err != nil {
+			err = fmt.Errorf("[MigrationChain]>[Valid] '%s': %w", migration.MigrationFile, err)
+			// Ошибка валидации попадает в отчёт миграции, которая её не прошла
+			migration.Report.Begin(migration)
+			migration.Report.End(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// UpdateRelease выполняет миграции цепочки по порядку и останавливается на первой неуспешной.
+// Перед каждой миграцией перечитывается состояние стендов, изменённое предыдущими.
+func (mc *MigrationChain) UpdateRelease(journalDir string, resume bool, logMessage func(string, string, ...interface{})) error {
+
+	// Все миграции цепочки выполняются в одном запуске
+	runID := newRunID()
+	for i, migration := range mc.Migrations {
+		migration.RunID = runID
+		logMessage("INFO", fmt.Sprintf("[MigrationChain]>[Update] %d/%d '%s' => '%s' (%s)", i+1, len(mc.Migrations), migration.FromRelease, migration.ToRelease, migration.MigrationFile))
+
+		if err := mc.updateMigration(migration, journalDir, resume, logMessage); err != nil {
+			return fmt.Errorf("[MigrationChain]>[Update] stopped at '%s' ('%s' => '%s'), stands remain on '%s': %w", migration.MigrationFile, migration.FromRelease, migration.ToRelease, migration.FromRelease, err)
+		}
+	}
+	return nil
+}
+
+// updateMigration выполняет одну миграцию цепочки. Отчёт миграции начинается до проверки
+// состояния стендов, поэтому ошибка проверки тоже попадает в отчёт.
+func (mc *MigrationChain) updateMigration(migration *MigrationSet, journalDir string, resume bool, logMessage func(string, string, ...interface{})) (err error) {
+
+	migration.Report.Begin(migration)
+	defer func() { migration.Report.End(err) }()
+
+	if err := migration.ReloadState(logMessage); err != nil {
+		return err
+	}
+	if err := migration.ValidateRelease(*migration, logMessage); err != nil {
+		return newRunError(ERROR_VALIDATION, err)
+	}
+	if err := migration.OpenJournal(journalDir, resume, logMessage); err != nil {
+		return err
+	}
+	return migration.UpdateRelease(migration, logMessage)
+}
+
+// releaseKey приводит релиз к каноническому виду для сравнения
+func releaseKey(release string) (string, error) {
+	version, err := semver.Parse(release)
+	if err != nil {
+		return "", err
+	}
+	return version.String(), nil
+}
+
+// Reports возвращает отчёты запущенных миграций цепочки
+func (mc *MigrationChain) Reports() []*Report {
+
+	var reports []*Report
+	for _, migration := range mc.Migrations {
+		if migration.Report.Started() {
+			reports = append(reports, migration.Report)
+		}
+	}
+	return reports
+}
@@ -0,0 +1,71 @@
+package run
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFindChain(t *testing.T) {
+	// hop миграция 'from => to' из файла name
+	hop := func(name string, from string, to string) *MigrationSet {
+		return &MigrationSet{MigrationFile: name, FromRelease: from, ToRelease: to}
+	}
+	migrations := []*MigrationSet{
+		hop("a.yml", "v0.0.1", "v0.0.2"),
+		hop("b.yml", "0.0.2", "v0.0.3"),
+		hop("c.yml", "v0.0.3", "v0.0.4"),
+		hop("d.yml", "v0.0.1", "v0.0.3"),
+		hop("e.yml", "v0.0.4", "v0.0.5"),
+		hop("back.yml", "v0.0.4", "v0.0.1"),
+	}
+
+	tests := []struct {
+		name       string
+		migrations []*MigrationSet
+		from       string
+		to         string
+		want       []string
+		wantErr    string
+	}{
+		{name: "one hop", migrations: migrations, from: "v0.0.1", to: "v0.0.2", want: []string{"a.yml"}},
+		// Из двух путей выбирается кратчайший: d.yml вместо a.yml + b.yml
+		{name: "shortest", migrations: migrations, from: "v0.0.1", to: "v0.0.5", want: []string{"d.yml", "c.yml", "e.yml"}},
+		{name: "semver keys", migrations: migrations, from: "0.0.2", to: "v0.0.4", want: []string{"b.yml", "c.yml"}},
+		{name: "cycle in graph", migrations: migrations, from: "v0.0.4", to: "v0.0.2", want: []string{"back.yml", "a.yml"}},
+		{name: "already on release", migrations: migrations, from: "v0.0.3", to: "0.0.3"},
+		{name: "no path", migrations: migrations, from: "v0.0.5", to: "v0.0.1", wantErr: "no chain of migrations from 'v0.0.5' to 'v0.0.1'"},
+		{name: "unknown release", migrations: migrations, from: "v0.0.1", to: "v0.0.9", wantErr: "no chain of migrations"},
+		{
+			name:       "duplicate hop",
+			migrations: append([]*MigrationSet{hop("x.yml", "v0.0.2", "0.0.3")}, migrations...),
+			from:       "v0.0.1",
+			to:         "v0.0.2",
+			wantErr:    "'x.yml' and 'b.yml' both migrate '0.0.2' => 'v0.0.3'",
+		},
+		{name: "invalid target", migrations: migrations, from: "v0.0.1", to: "latest", wantErr: "target release"},
+		{name: "invalid migration release", migrations: []*MigrationSet{hop("bad.yml", "next", "v0.0.2")}, from: "v0.0.1", to: "v0.0.2", wantErr: "'bad.yml' 'from_release'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain, err := FindChain(tt.migrations, tt.from, tt.to)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FindChain() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FindChain() error: %v", err)
+			}
+			var files []string
+			for _, migration := range chain {
+				files = append(files, migration.MigrationFile)
+			}
+			if !reflect.DeepEqual(files, tt.want) {
+				t.Errorf("FindChain() = %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package run
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Граф зависимостей
+type DependencyGraph struct {
+	Actions      map[string]Action   // Карта действий
+	Dependencies map[string][]string // Зависимости для каждого действия
+	Order        []string            // Порядок добавления действий
+	mu           sync.Mutex
+}
+
+type Action struct {
+	Name       string
+	PluginType string
+	Component  map[string]interface{}
+	Action     map[string]interface{}
+	Rollback   map[string]interface{}
+	When       string // Условие выполнения отката
+	RolledBack bool   // Флаг: компенсирующее действие уже выполнено
+}
+
+// NewDependencyGraph создаёт пустой граф
+func NewDependencyGraph() *DependencyGraph {
+	return &DependencyGraph{
+		Actions:      make(map[string]Action),
+		Dependencies: make(map[string][]string),
+	}
+}
+
+// AddAction добавляет действие и его зависимости в граф
+func (g *DependencyGraph) AddAction(actionName string, action Action, dependencies []string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, exists := g.Actions[actionName]; exists {
+		return fmt.Errorf("[MigrationSet]>[AddActionToGraph] Action %s already exists", actionName)
+	}
+
+	g.Actions[actionName] = action
+	g.Dependencies[actionName] = dependencies
+	g.Order = append(g.Order, actionName)
+	return nil
+}
+
+// Validate проверяет, что все зависимости известны и граф не содержит циклов
+func (g *DependencyGraph) Validate() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for _, name := range g.Order {
+		for _, dep := range g.Dependencies[name] {
+			if _, ok := g.Actions[dep]; !ok {
+				return fmt.Errorf("[DependencyGraph]>[Valid] '%s' depends on unknown '%s'", name, dep)
+			}
+		}
+	}
+
+	// Поиск цикла обходом в глубину: 1 - в обработке, 2 - обработан
+	state := make(map[string]int)
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("[DependencyGraph]>[Valid] dependency cycle: %s -> %s", strings.Join(path, " -> "), name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		path = append(path, name)
+		for _, dep := range g.Dependencies[name] {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = 2
+		return nil
+	}
+
+	for _, name := range g.Order {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildStageGraph строит граф этапов по полю 'dependence'.
+// Этап может зависеть только от этапов своего уровня вложенности.
+func BuildStageGraph(stages []Stages, parentName string) (*DependencyGraph, error) {
+	graph := NewDependencyGraph()
+	if err := addStagesToGraph(graph, stages, parentName); err != nil {
+		return nil, err
+	}
+	if err := graph.Validate(); err != nil {
+		return nil, err
+	}
+	return graph, nil
+}
+
+func addStagesToGraph(graph *DependencyGraph, stages []Stages, parentName string) error {
+	for _, stage := range stages {
+		stageName := stage.setName(parentName, stage.Name)
+
+		dependence, err := stage.Dependencies()
+		if err != nil {
+			return fmt.Errorf("[Stage > %s]>[Valid] %v", stageName, err)
+		}
+
+		dependencies := make([]string, 0, len(dependence))
+		for _, dep := range dependence {
+			dependencies = append(dependencies, stage.setName(parentName, dep))
+		}
+
+		if err := graph.AddAction(stageName, Action{Name: stageName}, dependencies); err != nil {
+			return fmt.Errorf("[Stage > %s]>[Valid] duplicate stage name", stageName)
+		}
+
+		if err := addStagesToGraph(graph, stage.Stages, stageName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
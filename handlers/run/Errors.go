@@ -0,0 +1,162 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// ErrorKind категория ошибки выполнения. Категории упорядочены по важности:
+// при нескольких ошибках (например, шаг и его откат) итоговой считается старшая.
+type ErrorKind int
+
+const (
+	ERROR_UNKNOWN ErrorKind = iota
+	ERROR_VALIDATION
+	ERROR_PLUGIN_MISSING
+	ERROR_CHECK_FAILED
+	ERROR_ACTION_FAILED
+	ERROR_ROLLBACK_FAILED
+)
+
+// Коды завершения процесса по категориям ошибок
+var (
+	EXIT_CODE_OK              = 0
+	EXIT_CODE_UNKNOWN         = 1
+	EXIT_CODE_VALIDATION      = 2
+	EXIT_CODE_PLUGIN_MISSING  = 3
+	EXIT_CODE_CHECK_FAILED    = 4
+	EXIT_CODE_ACTION_FAILED   = 5
+	EXIT_CODE_ROLLBACK_FAILED = 6
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ERROR_VALIDATION:
+		return "validation"
+	case ERROR_PLUGIN_MISSING:
+		return "plugin missing"
+	case ERROR_CHECK_FAILED:
+		return "check failed"
+	case ERROR_ACTION_FAILED:
+		return "action failed"
+	case ERROR_ROLLBACK_FAILED:
+		return "rollback failed"
+	}
+	return "unknown"
+}
+
+// ExitCode возвращает код завершения процесса для категории
+func (k ErrorKind) ExitCode() int {
+	switch k {
+	case ERROR_VALIDATION:
+		return EXIT_CODE_VALIDATION
+	case ERROR_PLUGIN_MISSING:
+		return EXIT_CODE_PLUGIN_MISSING
+	case ERROR_CHECK_FAILED:
+		return EXIT_CODE_CHECK_FAILED
+	case ERROR_ACTION_FAILED:
+		return EXIT_CODE_ACTION_FAILED
+	case ERROR_ROLLBACK_FAILED:
+		return EXIT_CODE_ROLLBACK_FAILED
+	}
+	return EXIT_CODE_UNKNOWN
+}
+
+// RunError ошибка с категорией. Текст ошибки не меняется.
+type RunError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *RunError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RunError) Unwrap() error {
+	return e.Err
+}
+
+// newRunError присваивает ошибке категорию kind. Уже категоризированная ошибка
+// (например, отсутствующий плагин внутри шага) сохраняет свою категорию.
+func newRunError(kind ErrorKind, err error) error {
+	if err == nil {
+		return nil
+	}
+	var runErr *RunError
+	if errors.As(err, &runErr) {
+		return err
+	}
+	return &RunError{Kind: kind, Err: err}
+}
+
+// ErrorKindOf возвращает старшую категорию среди всех ошибок в цепочке err
+func ErrorKindOf(err error) ErrorKind {
+
+	kind := ERROR_UNKNOWN
+	switch e := err.(type) {
+	case nil:
+		return kind
+	case *RunError:
+		kind = e.Kind
+	}
+
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		if inner := ErrorKindOf(e.Unwrap()); inner > kind {
+			kind = inner
+		}
+	case interface{ Unwrap() []error }:
+		for _, wrapped := range e.Unwrap() {
+			if inner := ErrorKindOf(wrapped); inner > kind {
+				kind = inner
+			}
+		}
+	}
+	return kind
+}
+
+// ExitCode возвращает код завершения процесса для ошибки; nil - успешное завершение
+func ExitCode(err error) int {
+	if err == nil {
+		return EXIT_CODE_OK
+	}
+	return ErrorKindOf(err).ExitCode()
+}
+
+// lookupExecutor возвращает исполнитель плагина pluginType. Отсутствующий плагин
+// устанавливается из репозитория, если install=true.
+func lookupExecutor(pc *plugin.PluginController, kind string, stepName string, pluginType string, install bool, logMessage func(string, string, ...interface{})) (v1.Executor, error) {
+
+	if executor, ok := pc.Executor(pluginType); ok {
+		return executor, nil
+	}
+	missing := fmt.Errorf("[%s:'%s'] '%s.Plugin' плагин для типа '%s' не найден", kind, stepName, kind, pluginType)
+	if !install {
+		return nil, newRunError(ERROR_PLUGIN_MISSING, missing)
+	}
+
+	logMessage("ERROR", fmt.Sprintf("%v. Попытка установки", missing))
+	if err := pc.InstallPlugin(pluginType); err != nil {
+		return nil, newRunError(ERROR_PLUGIN_MISSING, fmt.Errorf("%v: %v", missing, err))
+	}
+	// Установленный плагин может не предоставлять тип pluginType
+	if executor, ok := pc.Executor(pluginType); ok {
+		return executor, nil
+	}
+	return nil, newRunError(ERROR_PLUGIN_MISSING, fmt.Errorf("%v: installed plugin does not provide '%s'", missing, pluginType))
+}
+
+// validationExecutor возвращает исполнитель для проверки шага или компонента. Без установки
+// отсутствующий плагин не считается ошибкой: возвращается nil, проверка плагином пропускается.
+func validationExecutor(pc *plugin.PluginController, kind string, stepName string, pluginType string, install bool, logMessage func(string, string, ...interface{})) (v1.Executor, error) {
+
+	executor, err := lookupExecutor(pc, kind, stepName, pluginType, install, logMessage)
+	if err != nil && !install && ErrorKindOf(err) == ERROR_PLUGIN_MISSING {
+		logMessage("WARN", fmt.Sprintf("%v. Plugin validation skipped", err))
+		return nil, nil
+	}
+	return executor, err
+}
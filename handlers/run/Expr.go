@@ -0,0 +1,587 @@
+package run
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
+)
+
+// Выражения 'when' этапов и шагов. Видят те же значения, что и шаблоны:
+// steps, stand, component, ms.
+//
+//	when: stand.name == 'PROD' && component.version < '1.12.0'
+//	when: "'critical' in stand.tags || !defined(steps.Adapter.get_ver.stdout)"
+//	when: steps.Adapter.check_db.status != 'skipped' and ms.to_release =~ '^v2'
+//
+// Операторы: == != < <= > >= =~ in, && || ! (или and, or, not), скобки.
+// Сравнение '<'/'>' строк, похожих на версии, выполняется по semver.
+// Функции: defined(путь), len(x), lower(x), upper(x).
+// Результат выражения должен быть true или false.
+
+// Expr разобранное выражение
+type Expr struct {
+	Source string
+	root   exprNode
+	roots  map[string]bool // Корни путей в выражении: steps, stand, component, ms
+}
+
+type exprNode interface {
+	eval(scope map[string]interface{}) (interface{}, error)
+}
+
+// ParseExpr разбирает выражение без его вычисления
+func ParseExpr(source string) (*Expr, error) {
+
+	tokens, err := tokenizeExpr(source)
+	if err != nil {
+		return nil, fmt.Errorf("expression '%s': %v", source, err)
+	}
+	p := &exprParser{tokens: tokens, roots: make(map[string]bool)}
+	root, err := p.parseOr()
+	if err == nil && !p.done() {
+		err = fmt.Errorf("unexpected '%s'", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("expression '%s': %v", source, err)
+	}
+	return &Expr{Source: source, root: root, roots: p.roots}, nil
+}
+
+// Uses сообщает, обращается ли выражение к значениям root ('component', 'steps' и т.п.)
+func (e *Expr) Uses(root string) bool {
+	return e.roots[root]
+}
+
+// Eval вычисляет выражение как условие
+func (e *Expr) Eval(scope map[string]interface{}) (bool, error) {
+	value, err := e.root.eval(scope)
+	if err != nil {
+		return false, fmt.Errorf("expression '%s': %v", e.Source, err)
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expression '%s': result must be true or false, got '%v'", e.Source, value)
+	}
+	return result, nil
+}
+
+// EvalWhen вычисляет условие 'when'; пустое условие истинно
+func EvalWhen(when string, scope map[string]interface{}) (bool, error) {
+	expr, err := parseWhen(when)
+	if expr == nil || err != nil {
+		return err == nil, err
+	}
+	return expr.Eval(scope)
+}
+
+// ValidateWhen проверяет синтаксис условия 'when'
+func ValidateWhen(when string) error {
+	_, err := parseWhen(when)
+	return err
+}
+
+// parseWhen разбирает условие 'when'; для пустого условия возвращает nil
+func parseWhen(when string) (*Expr, error) {
+	if strings.TrimSpace(when) == "" {
+		return nil, nil
+	}
+	return ParseExpr(when)
+}
+
+const (
+	tokenEOF = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+}
+
+var exprOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")", "[", "]", ","}
+
+func tokenizeExpr(source string) ([]exprToken, error) {
+
+	var tokens []exprToken
+	runes := []rune(source)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '\'' || r == '"':
+			end := i + 1
+			var b strings.Builder
+			for ; end < len(runes) && runes[end] != r; end++ {
+				// В двойных кавычках \" и \\ экранируют символ, остальное берётся как есть
+				if r == '"' && runes[end] == '\\' && end+1 < len(runes) && (runes[end+1] == '"' || runes[end+1] == '\\') {
+					end++
+				}
+				b.WriteRune(runes[end])
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, exprToken{kind: tokenString, text: b.String()})
+			i = end + 1
+
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenNumber, text: string(runes[i:end])})
+			i = end
+
+		case unicode.IsLetter(r) || r == '_':
+			// Путь: имена через точку, в именах шагов допустим '-'
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || strings.ContainsRune("_-.", runes[end])) {
+				end++
+			}
+			tokens = append(tokens, exprToken{kind: tokenIdent, text: string(runes[i:end])})
+			i = end
+
+		default:
+			matched := false
+			for _, op := range exprOperators {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					tokens = append(tokens, exprToken{kind: tokenOp, text: op})
+					i += len([]rune(op))
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character '%c'", r)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+	roots  map[string]bool
+}
+
+func (p *exprParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *exprParser) peek() exprToken {
+	if p.done() {
+		return exprToken{kind: tokenEOF, text: "end of expression"}
+	}
+	return p.tokens[p.pos]
+}
+
+// accept пропускает оператор или ключевое слово, если оно следующее
+func (p *exprParser) accept(texts ...string) (string, bool) {
+	token := p.peek()
+	if token.kind != tokenOp && token.kind != tokenIdent {
+		return "", false
+	}
+	for _, text := range texts {
+		if token.text == text {
+			p.pos++
+			return text, true
+		}
+	}
+	return "", false
+}
+
+func (p *exprParser) expect(text string) error {
+	if _, ok := p.accept(text); !ok {
+		return fmt.Errorf("expected '%s', got '%s'", text, p.peek().text)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("||", "or"); !ok {
+			return left, nil
+		}
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{or: true, left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept("&&", "and"); !ok {
+			return left, nil
+		}
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{left: left, right: right}
+	}
+}
+
+func (p *exprParser) parseNot() (exprNode, error) {
+	if _, ok := p.accept("!", "not"); ok {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand: operand}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *exprParser) parseCompare() (exprNode, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">", "=~", "in")
+	if !ok {
+		return left, nil
+	}
+	right, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if literal, isLiteral := right.(*literalNode); op == "=~" && isLiteral {
+		if _, err := regexp.Compile(fmt.Sprint(literal.value)); err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+	}
+	return &compareNode{op: op, left: left, right: right}, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+
+	token := p.peek()
+	switch token.kind {
+	case tokenString:
+		p.pos++
+		return &literalNode{value: token.text}, nil
+
+	case tokenNumber:
+		p.pos++
+		// '1.12.0' без кавычек - строка версии
+		if number, err := strconv.ParseFloat(token.text, 64); err == nil {
+			return &literalNode{value: number}, nil
+		}
+		return &literalNode{value: token.text}, nil
+
+	case tokenIdent:
+		p.pos++
+		switch token.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(token.text)
+		}
+		keys := strings.Split(token.text, ".")
+		p.roots[keys[0]] = true
+		return &pathNode{keys: keys}, nil
+
+	case tokenOp:
+		switch token.text {
+		case "(":
+			p.pos++
+			node, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			return node, p.expect(")")
+		case "[":
+			p.pos++
+			list := &listNode{}
+			if _, ok := p.accept("]"); ok {
+				return list, nil
+			}
+			for {
+				item, err := p.parsePrimary()
+				if err != nil {
+					return nil, err
+				}
+				list.items = append(list.items, item)
+				if _, ok := p.accept(","); !ok {
+					return list, p.expect("]")
+				}
+			}
+		}
+	}
+	return nil, fmt.Errorf("unexpected '%s'", token.text)
+}
+
+func (p *exprParser) parseCall(name string) (exprNode, error) {
+
+	switch name {
+	case "defined", "len", "lower", "upper":
+	default:
+		return nil, fmt.Errorf("unknown function '%s'", name)
+	}
+
+	arg, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if _, isPath := arg.(*pathNode); name == "defined" && !isPath {
+		return nil, fmt.Errorf("defined() expects a path")
+	}
+	return &callNode{name: name, arg: arg}, nil
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(scope map[string]interface{}) (interface{}, error) {
+	return n.value, nil
+}
+
+type pathNode struct {
+	keys []string
+}
+
+func (n *pathNode) eval(scope map[string]interface{}) (interface{}, error) {
+	return lookupPath(scope, n.keys)
+}
+
+type listNode struct {
+	items []exprNode
+}
+
+func (n *listNode) eval(scope map[string]interface{}) (interface{}, error) {
+	list := make([]interface{}, 0, len(n.items))
+	for _, item := range n.items {
+		value, err := item.eval(scope)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, value)
+	}
+	return list, nil
+}
+
+type notNode struct {
+	operand exprNode
+}
+
+func (n *notNode) eval(scope map[string]interface{}) (interface{}, error) {
+	value, err := evalBool(n.operand, scope)
+	if err != nil {
+		return nil, err
+	}
+	return !value, nil
+}
+
+// logicalNode && и ||; правая часть вычисляется, только если она нужна
+type logicalNode struct {
+	or          bool
+	left, right exprNode
+}
+
+func (n *logicalNode) eval(scope map[string]interface{}) (interface{}, error) {
+	left, err := evalBool(n.left, scope)
+	if err != nil {
+		return nil, err
+	}
+	if left == n.or {
+		return left, nil
+	}
+	return evalBool(n.right, scope)
+}
+
+type callNode struct {
+	name string
+	arg  exprNode
+}
+
+func (n *callNode) eval(scope map[string]interface{}) (interface{}, error) {
+
+	value, err := n.arg.eval(scope)
+	if n.name == "defined" {
+		return err == nil && value != nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.name {
+	case "len":
+		switch v := value.(type) {
+		case []interface{}:
+			return float64(len(v)), nil
+		case map[string]interface{}:
+			return float64(len(v)), nil
+		case nil:
+			return float64(0), nil
+		}
+		return float64(len(templateString(value))), nil
+	case "lower":
+		return strings.ToLower(templateString(value)), nil
+	case "upper":
+		return strings.ToUpper(templateString(value)), nil
+	}
+	return nil, fmt.Errorf("unknown function '%s'", n.name)
+}
+
+type compareNode struct {
+	op          string
+	left, right exprNode
+}
+
+func (n *compareNode) eval(scope map[string]interface{}) (interface{}, error) {
+
+	left, err := n.left.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(scope)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return valuesEqual(left, right), nil
+	case "!=":
+		return !valuesEqual(left, right), nil
+	case "=~":
+		re, err := regexp.Compile(templateString(right))
+		if err != nil {
+			return nil, fmt.Errorf("invalid regular expression: %v", err)
+		}
+		return re.MatchString(templateString(left)), nil
+	case "in":
+		return contains(right, left), nil
+	}
+
+	cmp, err := compareValues(left, right)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", n.op)
+}
+
+func evalBool(node exprNode, scope map[string]interface{}) (bool, error) {
+	value, err := node.eval(scope)
+	if err != nil {
+		return false, err
+	}
+	result, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("'%v' is not true or false", value)
+	}
+	return result, nil
+}
+
+// toNumber приводит числа из YAML и журнала к float64
+func toNumber(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+func valuesEqual(left interface{}, right interface{}) bool {
+	if l, ok := toNumber(left); ok {
+		if r, ok := toNumber(right); ok {
+			return l == r
+		}
+	}
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, ok := left.(bool); ok {
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return templateString(left) == templateString(right)
+}
+
+// compareValues сравнивает числа, затем версии, затем строки
+func compareValues(left interface{}, right interface{}) (int, error) {
+
+	l, lok := toNumber(left)
+	r, rok := toNumber(right)
+	if lok && rok {
+		switch {
+		case l < r:
+			return -1, nil
+		case l > r:
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	if left == nil || right == nil {
+		return 0, fmt.Errorf("cannot compare '%v' and '%v'", left, right)
+	}
+	ls, rs := templateString(left), templateString(right)
+	if cmp, err := semver.CompareStrings(ls, rs); err == nil {
+		return cmp, nil
+	}
+	return strings.Compare(ls, rs), nil
+}
+
+func contains(container interface{}, item interface{}) bool {
+	switch c := container.(type) {
+	case []interface{}:
+		for _, value := range c {
+			if valuesEqual(value, item) {
+				return true
+			}
+		}
+		return false
+	case map[string]interface{}:
+		_, ok := c[templateString(item)]
+		return ok
+	case nil:
+		return false
+	}
+	return strings.Contains(templateString(container), templateString(item))
+}
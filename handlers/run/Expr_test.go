@@ -0,0 +1,183 @@
+package run
+
+import (
+	"strings"
+	"testing"
+)
+
+// exprScope значения, которые видят выражения 'when' на компоненте c1 стенда PROD
+func exprScope() map[string]interface{} {
+	outputs := NewOutputs()
+	outputs.Set("MDM.Adapter", "get_ver", map[string]interface{}{"stdout": "1.2.3\n", "code": 0})
+
+	return map[string]interface{}{
+		"steps": outputs,
+		"stand": map[string]interface{}{
+			"name": "PROD",
+			"tags": []interface{}{"critical", "eu"},
+		},
+		"component": map[string]interface{}{
+			"name":    "c1",
+			"version": "1.9.0",
+			"port":    8080,
+			"weight":  0.5,
+			"pattern": "(",
+		},
+		"ms": map[string]interface{}{
+			"from_release": "v1.9.0",
+			"to_release":   "v2.1.0",
+		},
+	}
+}
+
+func TestEvalWhen(t *testing.T) {
+	tests := []struct {
+		name string
+		when string
+		want bool
+	}{
+		{name: "empty", when: "", want: true},
+		{name: "blank", when: "  ", want: true},
+		{name: "literal", when: "false", want: false},
+
+		// Приоритет: ! выше &&, && выше ||, сравнение выше !
+		{name: "and before or", when: "true || false && false", want: true},
+		{name: "parentheses", when: "(true || false) && false", want: false},
+		{name: "not before and", when: "!false && false", want: false},
+		{name: "double not", when: "!!true", want: true},
+		{name: "compare before not", when: "!stand.name == 'DEV'", want: true},
+		{name: "words", when: "not (false or true) and true", want: false},
+		{name: "left to right", when: "false || false || true", want: true},
+
+		// Правая часть не вычисляется, если результат уже известен
+		{name: "short circuit and", when: "false && nosuch.path == 1", want: false},
+		{name: "short circuit or", when: "true || nosuch.path", want: true},
+
+		{name: "equal string", when: "stand.name == 'PROD'", want: true},
+		{name: "equal double quotes", when: `stand.name == "PROD"`, want: true},
+		{name: "not equal", when: "stand.name != 'PROD'", want: false},
+		{name: "not null", when: "component.name != null", want: true},
+		{name: "regex", when: "ms.to_release =~ '^v2'", want: true},
+		{name: "regex no match", when: "stand.name =~ '^DEV'", want: false},
+
+		{name: "in list", when: "'critical' in stand.tags", want: true},
+		{name: "not in list", when: "'us' in stand.tags", want: false},
+		{name: "in literal list", when: "stand.name in ['PRE', 'PROD']", want: true},
+		{name: "in empty list", when: "stand.name in []", want: false},
+		{name: "number in list", when: "component.port in [80, 8080]", want: true},
+		{name: "in map keys", when: "'version' in component", want: true},
+		{name: "substring", when: "'RO' in stand.name", want: true},
+		{name: "in null", when: "'x' in null", want: false},
+
+		{name: "defined", when: "defined(component.name)", want: true},
+		{name: "not defined", when: "defined(component.missing)", want: false},
+		{name: "defined under missing", when: "defined(component.missing.deeper)", want: false},
+		{name: "defined step output", when: "defined(steps.Adapter.get_ver.stdout)", want: true},
+		{name: "undefined stage", when: "!defined(steps.Other.get_ver.stdout)", want: true},
+
+		// Числа сравниваются как числа, даже если в строке они упорядочены иначе
+		{name: "number", when: "component.port > 900", want: true},
+		{name: "number equal int and float", when: "component.port == 8080.0", want: true},
+		{name: "float", when: "component.weight < 1", want: true},
+		{name: "step output number", when: "steps.Adapter.get_ver.code == 0", want: true},
+		{name: "len", when: "len(stand.tags) == 2", want: true},
+		{name: "len string", when: "len(stand.name) >= 4", want: true},
+
+		// Строки, похожие на версии, сравниваются по semver
+		{name: "semver", when: "component.version < '1.12.0'", want: true},
+		{name: "semver with v", when: "ms.to_release >= 'v2.0.0'", want: true},
+		{name: "semver prerelease", when: "'2.0.0-rc.1' < '2.0.0'", want: true},
+		{name: "unquoted version", when: "component.version == 1.9.0", want: true},
+		{name: "unquoted version compare", when: "component.version <= 1.10.0", want: true},
+
+		// Остальные строки сравниваются лексикографически
+		{name: "string", when: "stand.name > 'ABC'", want: true},
+		{name: "string mixed", when: "'release-b' > 'release-a'", want: true},
+
+		{name: "lower", when: "lower(stand.name) == 'prod'", want: true},
+		{name: "upper", when: "upper(component.name) == 'C1'", want: true},
+		{name: "step output regex", when: "steps.MDM.Adapter.get_ver.stdout =~ '^1\\.2'", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := EvalWhen(tt.when, exprScope())
+			if err != nil {
+				t.Fatalf("EvalWhen(%q) error: %v", tt.when, err)
+			}
+			if got != tt.want {
+				t.Errorf("EvalWhen(%q) = %v, want %v", tt.when, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalWhenErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		when    string
+		wantErr string
+	}{
+		{name: "not boolean", when: "stand.name", wantErr: "result must be true or false"},
+		{name: "not boolean operand", when: "stand.name && true", wantErr: "is not true or false"},
+		{name: "undefined", when: "nosuch.path == 1", wantErr: "'nosuch' is not defined"},
+		{name: "undefined field", when: "stand.region == 'eu'", wantErr: "'stand.region' is not defined"},
+		{name: "no outputs", when: "steps.Adapter.other.stdout == 'x'", wantErr: "has no outputs yet"},
+		{name: "compare null", when: "component.port < null", wantErr: "cannot compare"},
+		{name: "regex from value", when: "stand.name =~ component.pattern", wantErr: "invalid regular expression"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := EvalWhen(tt.when, exprScope())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("EvalWhen(%q) error = %v, want %q", tt.when, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateWhen(t *testing.T) {
+	tests := []struct {
+		when    string
+		wantErr string
+	}{
+		{when: "stand.name == 'PROD' && (component.version < '1.12.0' || !defined(steps.A.b.c))"},
+		{when: "a ==", wantErr: "unexpected 'end of expression'"},
+		{when: "(true", wantErr: "expected ')'"},
+		{when: "true false", wantErr: "unexpected 'false'"},
+		{when: "'abc", wantErr: "unterminated string"},
+		{when: "a # b", wantErr: "unexpected character '#'"},
+		{when: "exists(a)", wantErr: "unknown function 'exists'"},
+		{when: "defined('a')", wantErr: "defined() expects a path"},
+		{when: "a =~ '('", wantErr: "invalid regular expression"},
+		{when: "a in [1, 2", wantErr: "expected ']'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.when, func(t *testing.T) {
+			err := ValidateWhen(tt.when)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateWhen(%q) error: %v", tt.when, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateWhen(%q) error = %v, want %q", tt.when, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExprUses(t *testing.T) {
+	expr, err := ParseExpr("stand.name == 'PROD' && defined(steps.Adapter.get_ver.stdout) && 'x' in ['x']")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for root, want := range map[string]bool{"stand": true, "steps": true, "component": false, "ms": false} {
+		if got := expr.Uses(root); got != want {
+			t.Errorf("Uses(%q) = %v, want %v", root, got, want)
+		}
+	}
+}
@@ -0,0 +1,318 @@
+package run
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	JOURNAL_STATUS_RUNNING = "running"
+	JOURNAL_STATUS_OK      = "ok"
+	JOURNAL_STATUS_FAILED  = "failed"
+	JOURNAL_STATUS_SKIPPED = "skipped" // Пропущен по условию 'when'
+)
+
+var (
+	DEFAULT_JOURNAL_DIR = "./journal"
+
+	// DEFAULT_OUTPUTS_REDACT_FUNC маскирует выходные значения шага перед записью в журнал.
+	// Задаётся приложением (маскировщик логов); пока не задан, маскируются ссылки на секреты.
+	DEFAULT_OUTPUTS_REDACT_FUNC OutputsRedactFunc
+)
+
+// OutputsRedactFunc возвращает копию выходных значений шага без секретов
+type OutputsRedactFunc func(outputs map[string]interface{}) map[string]interface{}
+
+// JournalEntry описывает состояние одного шага миграции
+type JournalEntry struct {
+	Name      string                 `json:"name"`
+	Kind      string                 `json:"kind"`
+	Status    string                 `json:"status"`
+	StartTime time.Time              `json:"start_time"`
+	EndTime   time.Time              `json:"end_time,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Reason    string                 `json:"reason,omitempty"`  // Причина пропуска
+	Outputs   map[string]interface{} `json:"outputs,omitempty"` // Выходные значения успешного шага
+}
+
+// Journal хранит на диске ход выполнения миграции для продолжения после сбоя
+type Journal struct {
+	Path          string                  `json:"-"`
+	MigrationFile string                  `json:"migration_file"`
+	MigrationHash string                  `json:"migration_hash"`
+	Stand         string                  `json:"stand"`
+	Steps         map[string]JournalEntry `json:"steps"`
+	references    [][]string              // Пути 'steps.*' из файла миграции: какие выходные значения сохранять
+	mu            sync.Mutex
+}
+
+// outputReferenceRegexp ссылка на результат шага в шаблоне или условии 'when'
+var outputReferenceRegexp = regexp.MustCompile(`steps\.([\pL\pN_.-]+)`)
+
+// NewJournal создаёт журнал для пары 'файл миграции + стенд'.
+// При resume=true загружается ранее сохранённый журнал, если он существует.
+func NewJournal(journalDir string, migrationFile string, stand string, resume bool) (*Journal, error) {
+
+	hash, err := fileHash(migrationFile)
+	if err != nil {
+		return nil, fmt.Errorf("[Journal]>[New] %v", err)
+	}
+
+	if err := os.MkdirAll(journalDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("[Journal]>[New] failed to create journal directory: %v", err)
+	}
+
+	journal := &Journal{
+		Path:          filepath.Join(journalDir, journalFileName(hash, stand)),
+		MigrationFile: migrationFile,
+		MigrationHash: hash,
+		Stand:         stand,
+		Steps:         make(map[string]JournalEntry),
+		references:    outputReferences(migrationFile),
+	}
+
+	if resume {
+		data, err := os.ReadFile(journal.Path)
+		if err == nil {
+			saved := &Journal{}
+			if err := json.Unmarshal(data, saved); err != nil {
+				return nil, fmt.Errorf("[Journal]>[New] failed to decode '%s': %v", journal.Path, err)
+			}
+			// Журнал от другой версии файла миграции или другого стенда не используется
+			if saved.MigrationHash == hash && saved.Stand == stand && saved.Steps != nil {
+				journal.Steps = saved.Steps
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("[Journal]>[New] failed to read '%s': %v", journal.Path, err)
+		}
+	}
+
+	return journal, journal.save()
+}
+
+// Succeeded сообщает, завершился ли шаг успешно в одном из предыдущих запусков
+func (j *Journal) Succeeded(stepName string) bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.Steps[stepName]
+	return ok && entry.Status == JOURNAL_STATUS_OK
+}
+
+// Outputs возвращает выходные значения шага, сохранённые в журнале. Замаскированные значения
+// не восстанавливаются: их пути возвращаются в masked, а шаблоны с ними завершатся ошибкой.
+func (j *Journal) Outputs(stepName string) (outputs map[string]interface{}, masked []string) {
+	if j == nil {
+		return nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	saved := j.Steps[stepName].Outputs
+	if saved == nil {
+		return nil, nil
+	}
+	outputs = withoutMasked(saved, "", &masked).(map[string]interface{})
+	sort.Strings(masked)
+	return outputs, masked
+}
+
+// Referenced возвращает выходные значения шага stepName этапа stageName, на которые ссылаются
+// шаблоны и условия миграции. Только они нужны при продолжении (--resume) и сохраняются в журнал.
+func (j *Journal) Referenced(stageName string, stepName string, outputs map[string]interface{}) map[string]interface{} {
+	if j == nil || outputs == nil {
+		return nil
+	}
+
+	keep := map[string]bool{"status": true}
+	for _, ref := range j.references {
+		for n := 1; n < len(ref); n++ {
+			stage := strings.Join(ref[:n], ".")
+			if ref[n] != stepName || (stage != stageName && !strings.HasSuffix(stageName, "."+stage)) {
+				continue
+			}
+			// Ссылка на результат шага целиком
+			if n+1 == len(ref) {
+				return outputs
+			}
+			keep[ref[n+1]] = true
+		}
+	}
+
+	referenced := make(map[string]interface{}, len(keep))
+	for key, value := range outputs {
+		if keep[key] {
+			referenced[key] = value
+		}
+	}
+	return referenced
+}
+
+// Start отмечает начало выполнения шага
+func (j *Journal) Start(stepName string, kind string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.Steps[stepName] = JournalEntry{
+		Name:      stepName,
+		Kind:      kind,
+		Status:    JOURNAL_STATUS_RUNNING,
+		StartTime: time.Now(),
+	}
+	return j.save()
+}
+
+// Finish отмечает завершение шага с результатом stepErr и выходными значениями outputs.
+// Выходные значения сохраняются замаскированными (DEFAULT_OUTPUTS_REDACT_FUNC).
+func (j *Journal) Finish(stepName string, stepErr error, outputs map[string]interface{}) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry := j.Steps[stepName]
+	entry.EndTime = time.Now()
+	if stepErr != nil {
+		entry.Status = JOURNAL_STATUS_FAILED
+		entry.Error = stepErr.Error()
+	} else {
+		entry.Status = JOURNAL_STATUS_OK
+		entry.Error = ""
+		entry.Reason = ""
+		entry.Outputs = redactOutputs(outputs)
+	}
+	j.Steps[stepName] = entry
+	return j.save()
+}
+
+// Skip отмечает шаг или этап пропущенным с причиной reason.
+// Шаг, успешно выполненный в предыдущем запуске, остаётся выполненным.
+func (j *Journal) Skip(stepName string, kind string, reason string) error {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	entry, ok := j.Steps[stepName]
+	if ok && entry.Status == JOURNAL_STATUS_OK {
+		return nil
+	}
+	now := time.Now()
+	if !ok || entry.StartTime.IsZero() {
+		entry.StartTime = now
+	}
+	entry.Name = stepName
+	entry.Kind = kind
+	entry.Status = JOURNAL_STATUS_SKIPPED
+	entry.EndTime = now
+	entry.Error = ""
+	entry.Reason = reason
+	j.Steps[stepName] = entry
+	return j.save()
+}
+
+// redactOutputs маскирует выходные значения шага: журнал хранится на диске открытым текстом
+func redactOutputs(outputs map[string]interface{}) map[string]interface{} {
+	if outputs == nil {
+		return nil
+	}
+	if DEFAULT_OUTPUTS_REDACT_FUNC != nil {
+		return DEFAULT_OUTPUTS_REDACT_FUNC(outputs)
+	}
+	return RedactSecrets(outputs).(map[string]interface{})
+}
+
+// withoutMasked возвращает копию значения без замаскированных значений ключей;
+// пути удалённых ключей добавляются в masked
+func withoutMasked(value interface{}, path string, masked *[]string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		kept := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			if item == SECRET_REDACTED {
+				*masked = append(*masked, itemPath)
+				continue
+			}
+			kept[key] = withoutMasked(item, itemPath, masked)
+		}
+		return kept
+	case []interface{}:
+		kept := make([]interface{}, len(v))
+		for i, item := range v {
+			kept[i] = withoutMasked(item, fmt.Sprintf("%s.%d", path, i), masked)
+		}
+		return kept
+	}
+	return value
+}
+
+// save атомарно записывает журнал на диск. Вызывается под блокировкой.
+func (j *Journal) save() error {
+
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("[Journal]>[Save] %v", err)
+	}
+
+	tmpPath := j.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("[Journal]>[Save] failed to write '%s': %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, j.Path); err != nil {
+		return fmt.Errorf("[Journal]>[Save] failed to rename '%s': %v", tmpPath, err)
+	}
+	return nil
+}
+
+// outputReferences возвращает пути ссылок 'steps.<этап>.<шаг>.<ключ>' из файла миграции
+func outputReferences(migrationFile string) [][]string {
+	data, err := os.ReadFile(migrationFile)
+	if err != nil {
+		return nil
+	}
+	var references [][]string
+	for _, match := range outputReferenceRegexp.FindAllStringSubmatch(string(data), -1) {
+		path := strings.Trim(match[1], ".")
+		if path != "" {
+			references = append(references, strings.Split(path, "."))
+		}
+	}
+	return references
+}
+
+// fileHash возвращает SHA-256 содержимого файла
+func fileHash(filePath string) (string, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("ErrorReadFile '%s': %v", filePath, err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// journalFileName формирует имя файла журнала из хэша миграции и имени стенда
+func journalFileName(hash string, stand string) string {
+	replacer := strings.NewReplacer("/", "_", "\\", "_", " ", "_", ":", "_")
+	return fmt.Sprintf("%s_%s.json", hash[:12], replacer.Replace(stand))
+}
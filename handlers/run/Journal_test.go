@@ -0,0 +1,155 @@
+package run
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+const testJournalStages = `stages:
+- name: "A"
+  task:
+  - {name: "t1", plugin: "fake", component: {name: "db"}, action: {cmd: "t1"}}
+  - {name: "t2", plugin: "fake", component: {name: "db"}, action: {cmd: "t2"}}
+- name: "B"
+  dependence: "A"
+  task:
+  - {name: "t3", plugin: "fake", component: {name: "db"}, action: {cmd: "t3"}}
+`
+
+// runTestJournal выполняет миграцию из файла migrationFile с журналом в journalDir
+func runTestJournal(t *testing.T, migrationFile string, executor *fakeExecutor, journalDir string, resume bool) error {
+	t.Helper()
+
+	pc := &plugin.PluginController{ExecutorPluginRegistry: map[string]v1.Executor{"fake": executor}}
+	var ms *MigrationSet
+	ms, err := ms.NewMigrationSet(migrationFile, pc, testLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.OpenJournal(journalDir, resume, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	return runTestMigration(t, ms)
+}
+
+func TestJournalResume(t *testing.T) {
+	executor := &fakeExecutor{fail: map[string]bool{"t2": true}}
+	migrationFile := newTestMigrationSet(t, testJournalStages, executor).MigrationFile
+	journalDir := t.TempDir()
+
+	if err := runTestJournal(t, migrationFile, executor, journalDir, false); err == nil {
+		t.Fatal("first run: want error")
+	}
+	if got, want := executor.Calls(), []string{"t1", "t2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("first run calls = %v, want %v", got, want)
+	}
+
+	// Без --resume журнал не используется
+	executor = &fakeExecutor{fail: map[string]bool{"t2": true}}
+	if err := runTestJournal(t, migrationFile, executor, journalDir, false); err == nil {
+		t.Fatal("second run: want error")
+	}
+	if got, want := executor.Calls(), []string{"t1", "t2"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("second run calls = %v, want %v", got, want)
+	}
+
+	// Продолжение пропускает успешно выполненные шаги и повторяет неуспешный
+	executor = &fakeExecutor{}
+	if err := runTestJournal(t, migrationFile, executor, journalDir, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := executor.Calls(), []string{"t2", "t3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("resumed run calls = %v, want %v", got, want)
+	}
+}
+
+func TestJournalResumeChangedMigration(t *testing.T) {
+	executor := &fakeExecutor{fail: map[string]bool{"t2": true}}
+	migrationFile := newTestMigrationSet(t, testJournalStages, executor).MigrationFile
+	journalDir := t.TempDir()
+
+	if err := runTestJournal(t, migrationFile, executor, journalDir, false); err == nil {
+		t.Fatal("first run: want error")
+	}
+
+	// После изменения файла миграции все шаги выполняются заново
+	data, err := os.ReadFile(migrationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, migrationFile, string(data)+"# changed\n")
+
+	executor = &fakeExecutor{}
+	if err := runTestJournal(t, migrationFile, executor, journalDir, true); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := executor.Calls(), []string{"t1", "t2", "t3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestNewJournalMismatch(t *testing.T) {
+	migrationFile := filepath.Join(t.TempDir(), "migration.yml")
+	writeTestFile(t, migrationFile, "stages: []\n")
+
+	tests := []struct {
+		name      string
+		hash      string
+		stand     string
+		wantSteps int
+	}{
+		{name: "same migration and stand", stand: "A", wantSteps: 1},
+		{name: "other migration hash", hash: "0000", stand: "A"},
+		{name: "other stand", stand: "B"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			journalDir := t.TempDir()
+			journal, err := NewJournal(journalDir, migrationFile, "A", false)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Журнал на месте файла стенда 'A' записан для другой миграции или стенда
+			saved := Journal{
+				MigrationFile: migrationFile,
+				MigrationHash: journal.MigrationHash,
+				Stand:         tt.stand,
+				Steps:         map[string]JournalEntry{"A.task.t1": {Name: "A.task.t1", Status: JOURNAL_STATUS_OK}},
+			}
+			if tt.hash != "" {
+				saved.MigrationHash = tt.hash
+			}
+			data, err := json.Marshal(&saved)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeTestFile(t, journal.Path, string(data))
+
+			resumed, err := NewJournal(journalDir, migrationFile, "A", true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resumed.Steps) != tt.wantSteps || resumed.Succeeded("A.task.t1") != (tt.wantSteps == 1) {
+				t.Errorf("NewJournal() steps = %v, want %d", resumed.Steps, tt.wantSteps)
+			}
+		})
+	}
+
+	// Повреждённый журнал - ошибка
+	journal, err := NewJournal(t.TempDir(), migrationFile, "A", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, journal.Path, "{")
+	if _, err := NewJournal(filepath.Dir(journal.Path), migrationFile, "A", true); err == nil {
+		t.Error("NewJournal() with broken journal: want error")
+	}
+}
@@ -0,0 +1,101 @@
+package run
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+// Поля контекстного логгера
+const (
+	LOG_FIELD_RUN_ID    = "run_id"
+	LOG_FIELD_STAGE     = "stage"
+	LOG_FIELD_STEP      = "step"
+	LOG_FIELD_STEP_KIND = "step_kind"
+	LOG_FIELD_PLUGIN    = "plugin"
+	LOG_FIELD_COMPONENT = "component"
+	LOG_FIELD_ATTEMPT   = "attempt"
+)
+
+// LogFieldsFunc выводит сообщение вместе с полями контекста
+type LogFieldsFunc func(level string, fields map[string]interface{}, format string, args ...interface{})
+
+// DEFAULT_LOG_FIELDS_FUNC задаётся приложением (например, через logrus.WithFields).
+// Пока он не задан, поля не выводятся и сообщения передаются logMessage.
+var DEFAULT_LOG_FIELDS_FUNC LogFieldsFunc
+
+// Logger контекстный логгер выполнения: к каждому сообщению добавляются поля запуска,
+// этапа и шага. Поля не меняются после создания, производные логгеры получают копию.
+type Logger struct {
+	logMessage func(string, string, ...interface{})
+	fields     map[string]interface{}
+}
+
+func NewLogger(logMessage func(string, string, ...interface{})) *Logger {
+	return &Logger{logMessage: logMessage, fields: make(map[string]interface{})}
+}
+
+// With возвращает логгер с добавленным полем; пустые значения не добавляются
+func (l *Logger) With(key string, value interface{}) *Logger {
+	return l.WithFields(map[string]interface{}{key: value})
+}
+
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	child := &Logger{logMessage: l.logMessage, fields: make(map[string]interface{}, len(l.fields)+len(fields))}
+	for key, value := range l.fields {
+		child.fields[key] = value
+	}
+	for key, value := range fields {
+		if value == nil || value == "" {
+			continue
+		}
+		child.fields[key] = value
+	}
+	return child
+}
+
+// Step возвращает логгер шага этапа
+func (l *Logger) Step(kind string, name string, pluginType string) *Logger {
+	return l.WithFields(map[string]interface{}{
+		LOG_FIELD_STEP:      name,
+		LOG_FIELD_STEP_KIND: kind,
+		LOG_FIELD_PLUGIN:    pluginType,
+	})
+}
+
+// Fields возвращает копию полей логгера
+func (l *Logger) Fields() map[string]interface{} {
+	if l == nil {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(l.fields))
+	for key, value := range l.fields {
+		fields[key] = value
+	}
+	return fields
+}
+
+// Log выводит сообщение с полями логгера. Сигнатура совпадает с logMessage,
+// поэтому l.Log передаётся функциям, которые принимают logMessage.
+func (l *Logger) Log(level string, format string, args ...interface{}) {
+	if l == nil {
+		return
+	}
+	if DEFAULT_LOG_FIELDS_FUNC != nil {
+		DEFAULT_LOG_FIELDS_FUNC(level, l.fields, format, args...)
+		return
+	}
+	if l.logMessage != nil {
+		l.logMessage(level, format, args...)
+	}
+}
+
+// newRunID идентификатор запуска: время начала и случайный суффикс
+func newRunID() string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return time.Now().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
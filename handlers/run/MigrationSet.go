@@ -1,10 +1,13 @@
 package run
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"strings"
 
 	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
 	"gopkg.in/yaml.v3"
 )
 
@@ -26,28 +29,25 @@ var (
 	DEFAULT_SUPPORT_ROLLBACK = false
 )
 
-// Граф зависимостей
-type DependencyGraph struct {
-	Actions      map[string]Action   // Карта действий
-	Dependencies map[string][]string // Зависимости для каждого действия
-}
-
-type Action struct {
-	Name     string
-	Action   map[string]interface{}
-	Rollback map[string]interface{}
-}
-
 type MigrationSet struct {
 	StandsFile          *StandsFile
 	PluginController    *plugin.PluginController
-	DependencyGraph     *DependencyGraph
-	MigrationSetVersion string   `yaml:"msVersion"`
-	Atomic              *bool    `yaml:"atomic"` // Флаг атомарности
-	YAMLStandFile       string   `yaml:"stands"` // Путь к файлу стендов
-	FromRelease         string   `yaml:"from_release"`
-	ToRelease           string   `yaml:"to_release"`
-	Stages              []Stages `yaml:"stages"` // Список этапов
+	DependencyGraph     *DependencyGraph // Выполненные шаги для отката
+	StageGraph          *DependencyGraph // Зависимости между этапами ('dependence')
+	Journal             *Journal         // Журнал выполнения шагов
+	Outputs             *Outputs         // Выходные значения выполненных шагов для шаблонов
+	State               *StandsState     // Фактические версии компонентов и релиз стендов
+	Report              *Report          // Отчёт о выполнении этапов и шагов
+	AllowDowngrade      bool             `yaml:"-"` // Разрешить понижение версий 'to_version' для всех шагов
+	Force               bool             `yaml:"-"` // Выполнять миграцию, даже если релиз стендов не совпадает с 'from_release'
+	MigrationFile       string           `yaml:"-"` // Путь к файлу миграции
+	RunID               string           `yaml:"-"` // Идентификатор запуска в логах и отчёте; по умолчанию создаётся в UpdateRelease
+	MigrationSetVersion string           `yaml:"msVersion"`
+	Atomic              *bool            `yaml:"atomic"` // Флаг атомарности
+	YAMLStandFile       string           `yaml:"stands"` // Путь к файлу стендов
+	FromRelease         string           `yaml:"from_release"`
+	ToRelease           string           `yaml:"to_release"`
+	Stages              []Stages         `yaml:"stages"` // Список этапов
 }
 
 // Метод инициализации MigrationSet
@@ -65,19 +65,43 @@ func (ms *MigrationSet) NewMigrationSet(MigrationSetYamlFile string, pc *plugin.
 	if err != nil {
 		return nil, fmt.Errorf("[MigrationSet]>[New] Unmarshal 'migration' YAML: %v: %v", migrationSet, err)
 	}
+	return newMigrationSet(migrationSet, MigrationSetYamlFile, pc, logMessage)
+}
+
+// newMigrationSet дополняет разобранный файл миграции (или патча) стендами, состоянием и
+// служебными структурами выполнения
+func newMigrationSet(migrationSet *MigrationSet, MigrationSetYamlFile string, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) (*MigrationSet, error) {
+
 	// Читаем файл стендов из конфигурации миграции.
 	stand := &StandsFile{}
-	err = unmarshalYamlFile(migrationSet.YAMLStandFile, stand)
+	err := unmarshalYamlFile(migrationSet.YAMLStandFile, stand)
 	if err != nil {
 		return nil, fmt.Errorf("[MigrationSet]>[New] Unmarshal 'stands' YAML: %v", err)
 	}
+	// Раскрываем 'include' и наследование 'common'
+	err = stand.ResolveIncludes(migrationSet.YAMLStandFile, logMessage)
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationSet]>[New] %v", err)
+	}
+	// Версии, изменённые предыдущими миграциями, хранятся в файле состояния
+	state, err := LoadState(StatePath(migrationSet.YAMLStandFile))
+	if err != nil {
+		return nil, fmt.Errorf("[MigrationSet]>[New] %v", err)
+	}
+	state.Apply(stand)
+	logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[New] state file: %s, release: %s", state.Path, stand.Release))
 	// Создаем новый экземпляр MigrationSet с заполненными данными.
 	newMg := &MigrationSet{
 		StandsFile:          stand,
 		PluginController:    pc,
-		DependencyGraph:     &DependencyGraph{Actions: make(map[string]Action), Dependencies: make(map[string][]string)},
+		DependencyGraph:     NewDependencyGraph(),
+		Outputs:             NewOutputs(),
+		State:               state,
+		Report:              NewReport(),
+		MigrationFile:       MigrationSetYamlFile,
 		MigrationSetVersion: migrationSet.MigrationSetVersion,
 		Atomic:              migrationSet.Atomic,
+		YAMLStandFile:       migrationSet.YAMLStandFile,
 		FromRelease:         migrationSet.FromRelease,
 		ToRelease:           migrationSet.ToRelease,
 		Stages:              migrationSet.Stages,
@@ -86,11 +110,31 @@ func (ms *MigrationSet) NewMigrationSet(MigrationSetYamlFile string, pc *plugin.
 	return newMg, nil
 }
 
-func (ms *MigrationSet) CascadeValidation(mSet MigrationSet, logMessage func(string, string, ...interface{})) error {
+// CascadeValidation проверяет миграцию до выполнения. Ошибки получают категорию ERROR_VALIDATION,
+// кроме уже категоризированных (отсутствующий плагин). Отсутствующие плагины устанавливаются
+// при install=true; без установки (--dry-run) шаги с ними проверяются без плагина.
+func (ms *MigrationSet) CascadeValidation(mSet MigrationSet, install bool, logMessage func(string, string, ...interface{})) error {
 	err := ms.ValidateMS(mSet)
+	if err != nil {
+		return newRunError(ERROR_VALIDATION, err)
+	}
+	err = ms.ValidateRelease(mSet, logMessage)
+	if err != nil {
+		return newRunError(ERROR_VALIDATION, err)
+	}
+	return newRunError(ERROR_VALIDATION, ms.validateStages(mSet, install, logMessage))
+}
+
+// validateStages проверяет граф этапов, файл стендов и этапы миграции
+func (ms *MigrationSet) validateStages(mSet MigrationSet, install bool, logMessage func(string, string, ...interface{})) error {
+
+	// Построение графа зависимостей этапов: неизвестные имена и циклы недопустимы
+	logMessage("INFO", "[MigrationSet]>[Valid] Build stage dependency graph")
+	stageGraph, err := BuildStageGraph(mSet.Stages, "")
 	if err != nil {
 		return err
 	}
+	ms.StageGraph = stageGraph
 
 	// Channel to receive errors from goroutines
 	errChan := make(chan error, len(mSet.Stages)+1) // Buffered channel to avoid deadlocks
@@ -98,14 +142,14 @@ func (ms *MigrationSet) CascadeValidation(mSet MigrationSet, logMessage func(str
 	// Start goroutine for StandsFile validation
 	go func() {
 		logMessage("INFO", "[MigrationSet]>[Valid] Start validation 'StandsFile'")
-		errChan <- mSet.StandsFile.CascadeValidation(*mSet.StandsFile, mSet.PluginController, logMessage)
+		errChan <- mSet.StandsFile.CascadeValidation(*mSet.StandsFile, mSet.PluginController, install, logMessage)
 	}()
 
 	// Start goroutines for Stage validations
 	for _, Stage := range mSet.Stages {
 		go func(stage Stages) {
 			logMessage("INFO", fmt.Sprintf("[MigrationSet]>[Valid] Start validation 'Stage' '%s'", stage.Name))
-			errChan <- stage.CheckValideData(stage, mSet.PluginController, *mSet.StandsFile, logMessage)
+			errChan <- stage.CheckValideData(stage, mSet.PluginController, *mSet.StandsFile, install, logMessage)
 		}(Stage)
 	}
 
@@ -144,55 +188,461 @@ func (ms *MigrationSet) ValidateMS(mSet MigrationSet) error {
 	return nil
 }
 
-func (ms *MigrationSet) UpdateRelease(mSet *MigrationSet, logMessage func(string, string, ...interface{})) error {
+// ValidateRelease сверяет релизы миграции с текущим релизом стендов.
+// Миграция должна повышать релиз и начинаться с релиза стендов; с Force
+// несовпадения только выводятся предупреждением.
+func (ms *MigrationSet) ValidateRelease(mSet MigrationSet, logMessage func(string, string, ...interface{})) error {
+
+	direction, err := semver.CompareStrings(mSet.FromRelease, mSet.ToRelease)
+	if err != nil {
+		return fmt.Errorf("[MigrationSet]>[Valid] 'from_release'/'to_release': %v", err)
+	}
+	release, err := mSet.StandsFile.CurrentRelease()
+	if err != nil {
+		return fmt.Errorf("[MigrationSet]>[Valid] %v", err)
+	}
+	current, err := semver.CompareStrings(release, mSet.FromRelease)
+	if err != nil {
+		return fmt.Errorf("[MigrationSet]>[Valid] stands 'release': %v", err)
+	}
+
+	var problems []string
+	switch {
+	case direction == 0:
+		problems = append(problems, fmt.Sprintf("migration '%s' => '%s' does not change the release", mSet.FromRelease, mSet.ToRelease))
+	case direction > 0:
+		problems = append(problems, fmt.Sprintf("migration '%s' => '%s' goes backwards", mSet.FromRelease, mSet.ToRelease))
+	}
+	if current != 0 {
+		problems = append(problems, fmt.Sprintf("stands are on release '%s', migration expects 'from_release' '%s'", release, mSet.FromRelease))
+	}
+
+	for _, problem := range problems {
+		if !mSet.Force {
+			return fmt.Errorf("[MigrationSet]>[Valid] %s (use --force to run anyway)", problem)
+		}
+		logMessage("WARN", fmt.Sprintf("[MigrationSet]>[Valid] %s, continue with --force", problem))
+	}
+	return nil
+}
+
+func (ms *MigrationSet) UpdateRelease(mSet *MigrationSet, logMessage func(string, string, ...interface{})) (err error) {
 
 	logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[Update] Update Release '%s'=>'%s'", mSet.FromRelease, mSet.ToRelease))
 
-	for _, stage := range mSet.Stages {
-		err := stage.ExecStage(stage, mSet, mSet.Atomic, "", logMessage)
+	if mSet.RunID == "" {
+		mSet.RunID = newRunID()
+	}
+	logger := NewLogger(logMessage).With(LOG_FIELD_RUN_ID, mSet.RunID)
+
+	mSet.Report.Begin(mSet)
+	defer func() { mSet.Report.End(err) }()
+
+	_, atomic := isFlagSpecified(mSet.Atomic)
+	err = mSet.ExecStages(mSet.Stages, mSet.Atomic, "", atomic, logger)
+	if err != nil {
+		// Атомарная миграция откатывает все выполненные этапы
+		if atomic {
+			if rollbackErr := mSet.RollbackRelease(mSet.FromRelease, logMessage); rollbackErr != nil {
+				logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Update] %v", rollbackErr))
+			}
+		}
+		return err
+	}
+
+	// Стенды обновлены: фиксируем новый релиз. С --stand релиз меняется только у выбранного стенда.
+	if err := mSet.State.SetRelease(mSet.ToRelease, mSet.StandsFile.Selected); err != nil {
+		return err
+	}
+	mSet.StandsFile.SetRelease(mSet.ToRelease)
+	logMessage("INFO", fmt.Sprintf("[MigrationSet]>[Update] Release '%s' of stands '%s' saved to '%s'", mSet.ToRelease, mSet.StandName(), mSet.State.Path))
+
+	return nil
+}
+
+// ExecStages выполняет этапы одного уровня в порядке графа зависимостей.
+// Независимые этапы выполняются параллельно, не более чем в ExecThreads() потоков.
+// Этапы, зависящие от неуспешного, не запускаются. При stopOnError новые этапы
+// после первой ошибки не запускаются. Возвращается первая ошибка.
+func (ms *MigrationSet) ExecStages(stages []Stages, parentAtomic *bool, parentName string, stopOnError bool, logger *Logger) error {
+
+	logMessage := logger.Log
+
+	if ms.StageGraph == nil {
+		stageGraph, err := BuildStageGraph(ms.Stages, "")
 		if err != nil {
-			return nil
-		} else {
 			return err
 		}
+		ms.StageGraph = stageGraph
+	}
 
+	type stageResult struct {
+		name string
+		err  error
 	}
 
-	return nil
+	threads := ms.ExecThreads()
+	results := make(chan stageResult)
+	pending := make(map[string]Stages)
+	order := make([]string, 0, len(stages))
+	for _, stage := range stages {
+		name := stage.setName(parentName, stage.Name)
+		pending[name] = stage
+		order = append(order, name)
+	}
+
+	done := make(map[string]bool)
+	failed := make(map[string]bool)
+	running := 0
+	stopped := false
+	var firstErr error
+
+	for {
+		// Запускаем все готовые этапы, пока есть свободные потоки
+		for progress := true; progress; {
+			progress = false
+			for _, name := range order {
+				stage, ok := pending[name]
+				if !ok {
+					continue
+				}
+
+				ready, blocked := true, ""
+				for _, dep := range ms.StageGraph.Dependencies[name] {
+					if failed[dep] {
+						blocked = dep
+						break
+					}
+					if !done[dep] {
+						ready = false
+					}
+				}
+
+				if blocked != "" {
+					err := fmt.Errorf("[Stage > %s] skipped: dependence '%s' failed", name, blocked)
+					logMessage("ERROR", err.Error())
+					ms.Report.SkipStageTree(stage, name, fmt.Sprintf("dependence '%s' failed", blocked))
+					failed[name] = true
+					delete(pending, name)
+					if firstErr == nil {
+						firstErr = err
+					}
+					progress = true
+					continue
+				}
+
+				if !ready || stopped || running >= threads {
+					continue
+				}
+
+				delete(pending, name)
+				running++
+				progress = true
+				go func(name string, stage Stages) {
+					ms.Report.StartStage(name)
+					err := stage.ExecStage(stage, ms, parentAtomic, parentName, logger)
+					ms.Report.FinishStage(name, err)
+					results <- stageResult{name: name, err: err}
+				}(name, stage)
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		result := <-results
+		running--
+		if result.err != nil {
+			failed[result.name] = true
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			if stopOnError {
+				stopped = true
+			}
+		} else {
+			done[result.name] = true
+		}
+	}
+
+	for _, name := range order {
+		if stage, ok := pending[name]; ok {
+			logMessage("INFO", fmt.Sprintf("[Stage > %s] not started after previous failure", name))
+			ms.Report.SkipStageTree(stage, name, "not started after previous failure")
+		}
+	}
+
+	return firstErr
 }
 
+// RollbackRelease выполняет компенсирующие действия всех выполненных шагов в обратном порядке
 func (ms *MigrationSet) RollbackRelease(targetRelease string, logMessage func(string, string, ...interface{})) error {
 
-	return nil
+	logMessage("INFO", fmt.Sprintf("[MigrationSet]>[Rollback] Rollback Release '%s'=>'%s'", ms.ToRelease, targetRelease))
+
+	return ms.rollbackActions("", NewLogger(logMessage).With(LOG_FIELD_RUN_ID, ms.RunID))
+}
+
+// rollbackActions откатывает выполненные действия, имя которых начинается с prefix.
+// Пустой prefix означает откат всех действий миграции.
+func (ms *MigrationSet) rollbackActions(prefix string, logger *Logger) error {
+
+	graph := ms.DependencyGraph
+
+	// Собираем действия для отката под блокировкой, выполняем без неё
+	graph.mu.Lock()
+	var names []string
+	for i := len(graph.Order) - 1; i >= 0; i-- {
+		name := graph.Order[i]
+		action := graph.Actions[name]
+		if action.RolledBack || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		names = append(names, name)
+	}
+	graph.mu.Unlock()
+
+	var firstErr error
+	for _, name := range names {
+		graph.mu.Lock()
+		action := graph.Actions[name]
+		graph.mu.Unlock()
+
+		actionLogger := logger.Step("rollback", name, action.PluginType)
+		logMessage := actionLogger.Log
+		if action.Rollback == nil {
+			logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[Rollback] '%s' has no rollback, skip", name))
+			continue
+		}
+
+		logMessage("INFO", fmt.Sprintf("[MigrationSet]>[Rollback] Rollback '%s'", name))
+		err := action.ExecRollback(action, ms, actionLogger)
+		ms.Report.RolledBack(name, err)
+		if err != nil {
+			logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Rollback] Rollback '%s' failed: %v", name, err))
+			if firstErr == nil {
+				firstErr = &RunError{Kind: ERROR_ROLLBACK_FAILED, Err: fmt.Errorf("[MigrationSet]>[Rollback] rollback '%s' failed: %w", name, err)}
+			}
+			continue
+		}
+
+		graph.mu.Lock()
+		action.RolledBack = true
+		graph.Actions[name] = action
+		graph.mu.Unlock()
+	}
+
+	return firstErr
 }
 
 // Метод для добавления действия в граф
 func (ms *MigrationSet) AddActionToGraph(actionName string, action Action, dependencies []string) error {
-	if _, exists := ms.DependencyGraph.Actions[actionName]; exists {
-		return fmt.Errorf("[MigrationSet]>[AddActionToGraph] Action %s already exists", actionName)
+	return ms.DependencyGraph.AddAction(actionName, action, dependencies)
+}
+
+// StandName возвращает имя целевого стенда, а если стенд не выбран - путь к файлу стендов
+func (ms *MigrationSet) StandName() string {
+	if ms.StandsFile != nil && ms.StandsFile.Selected != "" {
+		return ms.StandsFile.Selected
 	}
+	return ms.YAMLStandFile
+}
+
+// ReloadState перечитывает файл состояния стендов: релиз и версии компонентов
+// могли измениться предыдущей миграцией цепочки
+func (ms *MigrationSet) ReloadState(logMessage func(string, string, ...interface{})) error {
 
-	ms.DependencyGraph.Actions[actionName] = action
-	ms.DependencyGraph.Dependencies[actionName] = dependencies
+	state, err := LoadState(StatePath(ms.YAMLStandFile))
+	if err != nil {
+		return err
+	}
+	state.Apply(ms.StandsFile)
+	ms.State = state
+	logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[State] state file: %s, release: %s", state.Path, ms.StandsFile.Release))
+	return nil
+}
+
+// OpenJournal подключает журнал выполнения. При resume=true успешно выполненные ранее шаги будут пропущены.
+func (ms *MigrationSet) OpenJournal(journalDir string, resume bool, logMessage func(string, string, ...interface{})) error {
+
+	journal, err := NewJournal(journalDir, ms.MigrationFile, ms.StandName(), resume)
+	if err != nil {
+		return err
+	}
+
+	logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[Journal] journal file: %s, resume: %v", journal.Path, resume))
+	ms.Journal = journal
 	return nil
 }
 
+// runStep выполняет шаг с записью результата и выходных значений в журнал.
+// Шаг, успешно выполненный в предыдущем запуске, пропускается, а его выходные значения восстанавливаются.
+func (ms *MigrationSet) runStep(stageName string, kind string, name string, logMessage func(string, string, ...interface{}), exec func() error) error {
+
+	stepName := stepKey(stageName, kind, name)
+	if ms.Journal.Succeeded(stepName) {
+		logMessage("INFO", fmt.Sprintf("[MigrationSet]>[Journal] '%s' already succeeded, skip", stepName))
+		outputs, masked := ms.Journal.Outputs(stepName)
+		if outputs != nil {
+			ms.Outputs.Set(stageName, name, outputs)
+		}
+		if len(masked) > 0 {
+			logMessage("WARN", fmt.Sprintf("[MigrationSet]>[Journal] '%s' outputs %s were masked in the journal and are not restored", stepName, strings.Join(masked, ", ")))
+		}
+		ms.Report.SkipStep(stageName, kind, name, "succeeded in a previous run")
+		return nil
+	}
+
+	if err := ms.Journal.Start(stepName, kind); err != nil {
+		logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] %v", err))
+	}
+	ms.Report.StartStep(stageName, kind, name)
+
+	stepErr := exec()
+
+	var skipped *stepSkipped
+	if errors.As(stepErr, &skipped) {
+		ms.Outputs.Set(stageName, name, map[string]interface{}{"status": JOURNAL_STATUS_SKIPPED})
+		if err := ms.Journal.Skip(stepName, kind, skipped.reason); err != nil {
+			logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] %v", err))
+		}
+		ms.Report.SkipStep(stageName, kind, name, skipped.reason)
+		return nil
+	}
+	stepErr = newRunError(stepErrorKind(kind), stepErr)
+
+	var outputs map[string]interface{}
+	if stepErr == nil {
+		ms.Outputs.SetStatus(stageName, name, JOURNAL_STATUS_OK)
+		outputs, _ = ms.Outputs.Get(stageName, name)
+	} else {
+		ms.Outputs.Set(stageName, name, map[string]interface{}{"status": JOURNAL_STATUS_FAILED, "error": stepErr.Error()})
+	}
+	if err := ms.Journal.Finish(stepName, stepErr, ms.Journal.Referenced(stageName, name, outputs)); err != nil {
+		logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] %v", err))
+	}
+	ms.Report.FinishStep(stageName, kind, name, stepErr)
+	return stepErr
+}
+
+// skipStage отмечает пропущенными этап и все его шаги, включая вложенные этапы
+func (ms *MigrationSet) skipStage(stage Stages, stageName string, reason string, logMessage func(string, string, ...interface{})) {
+
+	skip := func(kind string, name string) {
+		ms.Outputs.Set(stageName, name, map[string]interface{}{"status": JOURNAL_STATUS_SKIPPED})
+		if err := ms.Journal.Skip(stepKey(stageName, kind, name), kind, reason); err != nil {
+			logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] %v", err))
+		}
+		ms.Report.SkipStep(stageName, kind, name, reason)
+	}
+
+	if err := ms.Journal.Skip(stageName, "stage", reason); err != nil {
+		logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] %v", err))
+	}
+	ms.Report.SkipStage(stageName, reason)
+	for _, check := range stage.PreCheck {
+		skip("pre_check", check.Name)
+	}
+	for _, script := range stage.PreScript {
+		skip("pre_script", script.Name)
+	}
+	for _, task := range stage.Task {
+		skip("task", task.Name)
+	}
+	for _, script := range stage.PostScript {
+		skip("post_script", script.Name)
+	}
+	for _, check := range stage.PostCheck {
+		skip("post_check", check.Name)
+	}
+	for _, subStage := range stage.Stages {
+		ms.skipStage(subStage, stage.setName(stageName, subStage.Name), reason, logMessage)
+	}
+}
+
+// runActionStep выполняет скрипт или задачу через журнал и регистрирует для отката
+// каждый компонент, на котором шаг выполнен. Шаг, пропущенный по журналу,
+// считается выполненным на всех компонентах селектора.
+func (ms *MigrationSet) runActionStep(stageName string, kind string, name string, pluginType string, component map[string]interface{}, action map[string]interface{}, rollback map[string]interface{}, logMessage func(string, string, ...interface{}), exec func() ([]string, error)) error {
+
+	var done []string
+	executed := false
+	err := ms.runStep(stageName, kind, name, logMessage, func() error {
+		var execErr error
+		executed = true
+		done, execErr = exec()
+		return execErr
+	})
+
+	if !executed {
+		selector, renderErr := RenderMap(component, ms.templateScope(nil))
+		if renderErr != nil {
+			logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Journal] '%s': 'component' %v", stepKey(stageName, kind, name), renderErr))
+		}
+		for _, matched := range ms.StandsFile.MatchComponents(selector) {
+			done = append(done, matched.Name)
+		}
+	}
+	for _, componentName := range done {
+		ms.registerStep(stageName, kind, name, pluginType, componentName, component, action, rollback, "", logMessage)
+	}
+	return err
+}
+
+// registerStep добавляет выполненный шаг этапа в граф для последующего отката.
+// Если указан componentName, шаг регистрируется для одного компонента, иначе - для селектора component.
+// Условие when вычисляется в момент отката.
+func (ms *MigrationSet) registerStep(stageName string, kind string, name string, pluginType string, componentName string, component map[string]interface{}, action map[string]interface{}, rollback map[string]interface{}, when string, logMessage func(string, string, ...interface{})) {
+
+	stepName := stepKey(stageName, kind, name)
+	if componentName != "" {
+		stepName += "@" + componentName
+		component = map[string]interface{}{"name": componentName}
+	}
+	err := ms.AddActionToGraph(stepName, Action{
+		Name:       stepName,
+		PluginType: pluginType,
+		Component:  component,
+		Action:     action,
+		Rollback:   rollback,
+		When:       when,
+	}, []string{stageName})
+	if err != nil {
+		logMessage("ERROR", fmt.Sprintf("[MigrationSet]>[Graph] %v", err))
+	}
+}
+
+// stepErrorKind возвращает категорию ошибки шага по его типу
+func stepErrorKind(kind string) ErrorKind {
+	switch kind {
+	case "pre_check", "post_check":
+		return ERROR_CHECK_FAILED
+	}
+	return ERROR_ACTION_FAILED
+}
+
+// stepKey возвращает уникальное имя шага: '<этап>.<тип шага>.<имя>'
+func stepKey(stageName string, kind string, name string) string {
+	return stageName + "." + kind + "." + name
+}
+
 func (ms *MigrationSet) CreateMSFiles(mSet *MigrationSet, logMessage func(string, string, ...interface{})) error {
 
 	logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[Update] Update Release '%s'=>'%s'", mSet.FromRelease, mSet.ToRelease))
 
+	// Выполняются все этапы, возвращается первая ошибка
+	logger := NewLogger(logMessage).With(LOG_FIELD_RUN_ID, mSet.RunID)
+	var firstErr error
 	for _, stage := range mSet.Stages {
 		logMessage("DEBUG", fmt.Sprintf("[MigrationSet]>[Update] Start ExecStage for %s", stage.Name))
-		err := stage.ExecStage(stage, mSet, mSet.Atomic, "", logMessage)
-		if err != nil {
-			return nil
-		} else {
-			return err
+		err := stage.ExecStage(stage, mSet, mSet.Atomic, "", logger)
+		if err != nil && firstErr == nil {
+			firstErr = err
 		}
-
 	}
 
-	return nil
+	return firstErr
 }
 
 func (ms *MigrationSet) SetPluginController(pc *plugin.PluginController) error {
@@ -205,13 +655,93 @@ func (ms *MigrationSet) SetMinExecThreads(num int) {
 }
 
 func (ms *MigrationSet) SetMaxExecThreads(num int) {
-	DEFAULT_MS_MIN_EXEC_THREADS = num
+	DEFAULT_MS_MAX_EXEC_THREADS = num
+}
+
+func (ms *MigrationSet) SetExecThreads(num int) {
+	DEFAULT_MS_EXEC_THREADS = num
+}
+
+// ExecThreads возвращает число потоков выполнения этапов в границах [min, max]
+func (ms *MigrationSet) ExecThreads() int {
+	threads := DEFAULT_MS_EXEC_THREADS
+	if threads > DEFAULT_MS_MAX_EXEC_THREADS {
+		threads = DEFAULT_MS_MAX_EXEC_THREADS
+	}
+	if threads < DEFAULT_MS_MIN_EXEC_THREADS {
+		threads = DEFAULT_MS_MIN_EXEC_THREADS
+	}
+	if threads < 1 {
+		threads = 1
+	}
+	return threads
 }
 
 func (ms *MigrationSet) GetStages() []Stages {
 	return ms.Stages
 }
 
+// ReferencedPlugins возвращает плагины, на которые ссылаются файл миграции и его файл стендов.
+// Ключ - имя плагина, значение - места использования.
+func ReferencedPlugins(migrationFile string, logMessage func(string, string, ...interface{})) (map[string][]string, error) {
+
+	migrationSet := &MigrationSet{}
+	if err := unmarshalYamlFile(migrationFile, migrationSet); err != nil {
+		return nil, fmt.Errorf("[MigrationSet]>[Plugins] Unmarshal 'migration' YAML: %v", err)
+	}
+
+	refs := make(map[string][]string)
+	add := func(pluginType string, where string) {
+		if pluginType != "" {
+			refs[pluginType] = append(refs[pluginType], where)
+		}
+	}
+
+	var walk func(stages []Stages, parentName string)
+	walk = func(stages []Stages, parentName string) {
+		for _, stage := range stages {
+			stageName := stage.setName(parentName, stage.Name)
+			for _, check := range stage.PreCheck {
+				add(check.PluginType, stepKey(stageName, "pre_check", check.Name))
+			}
+			for _, script := range stage.PreScript {
+				add(script.PluginType, stepKey(stageName, "pre_script", script.Name))
+			}
+			for _, task := range stage.Task {
+				add(task.PluginType, stepKey(stageName, "task", task.Name))
+			}
+			for _, script := range stage.PostScript {
+				add(script.PluginType, stepKey(stageName, "post_script", script.Name))
+			}
+			for _, check := range stage.PostCheck {
+				add(check.PluginType, stepKey(stageName, "post_check", check.Name))
+			}
+			for _, script := range stage.Rollback {
+				add(script.PluginType, stepKey(stageName, "rollback", script.Name))
+			}
+			walk(stage.Stages, stageName)
+		}
+	}
+	walk(migrationSet.Stages, "")
+
+	if migrationSet.YAMLStandFile != "" {
+		stands := &StandsFile{}
+		if err := unmarshalYamlFile(migrationSet.YAMLStandFile, stands); err != nil {
+			return nil, fmt.Errorf("[MigrationSet]>[Plugins] Unmarshal 'stands' YAML: %v", err)
+		}
+		if err := stands.ResolveIncludes(migrationSet.YAMLStandFile, logMessage); err != nil {
+			return nil, fmt.Errorf("[MigrationSet]>[Plugins] %v", err)
+		}
+		for _, stand := range stands.Stand {
+			for _, component := range stand.Component {
+				add(component.Plugin, fmt.Sprintf("stand '%s' component '%s'", stand.Name, component.Name))
+			}
+		}
+	}
+
+	return refs, nil
+}
+
 // UnmarshalYamlFile загружает данные из YAML файла и возвращает объект.
 func unmarshalYamlFile(filePath string, target interface{}) error {
 	yamlData, err := ioutil.ReadFile(filePath)
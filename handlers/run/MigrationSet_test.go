@@ -0,0 +1,120 @@
+package run
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setTestExecThreads задаёт число потоков выполнения этапов на время теста
+func setTestExecThreads(t *testing.T, ms *MigrationSet, threads int) {
+	defaultThreads := DEFAULT_MS_EXEC_THREADS
+	t.Cleanup(func() { DEFAULT_MS_EXEC_THREADS = defaultThreads })
+	ms.SetExecThreads(threads)
+}
+
+// testStage возвращает этап name с одной задачей 'cmd: name' и зависимостями dependence
+func testStage(name string, dependence string) string {
+	stage := "- name: \"" + name + "\"\n"
+	if dependence != "" {
+		stage += "  dependence: " + dependence + "\n"
+	}
+	return stage + "  task:\n  - {name: \"t\", plugin: \"fake\", component: {name: \"db\"}, action: {cmd: \"" + name + "\"}}\n"
+}
+
+func TestExecStagesDependencyOrder(t *testing.T) {
+	executor := &fakeExecutor{}
+	ms := newTestMigrationSet(t, "stages:\n"+testStage("c", "[a, b]")+testStage("a", "b")+testStage("b", ""), executor)
+	setTestExecThreads(t, ms, 4)
+
+	if err := runTestMigration(t, ms); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := executor.Calls(), []string{"b", "a", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestExecStagesThreads(t *testing.T) {
+	stages := "stages:\n"
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		stages += testStage(name, "")
+	}
+
+	for _, threads := range []int{1, 2, 3} {
+		executor := &fakeExecutor{delay: 30 * time.Millisecond}
+		ms := newTestMigrationSet(t, stages, executor)
+		setTestExecThreads(t, ms, threads)
+
+		if err := runTestMigration(t, ms); err != nil {
+			t.Fatal(err)
+		}
+		// Независимые этапы выполняются параллельно, но не более чем в ExecThreads() потоков
+		if got := executor.MaxRunning(); got != threads {
+			t.Errorf("threads %d: max running stages = %d", threads, got)
+		}
+		if calls := executor.Calls(); len(calls) != 6 {
+			t.Errorf("threads %d: calls = %v, want all stages", threads, calls)
+		}
+	}
+}
+
+func TestExecStagesFailedDependence(t *testing.T) {
+	tests := []struct {
+		name   string
+		atomic string
+		want   []string
+	}{
+		// Зависимые от неуспешного этапа не запускаются, независимые выполняются
+		{name: "not atomic", want: []string{"a", "d"}},
+		// Атомарная миграция не запускает новые этапы после ошибки
+		{name: "atomic", atomic: "atomic: true\n", want: []string{"a"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &fakeExecutor{fail: map[string]bool{"a": true}}
+			ms := newTestMigrationSet(t, tt.atomic+"stages:\n"+testStage("a", "")+testStage("b", "a")+testStage("c", "b")+testStage("d", ""), executor)
+			setTestExecThreads(t, ms, 1)
+
+			err := runTestMigration(t, ms)
+			if err == nil || !strings.Contains(err.Error(), "a failed") {
+				t.Fatalf("UpdateRelease() error = %v, want 'a failed'", err)
+			}
+			if got := executor.Calls(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calls = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExecStagesCycle(t *testing.T) {
+	tests := []struct {
+		name    string
+		stages  string
+		wantErr string
+	}{
+		{name: "cycle", stages: testStage("a", "c") + testStage("b", "a") + testStage("c", "b"), wantErr: "dependency cycle: a -> c -> b -> a"},
+		{name: "self", stages: testStage("a", "a"), wantErr: "dependency cycle: a -> a"},
+		{name: "unknown", stages: testStage("a", "x"), wantErr: "'a' depends on unknown 'x'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &fakeExecutor{}
+			ms := newTestMigrationSet(t, "stages:\n"+tt.stages, executor)
+
+			if err := ms.CascadeValidation(*ms, true, testLog(t)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CascadeValidation() error = %v, want %q", err, tt.wantErr)
+			}
+			// Без проверки граф строится при выполнении, этапы не запускаются
+			if err := ms.ExecStages(ms.Stages, ms.Atomic, "", false, NewLogger(testLog(t))); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ExecStages() error = %v, want %q", err, tt.wantErr)
+			}
+			if calls := executor.Calls(); len(calls) != 0 {
+				t.Errorf("calls = %v, want none", calls)
+			}
+		})
+	}
+}
@@ -1,15 +1,204 @@
 package run
 
-import "github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
+)
+
+// PatchSet хотфикс: этапы выполняются поверх любого релиза стендов из диапазона
+// 'releases' и не меняют номер релиза. Применённый патч записывается в файл
+// состояния стендов и повторно не выполняется.
 type PatchSet struct {
-	StandsFile          *StandsFile
-	PluginController    *plugin.PluginController
-	DependencyGraph     *DependencyGraph
-	MigrationSetVersion string   `yaml:"msVersion"`
-	Atomic              *bool    `yaml:"atomic"` // Флаг атомарности
-	YAMLStandFile       string   `yaml:"stands"` // Путь к файлу стендов
-	FromRelease         string   `yaml:"from_release"`
-	ToRelease           string   `yaml:"to_release"`
-	Stages              []Stages `yaml:"stages"` // Список этапов
+	*MigrationSet `yaml:"-"` // Этапы, стенды и плагины патча; выполняются теми же средствами, что и миграция
+	PatchFile     string     `yaml:"-"`        // Путь к файлу патча
+	Name          string     `yaml:"name"`     // Имя патча; по умолчанию - имя файла
+	Releases      string     `yaml:"releases"` // Диапазон релизов, например ">=v0.0.1 <v0.1.0"
+}
+
+// Метод инициализации PatchSet
+func (ps *PatchSet) NewPatchSet(PatchSetYamlFile string, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) (*PatchSet, error) {
+
+	logMessage("DEBUG", fmt.Sprintf("[PatchSet]>[New] patch file: %s", PatchSetYamlFile))
+
+	if pc == nil {
+		return nil, fmt.Errorf("[PatchSet]>[New] pluginController is nil")
+	}
+
+	// Файл патча читается один раз: поля миграции и собственные поля патча
+	patchFile := &struct {
+		MigrationSet `yaml:",inline"`
+		Name         string `yaml:"name"`
+		Releases     string `yaml:"releases"`
+	}{}
+	if err := unmarshalYamlFile(PatchSetYamlFile, patchFile); err != nil {
+		return nil, fmt.Errorf("[PatchSet]>[New] Unmarshal 'patch' YAML: %v", err)
+	}
+
+	migrationSet, err := newMigrationSet(&patchFile.MigrationSet, PatchSetYamlFile, pc, logMessage)
+	if err != nil {
+		return nil, fmt.Errorf("[PatchSet]>[New] %v", err)
+	}
+	// Патч не меняет релиз стендов
+	migrationSet.FromRelease = migrationSet.StandsFile.Release
+	migrationSet.ToRelease = migrationSet.StandsFile.Release
+
+	patchSet := &PatchSet{
+		MigrationSet: migrationSet,
+		PatchFile:    PatchSetYamlFile,
+		Name:         patchFile.Name,
+		Releases:     patchFile.Releases,
+	}
+	if patchSet.Name == "" {
+		patchSet.Name = strings.TrimSuffix(filepath.Base(PatchSetYamlFile), filepath.Ext(PatchSetYamlFile))
+	}
+	return patchSet, nil
+}
+
+func (ps *PatchSet) CascadeValidation(pSet PatchSet, install bool, logMessage func(string, string, ...interface{})) error {
+
+	if err := ps.ValidatePS(pSet); err != nil {
+		return newRunError(ERROR_VALIDATION, err)
+	}
+	if err := ps.ValidateRelease(pSet, logMessage); err != nil {
+		return newRunError(ERROR_VALIDATION, err)
+	}
+	return newRunError(ERROR_VALIDATION, pSet.MigrationSet.validateStages(*pSet.MigrationSet, install, logMessage))
+}
+
+func (ps *PatchSet) ValidatePS(pSet PatchSet) error {
+
+	if pSet.MigrationSet == nil || pSet.StandsFile == nil {
+		return fmt.Errorf("[PatchSet]>[Valid] 'StandsFile' is empty")
+	}
+	if pSet.PluginController == nil {
+		return fmt.Errorf("[PatchSet]>[Valid] 'PluginController' is empty")
+	}
+	if pSet.MigrationSetVersion == "" {
+		return fmt.Errorf("[PatchSet]>[Valid] 'msVersion' is empty")
+	}
+	if pSet.Releases == "" {
+		return fmt.Errorf("[PatchSet]>[Valid] 'releases' is empty")
+	}
+	if _, err := semver.ParseConstraint(pSet.Releases); err != nil {
+		return fmt.Errorf("[PatchSet]>[Valid] 'releases' %v", err)
+	}
+	if len(pSet.Stages) == 0 {
+		return fmt.Errorf("[PatchSet]>[Valid] 'stages' is empty")
+	}
+	return nil
+}
+
+// ValidateRelease проверяет, что релиз стендов входит в диапазон 'releases' патча.
+// С Force несовпадение только выводится предупреждением.
+func (ps *PatchSet) ValidateRelease(pSet PatchSet, logMessage func(string, string, ...interface{})) error {
+
+	constraint, err := semver.ParseConstraint(pSet.Releases)
+	if err != nil {
+		return fmt.Errorf("[PatchSet]>[Valid] 'releases' %v", err)
+	}
+	current, err := pSet.StandsFile.CurrentRelease()
+	if err != nil {
+		return fmt.Errorf("[PatchSet]>[Valid] %v", err)
+	}
+	// Патч не меняет релиз; релиз выбранных стендов известен только после --stand
+	pSet.MigrationSet.FromRelease = current
+	pSet.MigrationSet.ToRelease = current
+
+	release, err := semver.Parse(current)
+	if err != nil {
+		return fmt.Errorf("[PatchSet]>[Valid] stands 'release': %v", err)
+	}
+	if constraint.Check(release) {
+		return nil
+	}
+
+	problem := fmt.Sprintf("stands are on release '%s', patch '%s' applies to '%s'", current, pSet.Name, pSet.Releases)
+	if !pSet.Force {
+		return fmt.Errorf("[PatchSet]>[Valid] %s (use --force to apply anyway)", problem)
+	}
+	logMessage("WARN", fmt.Sprintf("[PatchSet]>[Valid] %s, continue with --force", problem))
+	return nil
+}
+
+// Applied возвращает записи о применении патча к выбранным стендам и признак,
+// что патч применён ко всем выбранным стендам
+func (ps *PatchSet) Applied() ([]AppliedPatch, bool) {
+
+	var applied []AppliedPatch
+	stands := ps.StandsFile.stands()
+	for _, stand := range stands {
+		if patch, ok := ps.MigrationSet.State.Patch(ps.Name, stand.Name); ok {
+			applied = append(applied, patch)
+		}
+	}
+	return applied, len(applied) > 0 && len(applied) == len(stands)
+}
+
+// Apply выполняет этапы патча и записывает патч как применённый к каждому выбранному стенду.
+// Уже применённый патч пропускается, если не указан Force: это единственная проверка повторного применения.
+// Если патч применён только к части выбранных стендов, остальные нужно выбрать через --stand.
+func (ps *PatchSet) Apply(pSet *PatchSet, logMessage func(string, string, ...interface{})) (err error) {
+
+	ms := pSet.MigrationSet
+	if ms.RunID == "" {
+		ms.RunID = newRunID()
+	}
+	logger := NewLogger(logMessage).With(LOG_FIELD_RUN_ID, ms.RunID)
+	ms.Report.Begin(ms)
+	defer func() { ms.Report.End(err) }()
+
+	applied, all := pSet.Applied()
+	if all && !pSet.Force {
+		for _, patch := range applied {
+			logMessage("INFO", fmt.Sprintf("[PatchSet]>[Apply] Patch '%s' already applied to stand '%s' at %s on release '%s' (%s)", pSet.Name, patch.Stand, patch.Time.Format(time.RFC3339), patch.Release, patch.File))
+		}
+		logMessage("INFO", fmt.Sprintf("[PatchSet]>[Apply] Patch '%s' already applied, skip; use --force to apply again", pSet.Name))
+		for _, stage := range ms.Stages {
+			ms.Report.SkipStageTree(stage, stage.setName("", stage.Name), "patch already applied")
+		}
+		return nil
+	}
+	if len(applied) > 0 && !pSet.Force {
+		var stands []string
+		for _, patch := range applied {
+			stands = append(stands, patch.Stand)
+		}
+		return fmt.Errorf("[PatchSet]>[Apply] patch '%s' is already applied to stand(s) '%s': select the other stands with --stand or use --force to apply again", pSet.Name, strings.Join(stands, "', '"))
+	}
+
+	logMessage("DEBUG", fmt.Sprintf("[PatchSet]>[Apply] Apply patch '%s' on release '%s'", pSet.Name, ms.ToRelease))
+
+	_, atomic := isFlagSpecified(ms.Atomic)
+	err = ms.ExecStages(ms.Stages, ms.Atomic, "", atomic, logger)
+	if err != nil {
+		// Атомарный патч откатывает все выполненные этапы
+		if atomic {
+			logMessage("INFO", fmt.Sprintf("[PatchSet]>[Rollback] Rollback patch '%s'", pSet.Name))
+			if rollbackErr := ms.rollbackActions("", logger); rollbackErr != nil {
+				logMessage("ERROR", fmt.Sprintf("[PatchSet]>[Apply] %v", rollbackErr))
+			}
+		}
+		return err
+	}
+
+	// Патч записывается отдельно для каждого стенда, к которому он применён
+	appliedAt := time.Now()
+	for _, stand := range pSet.StandsFile.stands() {
+		if err := ms.State.AddPatch(AppliedPatch{
+			Name:    pSet.Name,
+			Stand:   stand.Name,
+			File:    pSet.PatchFile,
+			Release: pSet.StandsFile.StandRelease(stand.Name),
+			Time:    appliedAt,
+		}); err != nil {
+			return err
+		}
+	}
+	logMessage("INFO", fmt.Sprintf("[PatchSet]>[Apply] Patch '%s' applied on release '%s'", pSet.Name, ms.ToRelease))
+	return nil
 }
@@ -0,0 +1,120 @@
+package run
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPatch = `msVersion: "0.0.1"
+releases: ">=v0.0.1 <v0.1.0"
+stands: %q
+stages:
+- name: "hotfix"
+  task:
+  - name: "fix"
+    plugin: "fake"
+    component: {name: %q}
+    action: {cmd: "fix-{{ component.name }}-{{ stand.name }}"}
+`
+
+// newTestPatchSet создаёт патч 'hotfix' компонента component над стендами миграции ms
+func newTestPatchSet(t *testing.T, ms *MigrationSet, component string, stand string) *PatchSet {
+	t.Helper()
+
+	patchFile := filepath.Join(filepath.Dir(ms.MigrationFile), "hotfix.yml")
+	writeTestFile(t, patchFile, fmt.Sprintf(testPatch, ms.YAMLStandFile, component))
+
+	var ps *PatchSet
+	ps, err := ps.NewPatchSet(patchFile, ms.PluginController, testLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stand != "" {
+		if err := ps.StandsFile.SelectStand(stand); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps
+}
+
+func TestPatchSetApplyPerStand(t *testing.T) {
+	executor := &fakeExecutor{}
+	ms := newTestMigrationSet(t, "stages: []\n", executor)
+
+	// Патч на стенде A не считается применённым на стенде B
+	for _, stand := range []string{"A", "B"} {
+		ps := newTestPatchSet(t, ms, "app", stand)
+		if err := ps.Apply(ps, testLog(t)); err != nil {
+			t.Fatalf("Apply on stand %s: %v", stand, err)
+		}
+	}
+	if got, want := executor.Calls(), []string{"fix-app-A", "fix-app-B"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("calls = %v, want %v", got, want)
+	}
+
+	// Без --stand патч применён ко всем стендам и пропускается
+	ps := newTestPatchSet(t, ms, "app", "")
+	applied, all := ps.Applied()
+	if !all || len(applied) != 2 {
+		t.Fatalf("Applied() = %v, %v, want records for both stands", applied, all)
+	}
+	if err := ps.Apply(ps, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	if calls := executor.Calls(); len(calls) != 2 {
+		t.Fatalf("applied patch was executed again: %v", calls)
+	}
+}
+
+func TestPatchSetApplyAllStands(t *testing.T) {
+	executor := &fakeExecutor{}
+	ms := newTestMigrationSet(t, "stages: []\n", executor)
+
+	// Без --stand записывается каждый стенд запуска
+	ps := newTestPatchSet(t, ms, "db", "")
+	if err := ps.Apply(ps, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	state, err := LoadState(ps.State.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved []string
+	for _, patch := range state.Patches {
+		saved = append(saved, patch.Name+"@"+patch.Stand)
+	}
+	if want := []string{"hotfix@A", "hotfix@B"}; !reflect.DeepEqual(saved, want) {
+		t.Errorf("saved patches = %v, want %v", saved, want)
+	}
+}
+
+func TestPatchSetApplyPartly(t *testing.T) {
+	executor := &fakeExecutor{}
+	ms := newTestMigrationSet(t, "stages: []\n", executor)
+
+	ps := newTestPatchSet(t, ms, "app", "B")
+	if err := ps.Apply(ps, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Патч, применённый к части стендов, без --stand не выполняется повторно на стенде B
+	ps = newTestPatchSet(t, ms, "app", "")
+	if err := ps.Apply(ps, testLog(t)); err == nil || !strings.Contains(err.Error(), "already applied to stand(s) 'B'") {
+		t.Fatalf("Apply error = %v, want 'already applied to stand(s) 'B''", err)
+	}
+	if calls := executor.Calls(); len(calls) != 1 {
+		t.Fatalf("calls = %v, want only the first apply", calls)
+	}
+
+	// Оставшийся стенд выбирается через --stand
+	ps = newTestPatchSet(t, ms, "app", "A")
+	if err := ps.Apply(ps, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	if _, all := newTestPatchSet(t, ms, "app", "").Applied(); !all {
+		t.Error("patch is not applied to all stands")
+	}
+}
@@ -0,0 +1,258 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PLAN_UNRESOLVED_COMPONENTS показывается вместо компонентов, селектор которых зависит от других шагов
+var PLAN_UNRESOLVED_COMPONENTS = "<resolved at run time>"
+
+// PlanStep описывает один шаг плана выполнения миграции
+type PlanStep struct {
+	Stage         string                 `json:"stage"`
+	Kind          string                 `json:"kind"`
+	Name          string                 `json:"name"`
+	Plugin        string                 `json:"plugin"`
+	PluginMissing bool                   `json:"plugin_missing,omitempty"` // Плагин не установлен, будет установлен при выполнении
+	Components    []string               `json:"components"`
+	Atomic        bool                   `json:"atomic"`
+	Skipped       bool                   `json:"skipped,omitempty"`    // Условие 'when' ложно или все компоненты уже имеют 'to_version'
+	ToVersion     string                 `json:"to_version,omitempty"` // Версия компонентов после шага
+	When          []string               `json:"when,omitempty"`       // Условия, которые будут вычислены при выполнении
+	Action        map[string]interface{} `json:"action"`
+	Rollback      map[string]interface{} `json:"rollback,omitempty"`
+}
+
+// Plan строит упорядоченный план выполнения миграции без вызова плагинов.
+// Порядок совпадает с порядком выполнения при одном потоке.
+func (ms *MigrationSet) Plan(logMessage func(string, string, ...interface{})) ([]PlanStep, error) {
+
+	if ms.StageGraph == nil {
+		stageGraph, err := BuildStageGraph(ms.Stages, "")
+		if err != nil {
+			return nil, err
+		}
+		ms.StageGraph = stageGraph
+	}
+
+	var plan []PlanStep
+	if err := ms.planStages(&plan, ms.Stages, ms.Atomic, "", planCondition{}, logMessage); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+func (ms *MigrationSet) planStages(plan *[]PlanStep, stages []Stages, parentAtomic *bool, parentName string, parent planCondition, logMessage func(string, string, ...interface{})) error {
+
+	for _, stage := range ms.orderStages(stages, parentName) {
+		stageName := stage.setName(parentName, stage.Name)
+		atomic := stage.CheckMyAtomic(stageName, stage.Atomic, parentAtomic, logMessage)
+		stageCondition := parent.with(ms.planWhen(stage.When, nil))
+
+		addStep := func(kind string, name string, pluginType string, component map[string]interface{}, action map[string]interface{}, rollback map[string]interface{}, when string, task *Task) error {
+			// Селектор, зависящий от результатов шагов, разрешается только при выполнении
+			selector, err := RenderMap(component, ms.templateScope(nil))
+			if err != nil {
+				logMessage("DEBUG", fmt.Sprintf("[Plan > %s] %s '%s': 'component' %v", stageName, kind, name, err))
+			}
+			condition := stageCondition
+			toVersion := ""
+			if task != nil {
+				toVersion = task.ToVersion
+			}
+			var names []string
+			if err != nil {
+				names = []string{PLAN_UNRESOLVED_COMPONENTS}
+				condition = condition.with(ms.planWhen(when, nil))
+			} else {
+				stand, components, err := ms.StandsFile.matchComponents(selector)
+				if err != nil {
+					return fmt.Errorf("[Plan > %s] %s '%s': %v", stageName, kind, name, err)
+				}
+				if len(components) == 0 {
+					return fmt.Errorf("[Plan > %s] %s '%s': no component found for %v", stageName, kind, name, selector)
+				}
+				pending := false
+				for _, c := range components {
+					target := componentTarget{Name: c.Name, Source: c, Stand: stand}
+					componentCondition := ms.planWhen(when, &target)
+					if componentCondition.skipped {
+						continue
+					}
+					// Компоненты, уже имеющие 'to_version', не обновляются
+					if task != nil && task.ToVersion != "" {
+						versionPending, err := ms.versionPending(*task, target)
+						if err != nil {
+							return fmt.Errorf("[Plan > %s] %v", stageName, err)
+						}
+						if !versionPending {
+							continue
+						}
+					}
+					pending = pending || len(componentCondition.pending) != 0
+					names = append(names, c.Name)
+				}
+				if len(names) == 0 {
+					condition.skipped = true
+				}
+				if pending {
+					condition = condition.with(planCondition{pending: []string{when}})
+				}
+			}
+			_, installed := ms.PluginController.Executor(pluginType)
+			*plan = append(*plan, PlanStep{
+				Stage:         stageName,
+				Kind:          kind,
+				Name:          name,
+				Plugin:        pluginType,
+				PluginMissing: !installed,
+				Components:    names,
+				Atomic:        *atomic,
+				Skipped:       condition.skipped,
+				ToVersion:     toVersion,
+				When:          condition.pending,
+				Action:        action,
+				Rollback:      rollback,
+			})
+			return nil
+		}
+
+		for _, check := range stage.PreCheck {
+			if err := addStep("pre_check", check.Name, check.PluginType, check.Component, check.Actions, nil, check.When, nil); err != nil {
+				return err
+			}
+		}
+		for _, script := range stage.PreScript {
+			if err := addStep("pre_script", script.Name, script.PluginType, script.Component, script.Actions, script.Rollback, script.When, nil); err != nil {
+				return err
+			}
+		}
+		if err := ms.planStages(plan, stage.Stages, atomic, stageName, stageCondition, logMessage); err != nil {
+			return err
+		}
+		for _, task := range stage.Task {
+			if err := addStep("task", task.Name, task.PluginType, task.Component, task.Actions, task.Rollback, task.When, &task); err != nil {
+				return err
+			}
+		}
+		for _, script := range stage.PostScript {
+			if err := addStep("post_script", script.Name, script.PluginType, script.Component, script.Actions, script.Rollback, script.When, nil); err != nil {
+				return err
+			}
+		}
+		for _, check := range stage.PostCheck {
+			if err := addStep("post_check", check.Name, check.PluginType, check.Component, check.Actions, nil, check.When, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// planCondition условия 'when', от которых зависит шаг плана
+type planCondition struct {
+	skipped bool     // Одно из условий ложно уже при планировании
+	pending []string // Условия, зависящие от результатов шагов: вычисляются при выполнении
+}
+
+func (c planCondition) with(other planCondition) planCondition {
+	return planCondition{
+		skipped: c.skipped || other.skipped,
+		pending: append(append([]string{}, c.pending...), other.pending...),
+	}
+}
+
+// planWhen вычисляет условие 'when' для плана. Условие, которое нельзя вычислить
+// без результатов шагов или без компонента (target == nil), откладывается до выполнения.
+func (ms *MigrationSet) planWhen(when string, target *componentTarget) planCondition {
+
+	expr, err := parseWhen(when)
+	if expr == nil || err != nil {
+		return planCondition{}
+	}
+	if expr.Uses("component") && target == nil {
+		return planCondition{pending: []string{when}}
+	}
+	ok, err := expr.Eval(ms.templateScope(target))
+	if err != nil {
+		return planCondition{pending: []string{when}}
+	}
+	return planCondition{skipped: !ok}
+}
+
+// orderStages возвращает этапы одного уровня в топологическом порядке,
+// сохраняя порядок объявления для независимых этапов.
+func (ms *MigrationSet) orderStages(stages []Stages, parentName string) []Stages {
+
+	done := make(map[string]bool)
+	ordered := make([]Stages, 0, len(stages))
+
+	for len(ordered) < len(stages) {
+		progress := false
+		for _, stage := range stages {
+			name := stage.setName(parentName, stage.Name)
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, dep := range ms.StageGraph.Dependencies[name] {
+				if !done[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[name] = true
+				ordered = append(ordered, stage)
+				progress = true
+				break
+			}
+		}
+		// Циклы отсекаются при построении графа, но защищаемся от зацикливания
+		if !progress {
+			break
+		}
+	}
+	return ordered
+}
+
+// WritePlan выводит план выполнения в читаемом виде
+func WritePlan(w io.Writer, ms *MigrationSet, plan []PlanStep) error {
+
+	fmt.Fprintf(w, "Execution plan: '%s' => '%s' (%s, stands: %s)\n", ms.FromRelease, ms.ToRelease, ms.MigrationFile, ms.YAMLStandFile)
+	for i, step := range plan {
+		action, err := json.Marshal(step.Action)
+		if err != nil {
+			return fmt.Errorf("[Plan] %s.%s: %v", step.Stage, step.Name, err)
+		}
+		if step.Skipped {
+			fmt.Fprintf(w, "%3d. %s [%s] %s (skipped)\n", i+1, step.Stage, step.Kind, step.Name)
+			continue
+		}
+		fmt.Fprintf(w, "%3d. %s [%s] %s\n", i+1, step.Stage, step.Kind, step.Name)
+		pluginName := step.Plugin
+		if step.PluginMissing {
+			pluginName += " (not installed)"
+		}
+		fmt.Fprintf(w, "     plugin: %s, components: %s, atomic: %v\n", pluginName, strings.Join(step.Components, ", "), step.Atomic)
+		if step.ToVersion != "" {
+			fmt.Fprintf(w, "     to_version: %s\n", step.ToVersion)
+		}
+		for _, when := range step.When {
+			fmt.Fprintf(w, "     when: %s (evaluated at run time)\n", when)
+		}
+		fmt.Fprintf(w, "     action: %s\n", action)
+		if step.Rollback != nil {
+			rollback, err := json.Marshal(step.Rollback)
+			if err != nil {
+				return fmt.Errorf("[Plan] %s.%s: %v", step.Stage, step.Name, err)
+			}
+			fmt.Fprintf(w, "     rollback: %s\n", rollback)
+		}
+	}
+	fmt.Fprintf(w, "Total steps: %d\n", len(plan))
+	return nil
+}
@@ -0,0 +1,41 @@
+package run
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPlanMissingPlugin(t *testing.T) {
+	executor := &fakeExecutor{}
+	ms := newTestMigrationSet(t, "stages:\n"+testStage("a", "")+`- name: "b"
+  task:
+  - {name: "t", plugin: "missing", component: {name: "db"}, action: {cmd: "b"}}
+`, executor)
+
+	// Без установки (--dry-run) отсутствующий плагин не ошибка проверки и не устанавливается
+	if err := ms.CascadeValidation(*ms, false, testLog(t)); err != nil {
+		t.Fatalf("CascadeValidation() error: %v", err)
+	}
+	if _, ok := ms.PluginController.Executor("missing"); ok {
+		t.Error("plugin 'missing' was installed")
+	}
+
+	plan, err := ms.Plan(testLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan) != 2 || plan[0].PluginMissing || !plan[1].PluginMissing {
+		t.Fatalf("Plan() = %+v, want only step 'b' with missing plugin", plan)
+	}
+
+	var out strings.Builder
+	if err := WritePlan(&out, ms, plan); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "plugin: missing (not installed)") {
+		t.Errorf("WritePlan() = %q", out.String())
+	}
+	if calls := executor.Calls(); len(calls) != 0 {
+		t.Errorf("calls = %v, want none", calls)
+	}
+}
@@ -0,0 +1,506 @@
+package run
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	REPORT_STATUS_RUNNING     = "running"
+	REPORT_STATUS_OK          = "ok"
+	REPORT_STATUS_FAILED      = "failed"
+	REPORT_STATUS_SKIPPED     = "skipped"
+	REPORT_STATUS_ROLLED_BACK = "rolled-back"
+)
+
+// Report отчёт о выполнении миграции или патча: этапы и шаги с результатами
+type Report struct {
+	RunID         string         `json:"run_id,omitempty"`
+	MigrationFile string         `json:"migration_file"`
+	Stand         string         `json:"stand"`
+	FromRelease   string         `json:"from_release"`
+	ToRelease     string         `json:"to_release"`
+	Status        string         `json:"status"`
+	Error         string         `json:"error,omitempty"`
+	StartTime     time.Time      `json:"start_time"`
+	EndTime       time.Time      `json:"end_time"`
+	Duration      float64        `json:"duration_seconds"`
+	Stages        []*ReportStage `json:"stages"`
+	Steps         []*ReportStep  `json:"steps"`
+	mu            sync.Mutex
+}
+
+// ReportStage результат этапа; Path - полное имя этапа ('MDM.Adapter')
+type ReportStage struct {
+	Path      string    `json:"path"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Reason    string    `json:"reason,omitempty"` // Причина пропуска
+	StartTime time.Time `json:"start_time,omitempty"`
+	EndTime   time.Time `json:"end_time,omitempty"`
+	Duration  float64   `json:"duration_seconds"`
+}
+
+// ReportStep результат шага этапа
+type ReportStep struct {
+	Stage         string    `json:"stage"`
+	Kind          string    `json:"kind"`
+	Name          string    `json:"name"`
+	Plugin        string    `json:"plugin,omitempty"`
+	Components    []string  `json:"components,omitempty"`
+	Status        string    `json:"status"`
+	Error         string    `json:"error,omitempty"`
+	Reason        string    `json:"reason,omitempty"` // Причина пропуска
+	RollbackError string    `json:"rollback_error,omitempty"`
+	Attempts      int       `json:"attempts"`
+	StartTime     time.Time `json:"start_time,omitempty"`
+	EndTime       time.Time `json:"end_time,omitempty"`
+	Duration      float64   `json:"duration_seconds"`
+}
+
+// NewReport создаёт пустой отчёт
+func NewReport() *Report {
+	return &Report{}
+}
+
+// Begin отмечает начало выполнения миграции ms. Повторный вызов (запуск начат до валидации,
+// выполнение - в UpdateRelease) обновляет данные миграции, но сохраняет время начала.
+func (r *Report) Begin(ms *MigrationSet) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.RunID = ms.RunID
+	r.MigrationFile = ms.MigrationFile
+	r.Stand = ms.StandName()
+	r.FromRelease = ms.FromRelease
+	r.ToRelease = ms.ToRelease
+	r.Status = REPORT_STATUS_RUNNING
+	if r.StartTime.IsZero() {
+		r.StartTime = time.Now()
+	}
+}
+
+// Started сообщает, начиналось ли выполнение
+func (r *Report) Started() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !r.StartTime.IsZero()
+}
+
+// End отмечает завершение выполнения с результатом runErr. Завершённый отчёт не меняется:
+// результат UpdateRelease не перезаписывается итоговой ошибкой запуска.
+func (r *Report) End(runErr error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.EndTime.IsZero() {
+		return
+	}
+	r.EndTime = time.Now()
+	r.Duration = r.EndTime.Sub(r.StartTime).Seconds()
+	r.Status = REPORT_STATUS_OK
+	if runErr != nil {
+		r.Status = REPORT_STATUS_FAILED
+		r.Error = runErr.Error()
+	}
+}
+
+// StartStage отмечает начало выполнения этапа
+func (r *Report) StartStage(path string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.stage(path).Status = REPORT_STATUS_RUNNING
+	r.stage(path).StartTime = time.Now()
+}
+
+// FinishStage отмечает завершение этапа. Этап, пропущенный во время выполнения, остаётся пропущенным.
+// Неатомарный этап не возвращает ошибки шагов, поэтому этап с неуспешным шагом тоже считается неуспешным.
+func (r *Report) FinishStage(path string, stageErr error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stage := r.stage(path)
+	stage.EndTime = time.Now()
+	stage.Duration = stage.EndTime.Sub(stage.StartTime).Seconds()
+	if stage.Status != REPORT_STATUS_RUNNING {
+		return
+	}
+	stage.Status = REPORT_STATUS_OK
+	if stageErr != nil {
+		stage.Status = REPORT_STATUS_FAILED
+		stage.Error = stageErr.Error()
+		return
+	}
+	for _, step := range r.Steps {
+		if step.Stage == path && step.Status == REPORT_STATUS_FAILED {
+			stage.Status = REPORT_STATUS_FAILED
+			stage.Error = fmt.Sprintf("step '%s.%s' failed", step.Kind, step.Name)
+			return
+		}
+	}
+}
+
+// SkipStage отмечает этап пропущенным
+func (r *Report) SkipStage(path string, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stage := r.stage(path)
+	stage.Status = REPORT_STATUS_SKIPPED
+	stage.Reason = reason
+}
+
+// SkipStageTree отмечает пропущенными незапущенный этап, его шаги и вложенные этапы
+func (r *Report) SkipStageTree(stage Stages, stageName string, reason string) {
+	if r == nil {
+		return
+	}
+
+	r.SkipStage(stageName, reason)
+	skip := func(kind string, name string) {
+		r.SkipStep(stageName, kind, name, reason)
+	}
+	for _, check := range stage.PreCheck {
+		skip("pre_check", check.Name)
+	}
+	for _, script := range stage.PreScript {
+		skip("pre_script", script.Name)
+	}
+	for _, task := range stage.Task {
+		skip("task", task.Name)
+	}
+	for _, script := range stage.PostScript {
+		skip("post_script", script.Name)
+	}
+	for _, check := range stage.PostCheck {
+		skip("post_check", check.Name)
+	}
+	for _, subStage := range stage.Stages {
+		r.SkipStageTree(subStage, stage.setName(stageName, subStage.Name), reason)
+	}
+}
+
+// StartStep отмечает начало выполнения шага
+func (r *Report) StartStep(stageName string, kind string, name string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	step := r.step(stageName, kind, name)
+	step.Status = REPORT_STATUS_RUNNING
+	step.StartTime = time.Now()
+	step.Attempts = 1
+}
+
+// FinishStep отмечает завершение шага с результатом stepErr
+func (r *Report) FinishStep(stageName string, kind string, name string, stepErr error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	step := r.step(stageName, kind, name)
+	step.EndTime = time.Now()
+	step.Duration = step.EndTime.Sub(step.StartTime).Seconds()
+	step.Status = REPORT_STATUS_OK
+	if stepErr != nil {
+		step.Status = REPORT_STATUS_FAILED
+		step.Error = stepErr.Error()
+	}
+}
+
+// SkipStep отмечает шаг пропущенным
+func (r *Report) SkipStep(stageName string, kind string, name string, reason string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	step := r.step(stageName, kind, name)
+	if !step.StartTime.IsZero() {
+		step.EndTime = time.Now()
+		step.Duration = step.EndTime.Sub(step.StartTime).Seconds()
+	}
+	step.Status = REPORT_STATUS_SKIPPED
+	step.Reason = reason
+}
+
+// Target дополняет выполняемый шаг этапа stageName плагином и компонентами.
+// Тип шага исполнителю неизвестен: имена шагов одного этапа выполняются по очереди,
+// поэтому выполняемый шаг с этим именем единственный.
+func (r *Report) Target(stageName string, name string, plugin string, targets []componentTarget) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if step := r.running(stageName, name); step != nil {
+		step.Plugin = plugin
+		step.Components = step.Components[:0]
+		for _, target := range targets {
+			step.Components = append(step.Components, target.Name)
+		}
+	}
+}
+
+// Attempts запоминает число попыток выполняемого шага (максимум по компонентам)
+func (r *Report) Attempts(stageName string, name string, attempts int) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if step := r.running(stageName, name); step != nil && attempts > step.Attempts {
+		step.Attempts = attempts
+	}
+}
+
+// RolledBack отмечает результат отката действия графа '<этап>.<тип>.<имя>[@компонент]'.
+// Откаты этапа ('rollback') добавляются в отчёт отдельными шагами.
+func (r *Report) RolledBack(actionName string, rollbackErr error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := actionName
+	if i := strings.LastIndex(key, "@"); i >= 0 {
+		key = key[:i]
+	}
+
+	var step *ReportStep
+	for _, candidate := range r.Steps {
+		if stepKey(candidate.Stage, candidate.Kind, candidate.Name) == key {
+			step = candidate
+		}
+	}
+	if step == nil {
+		// Скрипт отката этапа: '<этап>.rollback.<имя>', имя этапа может содержать точки
+		for _, stage := range r.Stages {
+			name := strings.TrimPrefix(key, stage.Path+".rollback.")
+			if name == key {
+				continue
+			}
+			step = r.step(stage.Path, "rollback", name)
+			step.StartTime = time.Now()
+			step.EndTime = step.StartTime
+			step.Attempts = 1
+			step.Status = REPORT_STATUS_OK
+			if rollbackErr != nil {
+				step.Status = REPORT_STATUS_FAILED
+				step.Error = rollbackErr.Error()
+			}
+			return
+		}
+		return
+	}
+
+	if rollbackErr != nil {
+		step.RollbackError = rollbackErr.Error()
+		return
+	}
+	// Ошибка шага важнее отката его выполненных компонентов
+	if step.Status != REPORT_STATUS_FAILED {
+		step.Status = REPORT_STATUS_ROLLED_BACK
+	}
+}
+
+// stage возвращает запись этапа, создавая её. Вызывается под блокировкой.
+func (r *Report) stage(path string) *ReportStage {
+	for _, stage := range r.Stages {
+		if stage.Path == path {
+			return stage
+		}
+	}
+	stage := &ReportStage{Path: path}
+	r.Stages = append(r.Stages, stage)
+	return stage
+}
+
+// step возвращает запись шага, создавая её. Вызывается под блокировкой.
+func (r *Report) step(stageName string, kind string, name string) *ReportStep {
+	for _, step := range r.Steps {
+		if step.Stage == stageName && step.Kind == kind && step.Name == name {
+			return step
+		}
+	}
+	step := &ReportStep{Stage: stageName, Kind: kind, Name: name}
+	r.Steps = append(r.Steps, step)
+	return step
+}
+
+// running возвращает выполняемый шаг этапа по имени. Вызывается под блокировкой.
+func (r *Report) running(stageName string, name string) *ReportStep {
+	for _, step := range r.Steps {
+		if step.Stage == stageName && step.Name == name && step.Status == REPORT_STATUS_RUNNING {
+			return step
+		}
+	}
+	return nil
+}
+
+// WriteReportJSON выводит отчёты запусков в JSON: {"runs": [...]}
+func WriteReportJSON(w io.Writer, reports ...*Report) error {
+
+	for _, report := range reports {
+		report.mu.Lock()
+		defer report.mu.Unlock()
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(map[string]interface{}{"runs": reports}); err != nil {
+		return fmt.Errorf("[Report]>[JSON] %v", err)
+	}
+	return nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Skipped  int              `xml:"skipped,attr"`
+	Time     float64          `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Skipped   int             `xml:"skipped,attr"`
+	Time      float64         `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr,omitempty"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      float64       `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitMessage struct {
+	Type    string `xml:"type,attr,omitempty"`
+	Message string `xml:"message,attr"`
+}
+
+// WriteReportJUnit выводит отчёты в формате JUnit XML: testsuite - этап запуска, testcase - шаг
+func WriteReportJUnit(w io.Writer, reports ...*Report) error {
+
+	suites := junitTestSuites{Name: "roller"}
+	for _, report := range reports {
+		report.mu.Lock()
+		defer report.mu.Unlock()
+
+		suites.Time += report.Duration
+		for _, stage := range report.Stages {
+			suite := junitTestSuite{
+				Name: fmt.Sprintf("%s: %s", report.MigrationFile, stage.Path),
+				Time: stage.Duration,
+			}
+			if !stage.StartTime.IsZero() {
+				suite.Timestamp = stage.StartTime.Format("2006-01-02T15:04:05")
+			}
+			for _, step := range report.Steps {
+				if step.Stage != stage.Path {
+					continue
+				}
+				testCase := junitTestCase{
+					ClassName: step.Stage,
+					Name:      step.Kind + "." + step.Name,
+					Time:      step.Duration,
+					SystemOut: fmt.Sprintf("plugin: %s, components: %s, attempts: %d", step.Plugin, strings.Join(step.Components, ", "), step.Attempts),
+				}
+				switch step.Status {
+				case REPORT_STATUS_FAILED, REPORT_STATUS_RUNNING:
+					testCase.Failure = &junitMessage{Type: step.Status, Message: step.Error}
+					suite.Failures++
+				case REPORT_STATUS_SKIPPED:
+					testCase.Skipped = &junitMessage{Message: step.Reason}
+					suite.Skipped++
+				case REPORT_STATUS_ROLLED_BACK:
+					testCase.Skipped = &junitMessage{Type: step.Status, Message: "rolled back"}
+					suite.Skipped++
+				}
+				suite.Cases = append(suite.Cases, testCase)
+				suite.Tests++
+			}
+			suites.Tests += suite.Tests
+			suites.Failures += suite.Failures
+			suites.Skipped += suite.Skipped
+			suites.Suites = append(suites.Suites, suite)
+		}
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return fmt.Errorf("[Report]>[JUnit] %v", err)
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suites); err != nil {
+		return fmt.Errorf("[Report]>[JUnit] %v", err)
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteReportFiles записывает отчёты в файлы jsonPath и junitPath; пустой путь - не записывать
+func WriteReportFiles(jsonPath string, junitPath string, reports ...*Report) error {
+
+	write := func(path string, writer func(io.Writer, ...*Report) error) error {
+		if path == "" {
+			return nil
+		}
+		file, err := os.Create(path)
+		if err != nil {
+			return fmt.Errorf("[Report]>[Write] %v", err)
+		}
+		if err := writer(file, reports...); err != nil {
+			file.Close()
+			return err
+		}
+		return file.Close()
+	}
+
+	if err := write(jsonPath, WriteReportJSON); err != nil {
+		return err
+	}
+	return write(junitPath, WriteReportJUnit)
+}
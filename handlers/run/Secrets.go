@@ -0,0 +1,230 @@
+package run
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	DEFAULT_SECRET_KEY_FILE = "./roller.key" // Ключ AES-256 для значений '${enc:...}'
+	SECRET_REDACTED         = "******"
+)
+
+// Ссылки на секреты в конфигурации компонента:
+//
+//	${env:PG_PASS}              - переменная окружения
+//	${file:/run/secrets/pg}     - содержимое файла без завершающего перевода строки
+//	${enc:BASE64}               - значение, зашифрованное ключом DEFAULT_SECRET_KEY_FILE ('roller secret encrypt')
+var secretRefRegexp = regexp.MustCompile(`\$\{(env|file|enc):([^}]+)\}`)
+
+// Ключи шифрования по пути файла: файл читается один раз за запуск
+var (
+	secretKeys   = make(map[string][]byte)
+	secretKeysMu sync.Mutex
+)
+
+// ResolveSecrets возвращает копию конфигурации, в которой ссылки на секреты заменены значениями.
+// Исходная конфигурация не меняется: разрешённые значения передаются только плагину.
+func ResolveSecrets(config map[string]interface{}) (map[string]interface{}, error) {
+
+	resolved, err := resolveSecretValue(config)
+	if err != nil {
+		return nil, err
+	}
+	if resolved == nil {
+		return nil, nil
+	}
+	return resolved.(map[string]interface{}), nil
+}
+
+// RedactSecrets возвращает копию значения для вывода в лог: строки со ссылками на секреты
+// заменяются на SECRET_REDACTED
+func RedactSecrets(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		if secretRefRegexp.MatchString(v) {
+			return SECRET_REDACTED
+		}
+		return v
+	case map[string]interface{}:
+		redacted := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			redacted[key] = RedactSecrets(item)
+		}
+		return redacted
+	case []interface{}:
+		redacted := make([]interface{}, len(v))
+		for i, item := range v {
+			redacted[i] = RedactSecrets(item)
+		}
+		return redacted
+	}
+	return value
+}
+
+// resolveSecretValue рекурсивно разрешает ссылки в строках, картах и списках
+func resolveSecretValue(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return resolveSecretString(v)
+	case map[string]interface{}:
+		if v == nil {
+			return nil, nil
+		}
+		resolved := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			value, err := resolveSecretValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("'%s': %v", key, err)
+			}
+			resolved[key] = value
+		}
+		return resolved, nil
+	case []interface{}:
+		resolved := make([]interface{}, len(v))
+		for i, item := range v {
+			value, err := resolveSecretValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %v", i, err)
+			}
+			resolved[i] = value
+		}
+		return resolved, nil
+	}
+	return value, nil
+}
+
+// resolveSecretString заменяет все ссылки в строке: 'postgres://app:${env:PG_PASS}@db'
+func resolveSecretString(value string) (string, error) {
+
+	var resolveErr error
+	resolved := secretRefRegexp.ReplaceAllStringFunc(value, func(ref string) string {
+		if resolveErr != nil {
+			return ref
+		}
+		match := secretRefRegexp.FindStringSubmatch(ref)
+		secret, err := resolveSecretRef(match[1], strings.TrimSpace(match[2]))
+		if err != nil {
+			resolveErr = err
+			return ref
+		}
+		return secret
+	})
+	if resolveErr != nil {
+		return "", resolveErr
+	}
+	return resolved, nil
+}
+
+func resolveSecretRef(source string, arg string) (string, error) {
+	switch source {
+	case "env":
+		secret, ok := os.LookupEnv(arg)
+		if !ok {
+			return "", fmt.Errorf("[Secrets] environment variable '%s' is not set", arg)
+		}
+		return secret, nil
+	case "file":
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return "", fmt.Errorf("[Secrets] %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case "enc":
+		return DecryptSecret(DEFAULT_SECRET_KEY_FILE, arg)
+	}
+	return "", fmt.Errorf("[Secrets] unknown secret source '%s'", source)
+}
+
+// GenerateSecretKey создаёт файл ключа AES-256 (base64) с правами 0600. Существующий файл не перезаписывается.
+func GenerateSecretKey(keyFile string) error {
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return fmt.Errorf("[Secrets]>[Keygen] %v", err)
+	}
+	file, err := os.OpenFile(keyFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("[Secrets]>[Keygen] %v", err)
+	}
+	if _, err := file.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		file.Close()
+		return fmt.Errorf("[Secrets]>[Keygen] %v", err)
+	}
+	return file.Close()
+}
+
+// EncryptSecret шифрует значение ключом из keyFile (AES-256-GCM) и возвращает ссылку '${enc:...}'
+func EncryptSecret(keyFile string, plaintext string) (string, error) {
+
+	gcm, err := secretCipher(keyFile)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", fmt.Errorf("[Secrets]>[Encrypt] %v", err)
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "${enc:" + base64.StdEncoding.EncodeToString(sealed) + "}", nil
+}
+
+// DecryptSecret расшифровывает значение ссылки '${enc:...}' ключом из keyFile
+func DecryptSecret(keyFile string, encoded string) (string, error) {
+
+	gcm, err := secretCipher(keyFile)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("[Secrets]>[Decrypt] %v", err)
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("[Secrets]>[Decrypt] encrypted value is too short")
+	}
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("[Secrets]>[Decrypt] wrong key '%s' or corrupted value", keyFile)
+	}
+	return string(plaintext), nil
+}
+
+// secretCipher читает ключ из keyFile: 32 байта в base64 или в двоичном виде
+func secretCipher(keyFile string) (cipher.AEAD, error) {
+
+	secretKeysMu.Lock()
+	key, ok := secretKeys[keyFile]
+	secretKeysMu.Unlock()
+
+	if !ok {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("[Secrets] key file: %v", err)
+		}
+		key = data
+		if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data))); err == nil {
+			key = decoded
+		}
+		if len(key) != 32 {
+			return nil, fmt.Errorf("[Secrets] key file '%s' must contain a 32-byte AES-256 key", keyFile)
+		}
+		secretKeysMu.Lock()
+		secretKeys[keyFile] = key
+		secretKeysMu.Unlock()
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("[Secrets] %v", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,174 @@
+package run
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func newTestKey(t *testing.T) string {
+	t.Helper()
+	keyFile := filepath.Join(t.TempDir(), "roller.key")
+	if err := GenerateSecretKey(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+func TestEncryptDecryptSecret(t *testing.T) {
+	keyFile := newTestKey(t)
+
+	ref, err := EncryptSecret(keyFile, "p@ss:word")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(ref, "${enc:") || !strings.HasSuffix(ref, "}") {
+		t.Fatalf("EncryptSecret() = %q, want '${enc:...}'", ref)
+	}
+	encoded := strings.TrimSuffix(strings.TrimPrefix(ref, "${enc:"), "}")
+
+	got, err := DecryptSecret(keyFile, encoded)
+	if err != nil || got != "p@ss:word" {
+		t.Fatalf("DecryptSecret() = %q, %v, want p@ss:word", got, err)
+	}
+
+	// Одинаковые значения шифруются по-разному
+	if other, _ := EncryptSecret(keyFile, "p@ss:word"); other == ref {
+		t.Error("EncryptSecret() reuses the nonce")
+	}
+
+	// Существующий ключ не перезаписывается
+	if err := GenerateSecretKey(keyFile); err == nil {
+		t.Error("GenerateSecretKey() over an existing key: want error")
+	}
+}
+
+func TestDecryptSecretErrors(t *testing.T) {
+	keyFile := newTestKey(t)
+	ref, err := EncryptSecret(keyFile, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := strings.TrimSuffix(strings.TrimPrefix(ref, "${enc:"), "}")
+
+	badKey := filepath.Join(t.TempDir(), "short.key")
+	writeTestFile(t, badKey, base64.StdEncoding.EncodeToString([]byte("short")))
+
+	tests := []struct {
+		name    string
+		keyFile string
+		value   string
+		wantErr string
+	}{
+		{name: "wrong key", keyFile: newTestKey(t), value: encoded, wantErr: "wrong key"},
+		{name: "short ciphertext", keyFile: keyFile, value: base64.StdEncoding.EncodeToString([]byte("abc")), wantErr: "too short"},
+		{name: "not base64", keyFile: keyFile, value: "%%%", wantErr: "[Secrets]>[Decrypt]"},
+		{name: "missing key file", keyFile: filepath.Join(t.TempDir(), "missing.key"), value: encoded, wantErr: "key file"},
+		{name: "short key", keyFile: badKey, value: encoded, wantErr: "must contain a 32-byte AES-256 key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DecryptSecret(tt.keyFile, tt.value); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("DecryptSecret() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	t.Setenv("ROLLER_TEST_USER", "app")
+	t.Setenv("ROLLER_TEST_PASS", "s3cret")
+	secretFile := filepath.Join(t.TempDir(), "pg")
+	writeTestFile(t, secretFile, "from-file\r\n")
+
+	keyFile := newTestKey(t)
+	defaultKey := DEFAULT_SECRET_KEY_FILE
+	DEFAULT_SECRET_KEY_FILE = keyFile
+	defer func() { DEFAULT_SECRET_KEY_FILE = defaultKey }()
+	encrypted, err := EncryptSecret(keyFile, "encrypted")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := map[string]interface{}{
+		"dsn":   "postgres://${env:ROLLER_TEST_USER}:${env: ROLLER_TEST_PASS }@db/${file:" + secretFile + "}",
+		"file":  "${file:" + secretFile + "}",
+		"token": encrypted,
+		"port":  5432,
+		"hosts": []interface{}{"a", map[string]interface{}{"pass": "${env:ROLLER_TEST_PASS}"}},
+	}
+	resolved, err := ResolveSecrets(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"dsn":   "postgres://app:s3cret@db/from-file",
+		"file":  "from-file",
+		"token": "encrypted",
+		"port":  5432,
+		"hosts": []interface{}{"a", map[string]interface{}{"pass": "s3cret"}},
+	}
+	if !reflect.DeepEqual(resolved, want) {
+		t.Errorf("ResolveSecrets() = %v, want %v", resolved, want)
+	}
+
+	// Исходная конфигурация не меняется
+	if config["file"] != "${file:"+secretFile+"}" {
+		t.Errorf("ResolveSecrets() changed the source config: %v", config)
+	}
+	if resolved, err := ResolveSecrets(nil); resolved != nil || err != nil {
+		t.Errorf("ResolveSecrets(nil) = %v, %v, want nil, nil", resolved, err)
+	}
+}
+
+func TestResolveSecretsErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  map[string]interface{}
+		wantErr string
+	}{
+		{name: "missing env", config: map[string]interface{}{"pass": "${env:ROLLER_TEST_MISSING}"}, wantErr: "'pass': [Secrets] environment variable 'ROLLER_TEST_MISSING' is not set"},
+		{name: "missing in list", config: map[string]interface{}{"hosts": []interface{}{"a", "${env:ROLLER_TEST_MISSING}"}}, wantErr: "'hosts': [1]: "},
+		{name: "missing file", config: map[string]interface{}{"pass": "${file:/nonexistent/roller/secret}"}, wantErr: "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ResolveSecrets(tt.config); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ResolveSecrets() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	config := map[string]interface{}{
+		"host": "db",
+		"dsn":  "postgres://app:${env:PG_PASS}@db",
+		"port": 5432,
+		"auth": map[string]interface{}{
+			"user":  "app",
+			"token": "${enc:AAAA}",
+			"keys":  []interface{}{"${file:/run/key}", "plain", 1},
+		},
+	}
+	want := map[string]interface{}{
+		"host": "db",
+		"dsn":  SECRET_REDACTED,
+		"port": 5432,
+		"auth": map[string]interface{}{
+			"user":  "app",
+			"token": SECRET_REDACTED,
+			"keys":  []interface{}{SECRET_REDACTED, "plain", 1},
+		},
+	}
+	if got := RedactSecrets(config); !reflect.DeepEqual(got, want) {
+		t.Errorf("RedactSecrets() = %v, want %v", got, want)
+	}
+	if config["dsn"] != "postgres://app:${env:PG_PASS}@db" {
+		t.Errorf("RedactSecrets() changed the source config: %v", config)
+	}
+}
@@ -13,21 +13,34 @@ type Stages struct {
 	//Set         *MigrationSet
 	Name        string      `yaml:"name"`       // Имя этапа
 	Description string      `yaml:"desc"`       // Описание этапа
-	Dependence  interface{} `yaml:"dependence"` // Зависимости этапа
+	Dependence  interface{} `yaml:"dependence"` // Зависимости этапа: имя или список имён этапов того же уровня
 	Atomic      *bool       `yaml:"atomic"`     // Флаг атомарности: если true, этап останавливается при ошибке
+	When        string      `yaml:"when"`       // Условие выполнения этапа
 	PreCheck    []Check     `yaml:"pre_check"`  // Предварительная проверка перед выполнением этапа
 	PreScript   []Script    `yaml:"pre_script"` // Предварительный скрипт перед выполнением этапа
 	Task        []Task      `yaml:"task"`
 	PostCheck   []Check     `yaml:"post_check"`   // Пост-проверка после выполнения этапа
 	PostScript  []Script    `yaml:"post_scriprt"` // Пост-скрипт после выполнения этапа
-	Rollback    bool        `yaml:"rollback"`     // Флаг отката: если true, позволяет откатить изменения
+	Rollback    []Script    `yaml:"rollback"`     // Компенсирующие скрипты: выполняются при откате завершённого этапа
 	Stages      []Stages    `yaml:"stages"`       // Шаги, которые входят в этот этап
 }
 
-func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stands StandsFile, logMessage func(string, string, ...interface{})) error {
+func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stands StandsFile, install bool, logMessage func(string, string, ...interface{})) error {
 
 	logMessage("DEBUG", fmt.Sprintf("[Stage:'%s']>[Valid] Start validation", stage.Name))
 
+	when, err := parseWhen(stage.When)
+	if err != nil {
+		return fmt.Errorf("[Stage:'%s']>[Valid] 'when' %v", stage.Name, err)
+	}
+	if when != nil && when.Uses("component") {
+		return fmt.Errorf("[Stage:'%s']>[Valid] 'when' of a stage cannot use 'component'", stage.Name)
+	}
+	// Условие этапа вычисляется один раз, поэтому 'stand' в нём однозначен только для одного стенда
+	if when != nil && when.Uses("stand") && stands.selectedStand() == nil {
+		return fmt.Errorf("[Stage:'%s']>[Valid] 'when' of a stage uses 'stand', but stands file has %d stands: select one with --stand or move the condition to the steps", stage.Name, len(stands.stands()))
+	}
+
 	//if len(stage.Task) == 0 && len(stage.Stages) == 0 {
 	//	return fmt.Errorf("[Stages > %s]>[Valid] 'task' and 'stages' is empty", stage.Name)
 	//}
@@ -50,7 +63,7 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 
 			logMessage("DEBUG", fmt.Sprintf("[Stage:'%s']>[Valid] Starting CheckValideData PreCheck: '%s'", stage.Name, PreCheck.Name))
 			// Проверяем остальные данные компонента
-			_, _, PreCheckErr := PreCheck.CascadeValidation(PreCheck, pc, stands, logMessage)
+			_, _, PreCheckErr := PreCheck.CascadeValidation(PreCheck, pc, stands, install, logMessage)
 			if PreCheckErr != nil {
 				return PreCheckErr
 			}
@@ -79,7 +92,7 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 
 			logMessage("DEBUG", fmt.Sprintf("[Stages > %s]>[Valid] Starting CheckValideData PreScript: '%s'", stage.Name, PreScript.Name))
 			// Проверяем остальные данные компонента
-			_, _, PreScriptErr := PreScript.CascadeValidation(PreScript, pc, stands, logMessage)
+			_, _, PreScriptErr := PreScript.CascadeValidation(PreScript, pc, stands, install, logMessage)
 			if PreScriptErr != nil {
 				return PreScriptErr
 			}
@@ -105,7 +118,7 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 			nameSet[Stage.Name] = true
 
 			// Проверяем остальные данные компонента
-			componentErr := Stage.CheckValideData(Stage, pc, stands, logMessage)
+			componentErr := Stage.CheckValideData(Stage, pc, stands, install, logMessage)
 			if componentErr != nil {
 				return componentErr
 			}
@@ -131,7 +144,7 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 			nameSet[PostCheck.Name] = true
 
 			// Проверяем остальные данные компонента
-			_, _, componentErr := PostCheck.CascadeValidation(PostCheck, pc, stands, logMessage)
+			_, _, componentErr := PostCheck.CascadeValidation(PostCheck, pc, stands, install, logMessage)
 			if componentErr != nil {
 				return componentErr
 			}
@@ -157,7 +170,7 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 			nameSet[PostScript.Name] = true
 
 			// Проверяем остальные данные компонента
-			_, _, PreScriptErr := PostScript.CascadeValidation(PostScript, pc, stands, logMessage)
+			_, _, PreScriptErr := PostScript.CascadeValidation(PostScript, pc, stands, install, logMessage)
 			if PreScriptErr != nil {
 				return PreScriptErr
 			}
@@ -165,6 +178,28 @@ func (s *Stages) CheckValideData(stage Stages, pc *plugin.PluginController, stan
 	} else {
 		logMessage("DEBUG", fmt.Sprintf("[Stages > %s]>[Valid] Missing PostScript...", stage.Name))
 	}
+
+	//Валидация скриптов отката этапа, если они есть
+	if len(stage.Rollback) != 0 {
+		nameSet := make(map[string]bool)
+		for _, Rollback := range stage.Rollback {
+			if Rollback.Name == "" {
+				return fmt.Errorf("[Stages > %s]>[Valid] Rollback.Name is empty", stage.Name)
+			}
+
+			if nameSet[Rollback.Name] {
+				return fmt.Errorf("[Stages > %s]>[Valid] duplicate Rollback.Name found: %s", stage.Name, Rollback.Name)
+			}
+			nameSet[Rollback.Name] = true
+
+			_, _, RollbackErr := Rollback.CascadeValidation(Rollback, pc, stands, install, logMessage)
+			if RollbackErr != nil {
+				return RollbackErr
+			}
+		}
+	} else {
+		logMessage("DEBUG", fmt.Sprintf("[Stages > %s]>[Valid] Missing Rollback...", stage.Name))
+	}
 	return nil
 }
 
@@ -208,10 +243,23 @@ func (s *Stages) CheckMyAtomic(stageName string, myAtomic *bool, parentAtomic *b
 	return atomFlag
 }
 
-func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, parentName string, logMessage func(string, string, ...interface{})) error {
+func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, parentName string, logger *Logger) error {
 	// Создаём локальную переменную для хранения атомарности текущего этапа
 	//var ATOMIC_STAGE = new(bool)
 	stageName := s.setName(parentName, stage.Name)
+	logger = logger.With(LOG_FIELD_STAGE, stageName)
+	logMessage := logger.Log
+
+	// Условие этапа вычисляется до его шагов; зависимые этапы пропуск не останавливает
+	run, err := EvalWhen(stage.When, ms.templateScope(nil))
+	if err != nil {
+		return fmt.Errorf("[Stage > %s] 'when' %v", stageName, err)
+	}
+	if !run {
+		logMessage("INFO", fmt.Sprintf("========== Skip stage: %s (when '%s' is false) ==========", stage.Name, stage.When))
+		ms.skipStage(stage, stageName, fmt.Sprintf("when '%s' is false", stage.When), logMessage)
+		return nil
+	}
 
 	logMessage("INFO", fmt.Sprintf("========== Start stage: %s ==========", stage.Name))
 
@@ -222,23 +270,25 @@ func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, p
 
 	// Проверяем и вычисляем атомарность этапа
 	MY_ATOMIC_STAGE := stage.CheckMyAtomic(stageName, stage.Atomic, parentAtomic, logMessage)
+	// Неатомарный этап выполняет все шаги и возвращает первую ошибку после них
+	var stageErr error
 
 	// Шаг 1: Выполняем PreCheck, если он указан
 	if stage.PreCheck != nil {
 
 		for _, PreCheck := range stage.PreCheck {
 
-			if err := PreCheck.ExecCheck(PreCheck, stageName, ms.PluginController, ms.StandsFile, logMessage); err != nil {
+			stepLogger := logger.Step("pre_check", PreCheck.Name, PreCheck.PluginType)
+			if err := ms.runStep(stageName, "pre_check", PreCheck.Name, stepLogger.Log, func() error {
+				return PreCheck.ExecCheck(PreCheck, stageName, ms, stepLogger)
+			}); err != nil {
 
 				logMessage("ERROR", fmt.Sprintf("[Stage > %s] PreCheck failed: %v", stageName, err))
+				if *MY_ATOMIC_STAGE {
+					return s.rollbackStage(stageName, ms, err, logger)
+				}
 				return err
 
-			} else {
-
-				//err := ms.AddActionToGraph(PreCheck.Name, ms.Action, []PreCheck.Actions)
-				//if err != nil {
-				//	return nil
-				//}
 			}
 		}
 	}
@@ -247,22 +297,31 @@ func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, p
 	if stage.PreScript != nil {
 
 		for _, PreScript := range stage.PreScript {
-			if err := PreScript.ExecScript(PreScript, stageName, ms.PluginController, ms.StandsFile, logMessage); err != nil {
+			stepLogger := logger.Step("pre_script", PreScript.Name, PreScript.PluginType)
+			if err := ms.runActionStep(stageName, "pre_script", PreScript.Name, PreScript.PluginType, PreScript.Component, PreScript.Actions, PreScript.Rollback, stepLogger.Log, func() ([]string, error) {
+				return PreScript.ExecScript(PreScript, stageName, ms, stepLogger)
+			}); err != nil {
 				logMessage("ERROR", fmt.Sprintf("[Stage > %s] PreScript failed: %v", stageName, err))
 				if *MY_ATOMIC_STAGE {
-					return err
+					return s.rollbackStage(stageName, ms, err, logger)
+				}
+				if stageErr == nil {
+					stageErr = err
 				}
 			}
 		}
 	}
 
 	// Шаг 3: Выполняем вложенные этапы, если они есть
-	for _, subStage := range stage.Stages {
-		logMessage("INFO", fmt.Sprintf("[Stage > %s] Processing sub-stage: %s", stageName, subStage.Name))
-		if err := s.ExecStage(subStage, ms, MY_ATOMIC_STAGE, stageName, logMessage); err != nil {
-			logMessage("ERROR", fmt.Sprintf("[Stage > %s] Sub-stage %s failed: %v", stageName, subStage.Name, err))
+	if len(stage.Stages) != 0 {
+		logMessage("INFO", fmt.Sprintf("[Stage > %s] Processing %d sub-stage(s)", stageName, len(stage.Stages)))
+		if err := ms.ExecStages(stage.Stages, MY_ATOMIC_STAGE, stageName, *MY_ATOMIC_STAGE, logger); err != nil {
+			logMessage("ERROR", fmt.Sprintf("[Stage > %s] Sub-stage failed: %v", stageName, err))
 			if *MY_ATOMIC_STAGE {
-				return err
+				return s.rollbackStage(stageName, ms, err, logger)
+			}
+			if stageErr == nil {
+				stageErr = err
 			}
 		}
 	}
@@ -270,10 +329,16 @@ func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, p
 	// Шаг 4: Выполняем Task, если он указан
 	for _, task := range stage.Task {
 		logMessage("INFO", fmt.Sprintf("[Stage > %s] Executing Task...", stageName))
-		if err := task.ExecTask(task, stageName, ms.PluginController, ms.StandsFile, logMessage); err != nil {
+		stepLogger := logger.Step("task", task.Name, task.PluginType)
+		if err := ms.runActionStep(stageName, "task", task.Name, task.PluginType, task.Component, task.Actions, task.Rollback, stepLogger.Log, func() ([]string, error) {
+			return task.ExecTask(task, stageName, ms, stepLogger)
+		}); err != nil {
 			logMessage("ERROR", fmt.Sprintf("[Stage > %s] Task failed: %v", stageName, err))
 			if *MY_ATOMIC_STAGE {
-				return err
+				return s.rollbackStage(stageName, ms, err, logger)
+			}
+			if stageErr == nil {
+				stageErr = err
 			}
 		}
 	}
@@ -282,10 +347,16 @@ func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, p
 	if stage.PostScript != nil {
 		logMessage("INFO", fmt.Sprintf("[%s] Executing PostScript...", stageName))
 		for _, PostScript := range stage.PostScript {
-			if err := PostScript.ExecScript(PostScript, stageName, ms.PluginController, ms.StandsFile, logMessage); err != nil {
+			stepLogger := logger.Step("post_script", PostScript.Name, PostScript.PluginType)
+			if err := ms.runActionStep(stageName, "post_script", PostScript.Name, PostScript.PluginType, PostScript.Component, PostScript.Actions, PostScript.Rollback, stepLogger.Log, func() ([]string, error) {
+				return PostScript.ExecScript(PostScript, stageName, ms, stepLogger)
+			}); err != nil {
 				logMessage("ERROR", fmt.Sprintf("[%s] PostScript failed: %v", stageName, err))
 				if *MY_ATOMIC_STAGE {
-					return err
+					return s.rollbackStage(stageName, ms, err, logger)
+				}
+				if stageErr == nil {
+					stageErr = err
 				}
 			}
 		}
@@ -295,19 +366,74 @@ func (s *Stages) ExecStage(stage Stages, ms *MigrationSet, parentAtomic *bool, p
 	if stage.PostCheck != nil {
 		logMessage("INFO", fmt.Sprintf("[%s] Executing PostCheck...", stageName))
 		for _, PostCheck := range stage.PostCheck {
-			if err := PostCheck.ExecCheck(PostCheck, stageName, ms.PluginController, ms.StandsFile, logMessage); err != nil {
+			stepLogger := logger.Step("post_check", PostCheck.Name, PostCheck.PluginType)
+			if err := ms.runStep(stageName, "post_check", PostCheck.Name, stepLogger.Log, func() error {
+				return PostCheck.ExecCheck(PostCheck, stageName, ms, stepLogger)
+			}); err != nil {
 				logMessage("ERROR", fmt.Sprintf("[%s] PostCheck failed: %v", stageName, err))
 				if *MY_ATOMIC_STAGE {
-					return err
+					return s.rollbackStage(stageName, ms, err, logger)
+				}
+				if stageErr == nil {
+					stageErr = err
 				}
 			}
 		}
 	}
 
+	// Регистрируем скрипты отката этапа: при откате они выполнятся раньше откатов его шагов
+	for _, Rollback := range stage.Rollback {
+		ms.registerStep(stageName, "rollback", Rollback.Name, Rollback.PluginType, "", Rollback.Component, nil, Rollback.Actions, Rollback.When, logMessage)
+	}
+
+	if stageErr != nil {
+		logMessage("ERROR", fmt.Sprintf("[%s] Stage completed with errors: %v", stageName, stageErr))
+		return stageErr
+	}
 	logMessage("INFO", fmt.Sprintf("[%s] Stage completed successfully.", stageName))
 	return nil
 }
 
+// rollbackStage откатывает выполненные шаги атомарного этапа и возвращает исходную ошибку
+func (s *Stages) rollbackStage(stageName string, ms *MigrationSet, cause error, logger *Logger) error {
+
+	logMessage := logger.Log
+
+	logMessage("INFO", fmt.Sprintf("[Stage > %s] Atomic stage failed, rolling back completed steps", stageName))
+
+	if err := ms.rollbackActions(stageName+".", logger); err != nil {
+		return fmt.Errorf("%w; %w", cause, err)
+	}
+
+	logMessage("INFO", fmt.Sprintf("[Stage > %s] Rollback completed", stageName))
+	return cause
+}
+
+// Dependencies возвращает имена этапов из поля 'dependence'
+func (s *Stages) Dependencies() ([]string, error) {
+	switch dep := s.Dependence.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if dep == "" {
+			return nil, nil
+		}
+		return []string{dep}, nil
+	case []interface{}:
+		names := make([]string, 0, len(dep))
+		for _, item := range dep {
+			name, ok := item.(string)
+			if !ok || name == "" {
+				return nil, fmt.Errorf("'dependence' must contain stage names, got '%v'", item)
+			}
+			names = append(names, name)
+		}
+		return names, nil
+	default:
+		return nil, fmt.Errorf("'dependence' must be a stage name or a list of stage names")
+	}
+}
+
 func (s *Stages) setName(parentName string, currentName string) string {
 
 	var stageName string
@@ -0,0 +1,115 @@
+package run
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testNestedStages атомарный этап с двумя вложенными: шаг 't4' второго этапа завершается ошибкой
+const testNestedStages = `stages:
+- name: "outer"
+  atomic: true
+  pre_script:
+  - {name: "s0", plugin: "fake", component: {name: "db"}, action: {cmd: "s0"}, rollback: {cmd: "r0"}}
+  stages:
+  - name: "inner1"
+    task:
+    - {name: "t1", plugin: "fake", component: {name: "db"}, action: {cmd: "t1"}, rollback: {cmd: "r1"}}
+    - {name: "t2", plugin: "fake", component: {name: "db"}, action: {cmd: "t2"}, rollback: {cmd: "r2"}}
+    rollback:
+    - {name: "sr1", plugin: "fake", component: {name: "db"}, action: {cmd: "sr1"}}
+  - name: "inner2"
+    dependence: "inner1"
+    task:
+    - {name: "t3", plugin: "fake", component: {name: "db"}, action: {cmd: "t3"}, rollback: {cmd: "r3"}}
+    - {name: "t4", plugin: "fake", component: {name: "db"}, action: {cmd: "t4"}, rollback: {cmd: "r4"}}
+    - {name: "t5", plugin: "fake", component: {name: "db"}, action: {cmd: "t5"}, rollback: {cmd: "r5"}}
+  task:
+  - {name: "t6", plugin: "fake", component: {name: "db"}, action: {cmd: "t6"}, rollback: {cmd: "r6"}}
+`
+
+// runTestMigration проверяет миграцию и выполняет её этапы
+func runTestMigration(t *testing.T, ms *MigrationSet) error {
+	t.Helper()
+	if err := ms.CascadeValidation(*ms, true, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+	return ms.UpdateRelease(ms, testLog(t))
+}
+
+func TestAtomicStageRollbackOrder(t *testing.T) {
+	executor := &fakeExecutor{fail: map[string]bool{"t4": true}}
+	ms := newTestMigrationSet(t, testNestedStages, executor)
+
+	err := runTestMigration(t, ms)
+	if err == nil || !strings.Contains(err.Error(), "t4 failed") {
+		t.Fatalf("UpdateRelease() error = %v, want 't4 failed'", err)
+	}
+
+	// Откатываются только выполненные шаги, в обратном порядке: сначала вложенный этап,
+	// затем внешний. Скрипт отката этапа выполняется раньше откатов его шагов.
+	want := []string{"s0", "t1", "t2", "t3", "t4", "r3", "sr1", "r2", "r1", "r0"}
+	if got := executor.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+	if kind := ErrorKindOf(err); kind != ERROR_ACTION_FAILED {
+		t.Errorf("ErrorKindOf() = %s, want %s", kind, ERROR_ACTION_FAILED)
+	}
+}
+
+func TestAtomicStageRollbackFailed(t *testing.T) {
+	executor := &fakeExecutor{fail: map[string]bool{"t4": true, "r2": true}}
+	ms := newTestMigrationSet(t, testNestedStages, executor)
+
+	err := runTestMigration(t, ms)
+	if err == nil {
+		t.Fatal("UpdateRelease() error = nil, want error")
+	}
+
+	// Ошибка отката не останавливает откат остальных шагов
+	want := []string{"s0", "t1", "t2", "t3", "t4", "r3", "sr1", "r2", "r1", "r0"}
+	if got := executor.Calls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	// Возвращаются и причина отката, и ошибка отката
+	for _, part := range []string{"t4 failed", "rollback 'outer.inner1.task.t2@db' failed", "r2 failed"} {
+		if !strings.Contains(err.Error(), part) {
+			t.Errorf("error = %v, want %q", err, part)
+		}
+	}
+	if kind := ErrorKindOf(err); kind != ERROR_ROLLBACK_FAILED {
+		t.Errorf("ErrorKindOf() = %s, want %s", kind, ERROR_ROLLBACK_FAILED)
+	}
+}
+
+func TestRollbackStageWrapsErrors(t *testing.T) {
+	executor := &fakeExecutor{fail: map[string]bool{"r1": true}}
+	ms := newTestMigrationSet(t, "stages: []\n", executor)
+	logger := NewLogger(testLog(t))
+
+	ms.registerStep("A", "task", "t1", "fake", "db", nil, map[string]interface{}{"cmd": "t1"}, map[string]interface{}{"cmd": "r1"}, "", testLog(t))
+	ms.registerStep("B", "task", "t2", "fake", "db", nil, map[string]interface{}{"cmd": "t2"}, map[string]interface{}{"cmd": "r2"}, "", testLog(t))
+
+	cause := errors.New("step failed")
+	stage := &Stages{Name: "A"}
+	err := stage.rollbackStage("A", ms, cause, logger)
+
+	var runErr *RunError
+	if !errors.Is(err, cause) || !errors.As(err, &runErr) || runErr.Kind != ERROR_ROLLBACK_FAILED {
+		t.Fatalf("rollbackStage() = %v, want the cause and the rollback error", err)
+	}
+	// Откатываются только шаги этого этапа
+	if got, want := executor.Calls(), []string{"r1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+
+	// Успешный откат возвращает исходную ошибку
+	delete(executor.fail, "r1")
+	stage = &Stages{Name: "B"}
+	if err := stage.rollbackStage("B", ms, cause, logger); err != cause {
+		t.Errorf("rollbackStage() = %v, want %v", err, cause)
+	}
+}
@@ -2,8 +2,10 @@ package run
 
 import (
 	"fmt"
+	"path/filepath"
 	//"plugin"
 	"reflect"
+	"strings"
 
 	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
 )
@@ -13,10 +15,11 @@ type Component struct {
 	Version         string                 `yaml:"version"`
 	Group           string                 `yaml:"group"` // Имя группы
 	Plugin          string                 `yaml:"plugin"`
+	Tags            []string               `yaml:"tags"`
 	ComponentConfig map[string]interface{} `yaml:"config"`
 }
 
-func (c *Component) CheckValideData(component Component, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) error {
+func (c *Component) CheckValideData(component Component, pc *plugin.PluginController, install bool, logMessage func(string, string, ...interface{})) error {
 
 	if component.Version == "" {
 		return fmt.Errorf("[Component > %s]>[Valid] 'version' is empty", component.Name)
@@ -30,22 +33,22 @@ func (c *Component) CheckValideData(component Component, pc *plugin.PluginContro
 	}
 
 	logMessage("DEBUG", fmt.Sprintf("[Component > %s]>[Valid] Check executor...", component.Name))
-	executor, ok := pc.ExecutorPluginRegistry[component.Plugin]
-	if !ok {
-		logMessage("ERROR", fmt.Sprintf("[Component:'%s'] 'Component.Plugin' плагин для типа '%s' не найден", component.Name, component.Plugin))
-		err := pc.InstallPlugin(component.Plugin)
-		if err != nil {
-			return err
-		}
+	executor, err := validationExecutor(pc, "Component", component.Name, component.Plugin, install, logMessage)
+	if err != nil || executor == nil {
+		return err
 	}
 	info, _ := executor.GetInfo()
-	logMessage("DEBUG", fmt.Sprintf("[Component > %s]>[Valid] Get component for Plugin: %s, componentConfig: %s", component.Name, info, component.ComponentConfig))
-	if pluginComponent, err := executor.GetComponent(component.ComponentConfig); err == nil {
-		logMessage("DEBUG", fmt.Sprintf("[Component > %s]>[Valid] Validate component for Plugin: %s, pluginComponent: %s", component.Name, info.Name, pluginComponent))
+	logMessage("DEBUG", "[Component > %s]>[Valid] Get component for Plugin: %s, componentConfig: %v", component.Name, info, RedactSecrets(component.ComponentConfig))
+	config, err := ResolveSecrets(component.ComponentConfig)
+	if err != nil {
+		return fmt.Errorf("[Component > %s]>[Valid] 'config' %v", component.Name, err)
+	}
+	if pluginComponent, err := executor.GetComponent(config); err == nil {
+		logMessage("DEBUG", fmt.Sprintf("[Component > %s]>[Valid] Validate component for Plugin: %s", component.Name, info.Name))
 		componentErr := executor.ValidateYAMLComponent(pluginComponent)
 		logMessage("DEBUG", fmt.Sprintf("[Component > %s]>[Valid] component %s validate with Plugin: %s", component.Name, info.Name, component.Name))
 		if componentErr != nil {
-			return err
+			return fmt.Errorf("[Component > %s]>[Valid] %v", component.Name, componentErr)
 		}
 	} else {
 		return err
@@ -53,13 +56,99 @@ func (c *Component) CheckValideData(component Component, pc *plugin.PluginContro
 	return nil
 }
 
+// Common общие настройки стенда, которые наследуют его компоненты
 type Common struct {
+	Plugin  string                 `yaml:"plugin"`
+	Version string                 `yaml:"version"`
+	Tags    []string               `yaml:"tags"`
+	Config  map[string]interface{} `yaml:"config"`
 }
 
 func (c *Common) CheckValideData(common Common, logMessage func(string, string, ...interface{})) error {
+	for _, tag := range common.Tags {
+		if tag == "" {
+			return fmt.Errorf("[Common]>[Valid] empty tag")
+		}
+	}
 	return nil
 }
 
+// merge возвращает настройки c, дополненные значениями по умолчанию из defaults
+func (c *Common) merge(defaults Common) Common {
+	merged := Common{
+		Plugin:  c.Plugin,
+		Version: c.Version,
+		Tags:    mergeTags(defaults.Tags, c.Tags),
+		Config:  mergeConfig(defaults.Config, c.Config),
+	}
+	if merged.Plugin == "" {
+		merged.Plugin = defaults.Plugin
+	}
+	if merged.Version == "" {
+		merged.Version = defaults.Version
+	}
+	return merged
+}
+
+// apply применяет общие настройки к компоненту. Значения компонента имеют приоритет.
+func (c *Common) apply(component Component) Component {
+	if component.Plugin == "" {
+		component.Plugin = c.Plugin
+	}
+	if component.Version == "" {
+		component.Version = c.Version
+	}
+	component.Tags = mergeTags(c.Tags, component.Tags)
+	if c.Config != nil {
+		component.ComponentConfig = mergeConfig(c.Config, component.ComponentConfig)
+	}
+	return component
+}
+
+// mergeConfig рекурсивно объединяет конфигурации: значения override имеют приоритет
+func mergeConfig(defaults map[string]interface{}, override map[string]interface{}) map[string]interface{} {
+	if defaults == nil && override == nil {
+		return nil
+	}
+	merged := make(map[string]interface{}, len(defaults)+len(override))
+	for key, value := range defaults {
+		merged[key] = value
+	}
+	for key, value := range override {
+		defaultMap, defaultOk := merged[key].(map[string]interface{})
+		overrideMap, overrideOk := value.(map[string]interface{})
+		if defaultOk && overrideOk {
+			merged[key] = mergeConfig(defaultMap, overrideMap)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// mergeTags объединяет списки тегов без повторов
+func mergeTags(lists ...[]string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, list := range lists {
+		for _, tag := range list {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// standInclude описывает подключаемый через 'include' файл
+type standInclude struct {
+	Common    Common      `yaml:"common"`     // Общие настройки, дополняющие 'common' стенда
+	Include   []string    `yaml:"include"`    // Вложенные подключения
+	Component []Component `yaml:"components"` // Компоненты, добавляемые в стенд
+	Stand     []Stand     `yaml:"stand"`      // Стенды, добавляемые в файл стендов
+}
+
 type Stand struct {
 	Name        string      `yaml:"name"`   // Имя стенда
 	Description string      `yaml:"desc"`   // Краткое описание
@@ -69,7 +158,7 @@ type Stand struct {
 	Component   []Component `yaml:"components"`
 }
 
-func (s *Stand) CascadeValidation(stand Stand, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) error {
+func (s *Stand) CascadeValidation(stand Stand, pc *plugin.PluginController, install bool, logMessage func(string, string, ...interface{})) error {
 
 	validErr := s.ValidateS(stand)
 	if validErr != nil {
@@ -91,7 +180,7 @@ func (s *Stand) CascadeValidation(stand Stand, pc *plugin.PluginController, logM
 		nameSet[component.Name] = true
 
 		// Проверяем остальные данные компонента
-		componentErr := component.CheckValideData(component, pc, logMessage)
+		componentErr := component.CheckValideData(component, pc, install, logMessage)
 		if componentErr != nil {
 			return componentErr
 		}
@@ -106,6 +195,75 @@ func (s *Stand) CascadeValidation(stand Stand, pc *plugin.PluginController, logM
 	return nil
 }
 
+// resolveIncludes раскрывает 'include' стенда относительно baseDir.
+// Общие настройки и компоненты из подключаемых файлов объединяются со стендом
+// (собственные значения стенда имеют приоритет). Возвращает стенды, объявленные
+// в подключаемых файлах. stack содержит цепочку подключений для поиска циклов.
+func (s *Stand) resolveIncludes(baseDir string, stack []string, logMessage func(string, string, ...interface{})) ([]Stand, error) {
+
+	var included []Stand
+	for _, include := range s.Include {
+		includePath := include
+		if !filepath.IsAbs(includePath) {
+			includePath = filepath.Join(baseDir, includePath)
+		}
+		absPath, err := filepath.Abs(includePath)
+		if err != nil {
+			return nil, fmt.Errorf("[Stand > %s]>[Include] '%s': %v", s.Name, include, err)
+		}
+		for _, parent := range stack {
+			if parent == absPath {
+				return nil, fmt.Errorf("[Stand > %s]>[Include] include cycle detected: '%s'", s.Name, includePath)
+			}
+		}
+
+		logMessage("DEBUG", fmt.Sprintf("[Stand > %s]>[Include] Load '%s'", s.Name, includePath))
+		file := &standInclude{}
+		if err := unmarshalYamlFile(includePath, file); err != nil {
+			return nil, fmt.Errorf("[Stand > %s]>[Include] %v", s.Name, err)
+		}
+
+		includeDir := filepath.Dir(includePath)
+		nextStack := append(append([]string{}, stack...), absPath)
+
+		// Содержимое файла раскрывается как фрагмент стенда со своими подключениями
+		fragment := Stand{Name: s.Name, Common: file.Common, Include: file.Include, Component: file.Component}
+		fragmentStands, err := fragment.resolveIncludes(includeDir, nextStack, logMessage)
+		if err != nil {
+			return nil, err
+		}
+		included = append(included, fragmentStands...)
+
+		for _, stand := range file.Stand {
+			standStands, err := stand.resolveIncludes(includeDir, nextStack, logMessage)
+			if err != nil {
+				return nil, err
+			}
+			included = append(included, stand)
+			included = append(included, standStands...)
+		}
+
+		s.Common = s.Common.merge(fragment.Common)
+		s.Component = mergeComponents(s.Component, fragment.Component)
+	}
+	return included, nil
+}
+
+// mergeComponents добавляет к компонентам стенда подключаемые компоненты с новыми именами
+func mergeComponents(components []Component, included []Component) []Component {
+	names := make(map[string]bool, len(components))
+	for _, component := range components {
+		names[component.Name] = true
+	}
+	for _, component := range included {
+		if !names[component.Name] {
+			names[component.Name] = true
+			components = append(components, component)
+		}
+	}
+	return components
+}
+
 func (s *Stand) ValidateS(stand Stand) error {
 
 	if stand.Name == "" {
@@ -122,15 +280,79 @@ type StandsFile struct {
 	MsVersion string  `yaml:"msVersion"`
 	Release   string  `yaml:"release"`
 	Stand     []Stand `yaml:"stand"`
+	Selected  string  `yaml:"-"` // Имя выбранного стенда (--stand); пусто - все стенды
+
+	StandReleases map[string]string `yaml:"-"` // Релизы стендов, обновлённых отдельно через --stand (из файла состояния)
+}
+
+// StandRelease возвращает релиз стенда: стенд, обновлённый отдельно, может опережать релиз файла
+func (sf *StandsFile) StandRelease(standName string) string {
+	if release, ok := sf.StandReleases[standName]; ok {
+		return release
+	}
+	return sf.Release
+}
+
+// CurrentRelease возвращает релиз выбранных стендов. Стенды на разных релизах
+// нужно обновлять по одному через --stand.
+func (sf *StandsFile) CurrentRelease() (string, error) {
+	stands := sf.stands()
+	if len(stands) == 0 {
+		return sf.Release, nil
+	}
+	release := sf.StandRelease(stands[0].Name)
+	for _, stand := range stands[1:] {
+		if other := sf.StandRelease(stand.Name); other != release {
+			return "", fmt.Errorf("stand '%s' is on release '%s', stand '%s' is on '%s': select one with --stand", stands[0].Name, release, stand.Name, other)
+		}
+	}
+	return release, nil
+}
+
+// SetRelease переводит выбранные стенды на релиз release
+func (sf *StandsFile) SetRelease(release string) {
+	if sf.Selected == "" {
+		sf.Release = release
+		sf.StandReleases = nil
+		return
+	}
+	if sf.StandReleases == nil {
+		sf.StandReleases = make(map[string]string)
+	}
+	sf.StandReleases[sf.Selected] = release
+}
+
+// SelectStand ограничивает поиск компонентов стендом с именем standName
+func (sf *StandsFile) SelectStand(standName string) error {
+	for _, stand := range sf.Stand {
+		if stand.Name == standName {
+			sf.Selected = standName
+			return nil
+		}
+	}
+	return fmt.Errorf("[StandsFile] stand '%s' not found", standName)
+}
+
+// stands возвращает стенды, в которых выполняется поиск компонентов
+func (sf *StandsFile) stands() []Stand {
+	if sf.Selected == "" {
+		return sf.Stand
+	}
+	for _, stand := range sf.Stand {
+		if stand.Name == sf.Selected {
+			return []Stand{stand}
+		}
+	}
+	return nil
 }
 
 func (sf *StandsFile) FindComponent(data map[string]interface{}, logMessage func(string, string, ...interface{})) (map[string]interface{}, error) {
 	logMessage("DEBUG", "[StandsFile] Find Component...")
 
-	// Проверяем наличие ключа "name" в данных
-	searchKey, ok := data["name"].(string)
-	if !ok || searchKey == "" {
-		return nil, fmt.Errorf("invalid input: 'name' field is required and must be a string")
+	// Проверяем наличие ключа "name" или "group" в данных
+	searchKey, _ := data["name"].(string)
+	if groupKey, _ := data["group"].(string); searchKey == "" && groupKey == "" {
+		return nil, fmt.Errorf("invalid input: 'name' or 'group' field is required and must be a string")
 	}
 
 	// Преобразователь в map[string]interface{}
@@ -154,8 +376,8 @@ func (sf *StandsFile) FindComponent(data map[string]interface{}, logMessage func
 	}
 
 	// Поиск стенда по имени
-	for _, stand := range sf.Stand {
-		if stand.Name == searchKey {
+	for _, stand := range sf.stands() {
+		if searchKey != "" && stand.Name == searchKey {
 			return convertToMap(sf.Stand)
 		}
 
@@ -169,13 +391,13 @@ func (sf *StandsFile) FindComponent(data map[string]interface{}, logMessage func
 		// Поиск по компонентам внутри стенда
 		for _, component := range stand.Component {
 			// Сравнение имени компонента
-			if component.Name == searchKey {
-				logMessage("DEBUG", "[StandsFile] Return Component: %s", component.ComponentConfig)
+			if searchKey != "" && component.Name == searchKey {
+				logMessage("DEBUG", "[StandsFile] Return Component: %v", RedactSecrets(component.ComponentConfig))
 				return component.ComponentConfig, nil
 			}
 
 			// Сравнение группы компонента, если указано
-			if groupKey, ok := data["group"].(string); ok && component.Group == groupKey {
+			if groupKey, ok := data["group"].(string); ok && groupKey != "" && component.Group == groupKey {
 				return component.ComponentConfig, nil
 			}
 		}
@@ -185,15 +407,128 @@ func (sf *StandsFile) FindComponent(data map[string]interface{}, logMessage func
 	return nil, fmt.Errorf("no component, group, or stand found with name: '%s'", searchKey)
 }
 
-func (sf *StandsFile) CascadeValidation(standsFile StandsFile, pc *plugin.PluginController, logMessage func(string, string, ...interface{})) error {
+// ResolveIncludes раскрывает 'include' всех стендов относительно каталога файла стендов
+// и применяет 'common' к компонентам.
+func (sf *StandsFile) ResolveIncludes(standsFilePath string, logMessage func(string, string, ...interface{})) error {
+
+	absPath, err := filepath.Abs(standsFilePath)
+	if err != nil {
+		return fmt.Errorf("[StandsFile]>[Include] '%s': %v", standsFilePath, err)
+	}
+	baseDir := filepath.Dir(standsFilePath)
+
+	var stands []Stand
+	for _, stand := range sf.Stand {
+		included, err := stand.resolveIncludes(baseDir, []string{absPath}, logMessage)
+		if err != nil {
+			return err
+		}
+		stands = append(stands, stand)
+		stands = append(stands, included...)
+	}
+
+	nameSet := make(map[string]bool)
+	for i := range stands {
+		if nameSet[stands[i].Name] {
+			return fmt.Errorf("[StandsFile]>[Include] duplicate stand name: '%s'", stands[i].Name)
+		}
+		nameSet[stands[i].Name] = true
+
+		for j := range stands[i].Component {
+			stands[i].Component[j] = stands[i].Common.apply(stands[i].Component[j])
+		}
+	}
+
+	sf.Stand = stands
+	return nil
+}
+
+// MatchComponents возвращает компоненты, подходящие под селектор 'name' или 'group'.
+// Поиск ведётся в выбранном стенде, а если стенд не выбран - во всех стендах; совпадения
+// в нескольких стендах неоднозначны, и тогда компоненты не возвращаются.
+// Селектор 'group' возвращает все компоненты группы.
+func (sf *StandsFile) MatchComponents(data map[string]interface{}) []Component {
+	_, components, _ := sf.matchComponents(data)
+	return components
+}
+
+// matchComponents как MatchComponents, но возвращает и стенд, в котором найдены компоненты.
+// Если стенд не выбран, а совпадения есть в нескольких стендах, возвращает ошибку.
+func (sf *StandsFile) matchComponents(data map[string]interface{}) (*Stand, []Component, error) {
+
+	name, _ := data["name"].(string)
+	group, _ := data["group"].(string)
+
+	var (
+		matched    *Stand
+		components []Component
+		standNames []string
+	)
+	stands := sf.stands()
+	for i := range stands {
+		var standComponents []Component
+		for _, component := range stands[i].Component {
+			if (name != "" && component.Name == name) || (group != "" && component.Group == group) {
+				standComponents = append(standComponents, component)
+			}
+		}
+		if len(standComponents) != 0 {
+			matched, components = &stands[i], standComponents
+			standNames = append(standNames, stands[i].Name)
+		}
+	}
+	if len(standNames) > 1 {
+		return nil, nil, fmt.Errorf("components for %v found in stands '%s': select one with --stand", data, strings.Join(standNames, "', '"))
+	}
+	return matched, components, nil
+}
+
+// FindComponents возвращает компоненты по селектору шага или ошибку, если совпадений нет
+func (sf *StandsFile) FindComponents(data map[string]interface{}, logMessage func(string, string, ...interface{})) ([]Component, error) {
+	_, components, err := sf.findComponents(data, logMessage)
+	return components, err
+}
+
+func (sf *StandsFile) findComponents(data map[string]interface{}, logMessage func(string, string, ...interface{})) (*Stand, []Component, error) {
+	logMessage("DEBUG", "[StandsFile] Find Components...")
+
+	name, _ := data["name"].(string)
+	group, _ := data["group"].(string)
+	if name == "" && group == "" {
+		return nil, nil, fmt.Errorf("invalid input: 'name' or 'group' field is required and must be a string")
+	}
+
+	stand, components, err := sf.matchComponents(data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(components) == 0 {
+		if sf.Selected != "" {
+			return nil, nil, fmt.Errorf("no component found for %v in stand '%s'", data, sf.Selected)
+		}
+		return nil, nil, fmt.Errorf("no component found for %v", data)
+	}
+	return stand, components, nil
+}
+
+// selectedStand возвращает выбранный стенд, а если стенд не выбран и он единственный - его
+func (sf *StandsFile) selectedStand() *Stand {
+	stands := sf.stands()
+	if len(stands) == 1 {
+		return &stands[0]
+	}
+	return nil
+}
+
+func (sf *StandsFile) CascadeValidation(standsFile StandsFile, pc *plugin.PluginController, install bool, logMessage func(string, string, ...interface{})) error {
 
 	validErr := sf.ValidateSF(standsFile)
 	if validErr != nil {
 		return validErr
 	}
 
-	for _, stand := range standsFile.Stand {
-		standErr := stand.CascadeValidation(stand, pc, logMessage)
+	for _, stand := range standsFile.stands() {
+		standErr := stand.CascadeValidation(stand, pc, install, logMessage)
 		if standErr != nil {
 			return standErr
 		}
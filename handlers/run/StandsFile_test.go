@@ -0,0 +1,59 @@
+package run
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindComponents(t *testing.T) {
+	sf := &StandsFile{Stand: []Stand{
+		{Name: "prod", Component: []Component{{Name: "db", Group: "g"}, {Name: "app", Group: "g"}}},
+		{Name: "stage", Component: []Component{{Name: "app", Group: "g"}, {Name: "cache"}}},
+	}}
+	log := func(string, string, ...interface{}) {}
+
+	tests := []struct {
+		name      string
+		selected  string
+		selector  map[string]interface{}
+		wantStand string
+		want      []string
+		wantErr   string
+	}{
+		{name: "unique name", selector: map[string]interface{}{"name": "cache"}, wantStand: "stage", want: []string{"cache"}},
+		{name: "name in one stand", selector: map[string]interface{}{"name": "db"}, wantStand: "prod", want: []string{"db"}},
+
+		// Совпадения в нескольких стендах без --stand - ошибка, а не первый попавшийся стенд
+		{name: "shared name", selector: map[string]interface{}{"name": "app"}, wantErr: "found in stands 'prod', 'stage': select one with --stand"},
+		{name: "group in several stands", selector: map[string]interface{}{"group": "g"}, wantErr: "select one with --stand"},
+
+		{name: "selected stand", selected: "stage", selector: map[string]interface{}{"name": "app"}, wantStand: "stage", want: []string{"app"}},
+		{name: "selected group", selected: "prod", selector: map[string]interface{}{"group": "g"}, wantStand: "prod", want: []string{"db", "app"}},
+		{name: "not in selected stand", selected: "prod", selector: map[string]interface{}{"name": "cache"}, wantErr: "in stand 'prod'"},
+		{name: "not found", selector: map[string]interface{}{"name": "nosuch"}, wantErr: "no component found"},
+		{name: "empty selector", selector: map[string]interface{}{}, wantErr: "'name' or 'group' field is required"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf.Selected = tt.selected
+			stand, components, err := sf.findComponents(tt.selector, log)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("findComponents(%v) error = %v, want %q", tt.selector, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("findComponents(%v) error: %v", tt.selector, err)
+			}
+			var names []string
+			for _, component := range components {
+				names = append(names, component.Name)
+			}
+			if stand == nil || stand.Name != tt.wantStand || strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("findComponents(%v) = %v, %v, want %s, %v", tt.selector, stand, names, tt.wantStand, tt.want)
+			}
+		})
+	}
+}
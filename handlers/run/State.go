@@ -0,0 +1,229 @@
+package run
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	DEFAULT_STATE_SUFFIX = ".state.yml"
+)
+
+// StandsState фактическое состояние стендов: текущий релиз и версии компонентов.
+// Стенд, обновлённый отдельно (--stand), хранит свой релиз в StandReleases.
+// Файл стендов описывает состав стендов и не переписывается, а версии, изменённые
+// миграциями ('to_version'), сохраняются в отдельный файл рядом с ним.
+type StandsState struct {
+	Path          string                       `yaml:"-"`
+	Release       string                       `yaml:"release"`                  // Релиз стендов без отдельного релиза
+	StandReleases map[string]string            `yaml:"stand_releases,omitempty"` // Стенд => релиз, если стенд обновлялся через --stand
+	Stands        map[string]map[string]string `yaml:"stands"`                   // Стенд => компонент => версия
+	Patches       []AppliedPatch               `yaml:"patches,omitempty"`        // Применённые патчи
+	changes       map[string]versionChange     // Изменения версий в текущем запуске по шагам
+	mu            sync.Mutex
+}
+
+// AppliedPatch запись о применённом патче
+type AppliedPatch struct {
+	Name    string    `yaml:"name"`
+	Stand   string    `yaml:"stand,omitempty"` // Стенд, к которому применён патч; пусто - все стенды
+	File    string    `yaml:"file"`
+	Release string    `yaml:"release"` // Релиз стендов, на который применён патч
+	Time    time.Time `yaml:"time"`
+}
+
+// versionChange изменение версии компонента шагом миграции
+type versionChange struct {
+	stand     string
+	component string
+	previous  string
+}
+
+// StatePath возвращает путь файла состояния для файла стендов: 'stands.yml' => 'stands.state.yml'
+func StatePath(standsFile string) string {
+	return strings.TrimSuffix(standsFile, filepath.Ext(standsFile)) + DEFAULT_STATE_SUFFIX
+}
+
+// LoadState читает файл состояния. Отсутствующий файл - пустое состояние.
+func LoadState(statePath string) (*StandsState, error) {
+
+	state := &StandsState{Path: statePath}
+	if _, err := os.Stat(statePath); errors.Is(err, os.ErrNotExist) {
+		state.Stands = make(map[string]map[string]string)
+		return state, nil
+	}
+	if err := unmarshalYamlFile(statePath, state); err != nil {
+		return nil, fmt.Errorf("[State]>[Load] %v", err)
+	}
+	if state.Stands == nil {
+		state.Stands = make(map[string]map[string]string)
+	}
+	return state, nil
+}
+
+// Apply переносит сохранённые релиз и версии компонентов в файл стендов
+func (s *StandsState) Apply(sf *StandsFile) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Release != "" {
+		sf.Release = s.Release
+	}
+	sf.StandReleases = make(map[string]string, len(s.StandReleases))
+	for stand, release := range s.StandReleases {
+		sf.StandReleases[stand] = release
+	}
+	for i := range sf.Stand {
+		for j := range sf.Stand[i].Component {
+			if version, ok := s.Stands[sf.Stand[i].Name][sf.Stand[i].Component[j].Name]; ok {
+				sf.Stand[i].Component[j].Version = version
+			}
+		}
+	}
+}
+
+// Version возвращает текущую версию компонента с учётом изменений во время выполнения
+func (s *StandsState) Version(target componentTarget) string {
+	if s == nil {
+		return target.Source.Version
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if version, ok := s.Stands[targetStand(target)][target.Name]; ok {
+		return version
+	}
+	return target.Source.Version
+}
+
+// SetVersion запоминает новую версию компонента, установленную шагом stepName,
+// и сохраняет состояние на диск
+func (s *StandsState) SetVersion(stepName string, target componentTarget, version string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	standName := targetStand(target)
+	previous, ok := s.Stands[standName][target.Name]
+	if !ok {
+		previous = target.Source.Version
+	}
+	if s.changes == nil {
+		s.changes = make(map[string]versionChange)
+	}
+	s.changes[stepName+"@"+target.Name] = versionChange{stand: standName, component: target.Name, previous: previous}
+
+	if s.Stands[standName] == nil {
+		s.Stands[standName] = make(map[string]string)
+	}
+	s.Stands[standName][target.Name] = version
+	return s.save()
+}
+
+// Restore возвращает прежнюю версию компонента при откате шага '<шаг>@<компонент>'
+func (s *StandsState) Restore(actionName string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change, ok := s.changes[actionName]
+	if !ok {
+		return nil
+	}
+	delete(s.changes, actionName)
+	s.Stands[change.stand][change.component] = change.previous
+	return s.save()
+}
+
+// SetRelease сохраняет релиз, до которого обновлён стенд stand; пусто - все стенды
+func (s *StandsState) SetRelease(release string, stand string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stand == "" {
+		s.Release = release
+		s.StandReleases = nil
+		return s.save()
+	}
+	if s.StandReleases == nil {
+		s.StandReleases = make(map[string]string)
+	}
+	s.StandReleases[stand] = release
+	return s.save()
+}
+
+// Patch возвращает запись о применении патча name к стенду stand
+func (s *StandsState) Patch(name string, stand string) (AppliedPatch, bool) {
+	if s == nil {
+		return AppliedPatch{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, patch := range s.Patches {
+		if patch.Name == name && (patch.Stand == stand || patch.Stand == "") {
+			return patch, true
+		}
+	}
+	return AppliedPatch{}, false
+}
+
+// AddPatch записывает применённый к стенду патч; повторное применение обновляет запись
+func (s *StandsState) AddPatch(patch AppliedPatch) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.Patches {
+		if s.Patches[i].Name == patch.Name && s.Patches[i].Stand == patch.Stand {
+			s.Patches[i] = patch
+			return s.save()
+		}
+	}
+	s.Patches = append(s.Patches, patch)
+	return s.save()
+}
+
+// save атомарно записывает состояние на диск. Вызывается под блокировкой.
+func (s *StandsState) save() error {
+
+	data, err := yaml.Marshal(s)
+	if err != nil {
+		return fmt.Errorf("[State]>[Save] %v", err)
+	}
+
+	tmpPath := s.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("[State]>[Save] failed to write '%s': %v", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.Path); err != nil {
+		return fmt.Errorf("[State]>[Save] failed to rename '%s': %v", tmpPath, err)
+	}
+	return nil
+}
+
+func targetStand(target componentTarget) string {
+	if target.Stand == nil {
+		return ""
+	}
+	return target.Stand.Name
+}
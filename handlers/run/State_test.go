@@ -0,0 +1,68 @@
+package run
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateReleasePerStand(t *testing.T) {
+	ms := newTestMigrationSet(t, "stages: []\n", &fakeExecutor{})
+	if err := ms.StandsFile.SelectStand("A"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.UpdateRelease(ms, testLog(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Обновлён только выбранный стенд
+	state, err := LoadState(ms.State.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Release != "" || state.StandReleases["A"] != "v0.0.2" {
+		t.Fatalf("state release = %q, stand releases = %v, want only stand A on v0.0.2", state.Release, state.StandReleases)
+	}
+
+	// Стенд B остаётся на прежнем релизе, и миграция для него проходит проверку
+	other := newTestMigrationSet(t, "stages: []\n", &fakeExecutor{})
+	other.State = state
+	state.Apply(other.StandsFile)
+	if got := other.StandsFile.StandRelease("B"); got != "v0.0.1" {
+		t.Fatalf("StandRelease(B) = %q, want v0.0.1", got)
+	}
+	if err := other.StandsFile.SelectStand("B"); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ValidateRelease(*other, testLog(t)); err != nil {
+		t.Fatalf("ValidateRelease for stand B: %v", err)
+	}
+
+	// Стенд A уже на 'to_release'
+	other.StandsFile.Selected = "A"
+	if err := other.ValidateRelease(*other, testLog(t)); err == nil || !strings.Contains(err.Error(), "stands are on release 'v0.0.2'") {
+		t.Fatalf("ValidateRelease for stand A error = %v, want release mismatch", err)
+	}
+
+	// Без --stand стенды на разных релизах не обновляются
+	other.StandsFile.Selected = ""
+	if err := other.ValidateRelease(*other, testLog(t)); err == nil || !strings.Contains(err.Error(), "select one with --stand") {
+		t.Fatalf("ValidateRelease for all stands error = %v, want 'select one with --stand'", err)
+	}
+}
+
+func TestSetReleaseAllStands(t *testing.T) {
+	state := &StandsState{Path: filepath.Join(t.TempDir(), "stands.state.yml"), StandReleases: map[string]string{"A": "v0.0.2"}}
+	if err := state.SetRelease("v0.0.3", ""); err != nil {
+		t.Fatal(err)
+	}
+	if state.Release != "v0.0.3" || len(state.StandReleases) != 0 {
+		t.Fatalf("release = %q, stand releases = %v, want v0.0.3 for all stands", state.Release, state.StandReleases)
+	}
+
+	sf := &StandsFile{Release: "v0.0.1", Stand: []Stand{{Name: "A"}, {Name: "B"}}}
+	state.Apply(sf)
+	if release, err := sf.CurrentRelease(); err != nil || release != "v0.0.3" {
+		t.Fatalf("CurrentRelease() = %q, %v, want v0.0.3", release, err)
+	}
+}
@@ -0,0 +1,559 @@
+package run
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Шаблоны в 'action', 'component' и 'rollback' шагов: '{{ путь | фильтр "аргумент" }}'.
+//
+//	{{ steps.<этап>.<шаг>.<ключ> }}   результат ранее выполненного шага
+//	{{ stand.name }}                  стенд компонента
+//	{{ component.version }}           компонент, на котором выполняется шаг
+//	{{ ms.to_release }}               параметры миграции
+//
+// Фильтры: trim, lower, upper, default "значение", regex 'выражение'.
+// Аргумент в одинарных кавычках берётся как есть, удобно для регулярных выражений.
+// Строка, целиком состоящая из одного выражения, сохраняет тип значения.
+var templateExpr = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// templateLookup разрешает оставшуюся часть пути самостоятельно
+type templateLookup interface {
+	lookup(path []string) (interface{}, error)
+}
+
+// HasTemplate сообщает, содержит ли значение шаблонные выражения
+func HasTemplate(value interface{}) bool {
+	switch v := value.(type) {
+	case string:
+		return templateExpr.MatchString(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			if HasTemplate(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if HasTemplate(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateTemplates проверяет синтаксис всех выражений без их вычисления
+func ValidateTemplates(value interface{}) error {
+	switch v := value.(type) {
+	case string:
+		for _, match := range templateExpr.FindAllStringSubmatch(v, -1) {
+			if _, _, err := parseExpr(match[1]); err != nil {
+				return fmt.Errorf("template '%s': %v", match[0], err)
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			if err := ValidateTemplates(item); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := ValidateTemplates(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// RenderMap возвращает копию m с вычисленными шаблонами
+func RenderMap(m map[string]interface{}, scope map[string]interface{}) (map[string]interface{}, error) {
+	if m == nil {
+		return nil, nil
+	}
+	rendered, err := renderValue(m, scope)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.(map[string]interface{}), nil
+}
+
+func renderValue(value interface{}, scope map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return renderString(v, scope)
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			r, err := renderValue(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderValue(item, scope)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+func renderString(s string, scope map[string]interface{}) (interface{}, error) {
+
+	matches := templateExpr.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+
+	// Единственное выражение на всю строку сохраняет тип значения
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(s) {
+		value, err := evalExpr(s[matches[0][2]:matches[0][3]], scope)
+		if err != nil {
+			return nil, fmt.Errorf("template '%s': %v", s, err)
+		}
+		return value, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, match := range matches {
+		b.WriteString(s[last:match[0]])
+		value, err := evalExpr(s[match[2]:match[3]], scope)
+		if err != nil {
+			return nil, fmt.Errorf("template '%s': %v", s[match[0]:match[1]], err)
+		}
+		b.WriteString(templateString(value))
+		last = match[1]
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+// templateString форматирует значение для подстановки внутрь строки
+func templateString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]interface{}, []interface{}:
+		data, err := json.Marshal(v)
+		if err == nil {
+			return string(data)
+		}
+	}
+	return fmt.Sprint(value)
+}
+
+type templateFilter struct {
+	name string
+	arg  string
+}
+
+// parseExpr разбирает 'путь | фильтр "аргумент" | ...'
+func parseExpr(expr string) ([]string, []templateFilter, error) {
+
+	parts := splitPipes(expr)
+	path := strings.TrimSpace(parts[0])
+	if path == "" {
+		return nil, nil, fmt.Errorf("empty expression")
+	}
+	keys := strings.Split(path, ".")
+	for _, key := range keys {
+		if key == "" {
+			return nil, nil, fmt.Errorf("invalid path '%s'", path)
+		}
+	}
+
+	var filters []templateFilter
+	for _, part := range parts[1:] {
+		part = strings.TrimSpace(part)
+		name, rawArg, hasArg := strings.Cut(part, " ")
+		filter := templateFilter{name: name}
+		if hasArg {
+			arg, err := unquoteArg(strings.TrimSpace(rawArg))
+			if err != nil {
+				return nil, nil, fmt.Errorf("filter '%s': %v", name, err)
+			}
+			filter.arg = arg
+		}
+		switch filter.name {
+		case "trim", "lower", "upper":
+			if hasArg {
+				return nil, nil, fmt.Errorf("filter '%s' takes no argument", name)
+			}
+		case "default":
+			if !hasArg {
+				return nil, nil, fmt.Errorf("filter 'default' requires an argument")
+			}
+		case "regex":
+			if !hasArg {
+				return nil, nil, fmt.Errorf("filter 'regex' requires an argument")
+			}
+			if _, err := regexp.Compile(filter.arg); err != nil {
+				return nil, nil, fmt.Errorf("filter 'regex': %v", err)
+			}
+		default:
+			return nil, nil, fmt.Errorf("unknown filter '%s'", name)
+		}
+		filters = append(filters, filter)
+	}
+	return keys, filters, nil
+}
+
+// unquoteArg снимает кавычки с аргумента фильтра: "..." с экранированием Go, '...' - как есть
+func unquoteArg(arg string) (string, error) {
+	if len(arg) >= 2 && arg[0] == '\'' && arg[len(arg)-1] == '\'' {
+		return arg[1 : len(arg)-1], nil
+	}
+	if len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"' {
+		if unquoted, err := strconv.Unquote(arg); err == nil {
+			return unquoted, nil
+		}
+		// Например, "\d+" в регулярном выражении: берём как есть
+		return arg[1 : len(arg)-1], nil
+	}
+	return "", fmt.Errorf("argument must be a quoted string")
+}
+
+// splitPipes делит выражение по '|' вне кавычек
+func splitPipes(expr string) []string {
+	var parts []string
+	var quote rune
+	escaped := false
+	last := 0
+	for i, r := range expr {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote == '"':
+			escaped = true
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case r == quote:
+			quote = 0
+		case r == '|' && quote == 0:
+			parts = append(parts, expr[last:i])
+			last = i + 1
+		}
+	}
+	return append(parts, expr[last:])
+}
+
+func evalExpr(expr string, scope map[string]interface{}) (interface{}, error) {
+
+	keys, filters, err := parseExpr(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	value, lookupErr := lookupPath(scope, keys)
+	for _, filter := range filters {
+		if filter.name == "default" {
+			if lookupErr != nil || value == nil || value == "" {
+				value, lookupErr = filter.arg, nil
+			}
+			continue
+		}
+		// Неопределённое значение пропускает фильтры до ближайшего default
+		if lookupErr != nil {
+			continue
+		}
+		if value, err = applyFilter(filter, value); err != nil {
+			return nil, err
+		}
+	}
+	if lookupErr != nil {
+		return nil, lookupErr
+	}
+	return value, nil
+}
+
+func applyFilter(filter templateFilter, value interface{}) (interface{}, error) {
+	s := templateString(value)
+	switch filter.name {
+	case "trim":
+		return strings.TrimSpace(s), nil
+	case "lower":
+		return strings.ToLower(s), nil
+	case "upper":
+		return strings.ToUpper(s), nil
+	case "regex":
+		// Первая группа, а без групп - всё совпадение
+		match := regexp.MustCompile(filter.arg).FindStringSubmatch(s)
+		if match == nil {
+			return nil, fmt.Errorf("regex '%s' does not match '%s'", filter.arg, s)
+		}
+		if len(match) > 1 {
+			return match[1], nil
+		}
+		return match[0], nil
+	}
+	return nil, fmt.Errorf("unknown filter '%s'", filter.name)
+}
+
+func lookupPath(root interface{}, keys []string) (interface{}, error) {
+	current := root
+	for i, key := range keys {
+		switch c := current.(type) {
+		case templateLookup:
+			return c.lookup(keys[i:])
+		case map[string]interface{}:
+			value, ok := c[key]
+			if !ok {
+				return nil, fmt.Errorf("'%s' is not defined", strings.Join(keys[:i+1], "."))
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(c) {
+				return nil, fmt.Errorf("'%s': invalid index '%s'", strings.Join(keys[:i], "."), key)
+			}
+			current = c[index]
+		default:
+			return nil, fmt.Errorf("'%s' has no field '%s'", strings.Join(keys[:i], "."), key)
+		}
+	}
+	if _, ok := current.(templateLookup); ok {
+		return nil, fmt.Errorf("'%s' is not a value", strings.Join(keys, "."))
+	}
+	return current, nil
+}
+
+// Outputs хранит результаты выполненных шагов: этап -> шаг -> значения
+type Outputs struct {
+	mu    sync.Mutex
+	steps map[string]map[string]map[string]interface{}
+}
+
+func NewOutputs() *Outputs {
+	return &Outputs{steps: make(map[string]map[string]map[string]interface{})}
+}
+
+// Set сохраняет результат шага; повторный шаг с тем же именем заменяет прежний
+func (o *Outputs) Set(stageName string, stepName string, values map[string]interface{}) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.steps[stageName] == nil {
+		o.steps[stageName] = make(map[string]map[string]interface{})
+	}
+	o.steps[stageName][stepName] = values
+}
+
+// lookup разрешает '<этап>.<шаг>.<ключ>'. Этап задаётся полным именем
+// ('MDM.Adapter') или однозначным окончанием ('Adapter').
+func (o *Outputs) lookup(path []string) (interface{}, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for n := len(path) - 1; n >= 1; n-- {
+		stageName, err := o.findStage(strings.Join(path[:n], "."))
+		if err != nil {
+			return nil, err
+		}
+		if stageName == "" {
+			continue
+		}
+		values, ok := o.steps[stageName][path[n]]
+		if !ok {
+			return nil, fmt.Errorf("step '%s' of stage '%s' has no outputs yet", path[n], stageName)
+		}
+		return lookupPath(values, path[n+1:])
+	}
+	return nil, fmt.Errorf("'steps.%s': no outputs of such stage yet", strings.Join(path, "."))
+}
+
+// findStage находит этап по полному имени или окончанию. Вызывается под блокировкой.
+func (o *Outputs) findStage(name string) (string, error) {
+	if _, ok := o.steps[name]; ok {
+		return name, nil
+	}
+	found := ""
+	for stageName := range o.steps {
+		if strings.HasSuffix(stageName, "."+name) {
+			if found != "" {
+				return "", fmt.Errorf("stage '%s' is ambiguous: '%s' and '%s'", name, found, stageName)
+			}
+			found = stageName
+		}
+	}
+	return found, nil
+}
+
+// SetStatus дополняет результат шага итогом 'status': ok, failed или skipped
+func (o *Outputs) SetStatus(stageName string, stepName string, status string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.steps[stageName] == nil {
+		o.steps[stageName] = make(map[string]map[string]interface{})
+	}
+	values := o.steps[stageName][stepName]
+	if values == nil {
+		values = make(map[string]interface{})
+		o.steps[stageName][stepName] = values
+	}
+	values["status"] = status
+}
+
+// Get возвращает результат шага
+func (o *Outputs) Get(stageName string, stepName string) (map[string]interface{}, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	values, ok := o.steps[stageName][stepName]
+	return values, ok
+}
+
+// templateScope возвращает значения, доступные шаблонам шага на компоненте target.
+// Для селектора 'component' target = nil: доступны только steps, ms и выбранный стенд.
+func (ms *MigrationSet) templateScope(target *componentTarget) map[string]interface{} {
+
+	outputs := ms.Outputs
+	if outputs == nil {
+		outputs = NewOutputs()
+	}
+	scope := map[string]interface{}{
+		"steps": outputs,
+		"ms": map[string]interface{}{
+			"version":      ms.MigrationSetVersion,
+			"file":         ms.MigrationFile,
+			"stands":       ms.YAMLStandFile,
+			"from_release": ms.FromRelease,
+			"to_release":   ms.ToRelease,
+		},
+	}
+
+	var stand *Stand
+	if target != nil {
+		stand = target.Stand
+	}
+	if stand == nil && ms.StandsFile != nil {
+		stand = ms.StandsFile.selectedStand()
+	}
+	if stand != nil {
+		scope["stand"] = map[string]interface{}{
+			"name":    stand.Name,
+			"desc":    stand.Description,
+			"group":   stand.Group,
+			"tags":    stringList(stand.Common.Tags),
+			"release": ms.StandsFile.StandRelease(stand.Name),
+		}
+	}
+
+	if target != nil {
+		config := target.Source.ComponentConfig
+		if config == nil {
+			config = map[string]interface{}{}
+		}
+		scope["component"] = map[string]interface{}{
+			"name":    target.Source.Name,
+			"version": ms.State.Version(*target),
+			"group":   target.Source.Group,
+			"plugin":  target.Source.Plugin,
+			"tags":    stringList(target.Source.Tags),
+			"config":  config,
+		}
+	}
+	return scope
+}
+
+func stringList(values []string) []interface{} {
+	list := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		list = append(list, value)
+	}
+	return list
+}
+
+// stepOutputs собирает выходные значения шага по компонентам
+type stepOutputs struct {
+	mu         sync.Mutex
+	components map[string]map[string]interface{}
+}
+
+func newStepOutputs() *stepOutputs {
+	return &stepOutputs{components: make(map[string]map[string]interface{})}
+}
+
+// add сохраняет значения исполнителя для компонента target, дополняя их именованными
+// 'outputs' шага. В шаблонах 'outputs' значения исполнителя доступны как 'output'.
+func (so *stepOutputs) add(ms *MigrationSet, kind string, stepName string, named map[string]interface{}, raw map[string]interface{}, target componentTarget) error {
+
+	values := make(map[string]interface{}, len(raw)+len(named))
+	for key, value := range raw {
+		values[key] = value
+	}
+
+	if len(named) != 0 {
+		scope := ms.templateScope(&target)
+		scope["output"] = values
+		rendered, err := RenderMap(named, scope)
+		if err != nil {
+			return fmt.Errorf("[%s:'%s'] 'outputs' %v", kind, stepName, err)
+		}
+		for key, value := range rendered {
+			values[key] = value
+		}
+	}
+
+	so.mu.Lock()
+	defer so.mu.Unlock()
+	so.components[target.Name] = values
+	return nil
+}
+
+// result возвращает значения шага: значения первого компонента доступны напрямую
+// ('steps.<этап>.<шаг>.stdout'), значения всех компонентов - через 'components.<имя>'
+func (so *stepOutputs) result(targets []componentTarget) map[string]interface{} {
+
+	so.mu.Lock()
+	defer so.mu.Unlock()
+
+	values := make(map[string]interface{})
+	components := make(map[string]interface{}, len(so.components))
+	for _, target := range targets {
+		componentValues, ok := so.components[target.Name]
+		if !ok {
+			continue
+		}
+		if len(components) == 0 {
+			for key, value := range componentValues {
+				values[key] = value
+			}
+		}
+		components[target.Name] = componentValues
+	}
+	values["components"] = components
+	return values
+}
+
+// validateStepTemplates проверяет синтаксис шаблонов в полях шага
+func validateStepTemplates(kind string, stepName string, fields map[string]map[string]interface{}) error {
+
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := ValidateTemplates(fields[name]); err != nil {
+			return fmt.Errorf("[%s:'%s'] '%s' %v", kind, stepName, name, err)
+		}
+	}
+	return nil
+}
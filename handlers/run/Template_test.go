@@ -0,0 +1,255 @@
+package run
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// templateTestScope значения, которые видят шаблоны шага на компоненте c1 стенда PROD
+func templateTestScope() map[string]interface{} {
+	outputs := NewOutputs()
+	outputs.Set("MDM.Adapter", "get_ver", map[string]interface{}{
+		"stdout": "  Version: 1.2.3\n",
+		"code":   0,
+		"meta":   map[string]interface{}{"items": []interface{}{"a", "b"}},
+	})
+	outputs.SetStatus("MDM.Adapter", "get_ver", REPORT_STATUS_OK)
+	outputs.Set("DB", "migrate", map[string]interface{}{"stdout": "done"})
+
+	return map[string]interface{}{
+		"steps": outputs,
+		"stand": map[string]interface{}{
+			"name": "PROD",
+			"tags": []interface{}{"critical", "eu"},
+		},
+		"component": map[string]interface{}{
+			"name":  "c1",
+			"port":  8080,
+			"empty": "",
+		},
+		"ms": map[string]interface{}{
+			"to_release": "v2.1.0",
+		},
+	}
+}
+
+func TestRenderString(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		want     interface{}
+	}{
+		{name: "plain", template: "echo ok", want: "echo ok"},
+		{name: "value", template: "{{ stand.name }}", want: "PROD"},
+		{name: "no spaces", template: "{{stand.name}}", want: "PROD"},
+
+		// Единственное выражение сохраняет тип, внутри строки значение форматируется
+		{name: "keeps type", template: "{{ component.port }}", want: 8080},
+		{name: "keeps list", template: "{{ stand.tags }}", want: []interface{}{"critical", "eu"}},
+		{name: "in string", template: "port={{ component.port }}", want: "port=8080"},
+		{name: "several", template: "{{ stand.name }}-{{ component.name }}", want: "PROD-c1"},
+		{name: "list as json", template: "tags={{ stand.tags }}", want: `tags=["critical","eu"]`},
+
+		{name: "trim", template: "{{ steps.Adapter.get_ver.stdout | trim }}", want: "Version: 1.2.3"},
+		{name: "lower", template: "{{ stand.name | lower }}", want: "prod"},
+		{name: "upper", template: "{{ component.name | upper }}", want: "C1"},
+		{name: "chain", template: "{{ stand.name | lower | upper }}", want: "PROD"},
+		{name: "filter makes string", template: "{{ component.port | trim }}", want: "8080"},
+
+		{name: "default missing", template: `{{ component.missing | default "x" }}`, want: "x"},
+		{name: "default empty", template: "{{ component.empty | default 'y' }}", want: "y"},
+		{name: "default not used", template: "{{ stand.name | default 'y' }}", want: "PROD"},
+		{name: "default missing step", template: "{{ steps.Other.x.stdout | default 'none' }}", want: "none"},
+		{name: "default after filter", template: "{{ component.missing | upper | default 'z' }}", want: "z"},
+		{name: "default with pipe", template: "{{ component.missing | default 'a|b' }}", want: "a|b"},
+		{name: "default escaped", template: `{{ component.missing | default "say \"hi\"" }}`, want: `say "hi"`},
+
+		// regex возвращает первую группу, а без групп - всё совпадение
+		{name: "regex group", template: `{{ steps.Adapter.get_ver.stdout | regex 'Version: (\d+\.\d+)' }}`, want: "1.2"},
+		{name: "regex match", template: `{{ steps.Adapter.get_ver.stdout | regex '\d+\.\d+\.\d+' }}`, want: "1.2.3"},
+		{name: "regex double quotes", template: `{{ steps.Adapter.get_ver.stdout | regex "(\d+)" }}`, want: "1"},
+		{name: "regex then default", template: "{{ ms.to_release | regex '^v(.*)' | default '0' }}", want: "2.1.0"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderString(tt.template, templateTestScope())
+			if err != nil {
+				t.Fatalf("renderString(%q) error: %v", tt.template, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("renderString(%q) = %#v, want %#v", tt.template, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderStringErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		wantErr  string
+	}{
+		{name: "undefined", template: "{{ component.missing }}", wantErr: "'component.missing' is not defined"},
+		{name: "undefined in string", template: "x={{ nosuch }}", wantErr: "'nosuch' is not defined"},
+		{name: "filter on undefined", template: "{{ component.missing | trim }}", wantErr: "is not defined"},
+		{name: "regex no match", template: "{{ stand.name | regex '^DEV' }}", wantErr: "does not match"},
+		{name: "field of string", template: "{{ stand.name.first }}", wantErr: "'stand.name' has no field 'first'"},
+		{name: "bad index", template: "{{ stand.tags.5 }}", wantErr: "invalid index '5'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := renderString(tt.template, templateTestScope())
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("renderString(%q) error = %v, want %q", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRenderMap(t *testing.T) {
+	action := map[string]interface{}{
+		"command": "deploy --version {{ ms.to_release }}",
+		"port":    "{{ component.port }}",
+		"args":    []interface{}{"{{ stand.name | lower }}", 1},
+		"env":     map[string]interface{}{"VERSION": "{{ steps.Adapter.get_ver.stdout | regex '(\\d+\\.\\d+\\.\\d+)' }}"},
+	}
+
+	got, err := RenderMap(action, templateTestScope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{
+		"command": "deploy --version v2.1.0",
+		"port":    8080,
+		"args":    []interface{}{"prod", 1},
+		"env":     map[string]interface{}{"VERSION": "1.2.3"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("RenderMap() = %#v, want %#v", got, want)
+	}
+
+	// Исходная карта не меняется
+	if action["port"] != "{{ component.port }}" {
+		t.Errorf("RenderMap() changed the source map: %v", action)
+	}
+
+	if rendered, err := RenderMap(nil, templateTestScope()); rendered != nil || err != nil {
+		t.Errorf("RenderMap(nil) = %v, %v, want nil, nil", rendered, err)
+	}
+}
+
+func TestValidateTemplates(t *testing.T) {
+	tests := []struct {
+		template string
+		wantErr  string
+	}{
+		{template: "plain text"},
+		{template: `{{ steps.Adapter.get_ver.stdout | trim | regex '(\d+)' | default "0" }}`},
+		{template: "{{ }}", wantErr: "empty expression"},
+		{template: "{{ a..b }}", wantErr: "invalid path 'a..b'"},
+		{template: "{{ a | nosuch }}", wantErr: "unknown filter 'nosuch'"},
+		{template: "{{ a | trim 'x' }}", wantErr: "filter 'trim' takes no argument"},
+		{template: "{{ a | default }}", wantErr: "filter 'default' requires an argument"},
+		{template: "{{ a | default x }}", wantErr: "argument must be a quoted string"},
+		{template: "{{ a | regex }}", wantErr: "filter 'regex' requires an argument"},
+		{template: "{{ a | regex '(' }}", wantErr: "filter 'regex'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			value := map[string]interface{}{"action": []interface{}{tt.template}}
+			err := ValidateTemplates(value)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("ValidateTemplates(%q) error: %v", tt.template, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("ValidateTemplates(%q) error = %v, want %q", tt.template, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHasTemplate(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  bool
+	}{
+		{value: "echo ok", want: false},
+		{value: "echo {{ stand.name }}", want: true},
+		{value: map[string]interface{}{"a": 1, "b": []interface{}{"x", "{{ ms.to_release }}"}}, want: true},
+		{value: []interface{}{1, "x"}, want: false},
+		{value: 42, want: false},
+	}
+
+	for _, tt := range tests {
+		if got := HasTemplate(tt.value); got != tt.want {
+			t.Errorf("HasTemplate(%v) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestOutputsLookup(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		want    interface{}
+		wantErr string
+	}{
+		{name: "full stage name", path: "steps.MDM.Adapter.get_ver.stdout", want: "  Version: 1.2.3\n"},
+		{name: "stage suffix", path: "steps.Adapter.get_ver.code", want: 0},
+		{name: "top level stage", path: "steps.DB.migrate.stdout", want: "done"},
+		{name: "status", path: "steps.Adapter.get_ver.status", want: REPORT_STATUS_OK},
+		{name: "nested value", path: "steps.Adapter.get_ver.meta.items.1", want: "b"},
+		{name: "step map", path: "steps.DB.migrate", want: map[string]interface{}{"stdout": "done"}},
+		{name: "unknown stage", path: "steps.Other.get_ver.stdout", wantErr: "no outputs of such stage yet"},
+		{name: "unknown step", path: "steps.Adapter.other.stdout", wantErr: "step 'other' of stage 'MDM.Adapter' has no outputs yet"},
+		{name: "unknown key", path: "steps.Adapter.get_ver.stderr", wantErr: "'stderr' is not defined"},
+		{name: "no step", path: "steps.Adapter", wantErr: "no outputs of such stage yet"},
+		{name: "outputs root", path: "steps", wantErr: "'steps' is not a value"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupPath(templateTestScope(), strings.Split(tt.path, "."))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("lookup %q error = %v, want %q", tt.path, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookup %q error: %v", tt.path, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("lookup %q = %#v, want %#v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutputsAmbiguousStage(t *testing.T) {
+	outputs := NewOutputs()
+	outputs.Set("MDM.Adapter", "get_ver", map[string]interface{}{"stdout": "1"})
+	outputs.Set("CRM.Adapter", "get_ver", map[string]interface{}{"stdout": "2"})
+	scope := map[string]interface{}{"steps": outputs}
+
+	if _, err := lookupPath(scope, strings.Split("steps.Adapter.get_ver.stdout", ".")); err == nil || !strings.Contains(err.Error(), "is ambiguous") {
+		t.Fatalf("lookup of ambiguous stage error = %v, want 'is ambiguous'", err)
+	}
+	got, err := lookupPath(scope, strings.Split("steps.CRM.Adapter.get_ver.stdout", "."))
+	if err != nil || got != "2" {
+		t.Fatalf("lookup by full stage name = %v, %v, want 2", got, err)
+	}
+
+	// Повторный шаг с тем же именем заменяет прежний результат
+	outputs.Set("CRM.Adapter", "get_ver", map[string]interface{}{"stdout": "3"})
+	if values, ok := outputs.Get("CRM.Adapter", "get_ver"); !ok || values["stdout"] != "3" {
+		t.Fatalf("Get() = %v, %v, want stdout 3", values, ok)
+	}
+}
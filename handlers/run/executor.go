@@ -2,22 +2,50 @@ package run
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	//"plugin"
 
 	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/semver"
 	v1 "github.com/laplasd/roller-epi/v1"
 )
 
+const (
+	GROUP_FAILURE_STOP     = "stop"
+	GROUP_FAILURE_CONTINUE = "continue"
+)
+
+var (
+	DEFAULT_GROUP_PARALLEL       int    = 1
+	DEFAULT_GROUP_FAILURE_POLICY string = GROUP_FAILURE_STOP
+)
+
+var (
+	DEFAULT_CHECK_RETRIES  int           = 0
+	DEFAULT_CHECK_INTERVAL time.Duration = 5 * time.Second
+	DEFAULT_CHECK_BACKOFF  float64       = 1
+)
+
 type Check struct {
 	Name       string                 `yaml:"name"`
 	PluginType string                 `yaml:"plugin"`
 	Actions    map[string]interface{} `yaml:"action"`
 	Component  map[string]interface{} `yaml:"component"`
+	When       string                 `yaml:"when"`       // Условие выполнения шага
+	Retries    *int                   `yaml:"retries"`    // Количество повторов после первой неуспешной попытки
+	Interval   *time.Duration         `yaml:"interval"`   // Пауза перед повтором
+	Backoff    float64                `yaml:"backoff"`    // Множитель паузы для каждого следующего повтора
+	Timeout    time.Duration          `yaml:"timeout"`    // Общий лимит времени на все попытки
+	Parallel   int                    `yaml:"parallel"`   // Число компонентов группы, обрабатываемых одновременно
+	OnFailure  string                 `yaml:"on_failure"` // Политика ошибок для группы: 'stop' или 'continue'
+	Outputs    map[string]interface{} `yaml:"outputs"`    // Именованные выходные значения: шаблоны от 'output'
 }
 
-func (c *Check) CascadeValidation(check Check, pc *plugin.PluginController, stands StandsFile, logMessage func(string, string, ...interface{})) (*v1.Check, *v1.Component, error) {
+func (c *Check) CascadeValidation(check Check, pc *plugin.PluginController, stands StandsFile, install bool, logMessage func(string, string, ...interface{})) (*v1.Check, []componentTarget, error) {
 
 	validErr := c.ValidateCH(check)
 	if validErr != nil {
@@ -25,26 +53,22 @@ func (c *Check) CascadeValidation(check Check, pc *plugin.PluginController, stan
 	}
 
 	var pluginCheck v1.Check
-	var pluginComponent v1.Component
 	var err error
 
 	ctx := context.TODO()
 
 	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Check executor for '%s'", check.Name, check.PluginType))
-	executor, ok := pc.ExecutorPluginRegistry[check.PluginType]
-	if !ok {
-		logMessage("ERROR", fmt.Sprintf("[Check:'%s'] 'Check.Plugin' плагин для типа '%s' не найден", check.Name, check.PluginType))
-		err := pc.InstallPlugin(check.PluginType)
-		if err != nil {
-			return nil, nil, nil
-		}
-	} else {
-		info, _ := executor.GetInfo()
-		logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Executor object for '%s': %s", check.Name, check.PluginType, info))
+	executor, err := validationExecutor(pc, "Check", check.Name, check.PluginType, install, logMessage)
+	if err != nil || executor == nil {
+		return nil, nil, err
 	}
+	info, _ := executor.GetInfo()
+	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Executor object for '%s': %s", check.Name, check.PluginType, info))
 
 	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] GetCheck object for %s", check.Name, check.PluginType))
-	if pluginCheck, err = executor.GetCheck(check.Actions); err == nil {
+	if HasTemplate(check.Actions) {
+		logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] 'action' contains templates, validation deferred to execution", check.Name))
+	} else if pluginCheck, err = executor.GetCheck(check.Actions); err == nil {
 
 		logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Validate Check object for %s", check.Name, check.PluginType))
 		if err = executor.ValidateYAMLCheck(ctx, pluginCheck); err != nil {
@@ -54,31 +78,22 @@ func (c *Check) CascadeValidation(check Check, pc *plugin.PluginController, stan
 
 			logMessage("INFO", fmt.Sprintf("[Check:'%s'] Validate Check for '%s' succes!", check.Name, check.PluginType))
 		}
+	} else {
+		return nil, nil, err
 	}
 
 	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Find component for %s", check.Name, check.PluginType))
 	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Component %s", check.Name, check.Component))
-	componentConfig, err := stands.FindComponent(check.Component, logMessage)
-	if err != nil {
-
+	// Селектор с шаблонами разрешается при выполнении: targets == nil
+	var targets []componentTarget
+	if HasTemplate(check.Component) {
+		logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] 'component' contains templates, resolved at execution", check.Name))
+	} else if targets, err = resolveComponents(executor, "Check", check.Name, check.Component, stands, logMessage); err != nil {
 		return nil, nil, err
 	}
-
-	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] GetComponent for Check for %s, componentConfig: %s", check.Name, check.PluginType, componentConfig))
-	if pluginComponent, err = executor.GetComponent(componentConfig); err == nil {
-
-		logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] Validate Component object for %s, pluginComponent: %s", check.Name, check.PluginType, pluginComponent))
-		componentErr := executor.ValidateYAMLComponent(pluginComponent)
-		if componentErr != nil {
-
-			return nil, nil, fmt.Errorf(" [Check:'%s']'executor.ValidateYAMLComponent' ERROR '%s'", check.Name, err)
-		}
-	} else {
-		return nil, nil, fmt.Errorf(" [Check:'%s']'executor.GetComponent' ERROR '%s'", check.Name, err)
-	}
 	logMessage("DEBUG", fmt.Sprintf("[Check:'%s'] valitation Finish!", check.Name))
 
-	return &pluginCheck, &pluginComponent, nil
+	return &pluginCheck, targets, nil
 }
 
 func (c *Check) ValidateCH(check Check) error {
@@ -92,19 +107,43 @@ func (c *Check) ValidateCH(check Check) error {
 	if check.Actions == nil {
 		return fmt.Errorf("[Check:'%s'] 'actions' is empty", check.Name)
 	}
+	if check.Retries != nil && *check.Retries < 0 {
+		return fmt.Errorf("[Check:'%s'] 'retries' must not be negative", check.Name)
+	}
+	if check.Interval != nil && *check.Interval < 0 {
+		return fmt.Errorf("[Check:'%s'] 'interval' must not be negative", check.Name)
+	}
+	if check.Backoff != 0 && check.Backoff < 1 {
+		return fmt.Errorf("[Check:'%s'] 'backoff' must be >= 1", check.Name)
+	}
+	if check.Timeout < 0 {
+		return fmt.Errorf("[Check:'%s'] 'timeout' must not be negative", check.Name)
+	}
+	if err := validateStepTemplates("Check", check.Name, map[string]map[string]interface{}{
+		"action":    check.Actions,
+		"component": check.Component,
+		"outputs":   check.Outputs,
+	}); err != nil {
+		return err
+	}
+	if err := ValidateWhen(check.When); err != nil {
+		return fmt.Errorf("[Check:'%s'] 'when' %v", check.Name, err)
+	}
 
-	return nil
+	return validateGroupPolicy("Check", check.Name, check.Parallel, check.OnFailure)
 }
 
-func (c *Check) ExecCheck(check Check, stageName string, pc *plugin.PluginController, stands *StandsFile, logMessage func(string, string, ...interface{})) error {
+func (c *Check) ExecCheck(check Check, stageName string, ms *MigrationSet, logger *Logger) error {
+
+	logMessage := logger.Log
 
 	logMessage("INFO", fmt.Sprintf("[Check > %s] Start ExecCheck", check.Name))
 	ctx := context.Background()
 
 	logMessage("DEBUG", fmt.Sprintf("[Check > %s] Check executor", check.Name))
-	executor, ok := pc.ExecutorPluginRegistry[check.PluginType]
-	if !ok {
-		return fmt.Errorf("'Check.Plugin' плагин для типа '%s' не найден", check.PluginType)
+	executor, err := lookupExecutor(ms.PluginController, "Check", check.Name, check.PluginType, false, logMessage)
+	if err != nil {
+		return err
 	} else {
 		pluginInfo, err := executor.GetInfo()
 		if err == nil {
@@ -115,30 +154,113 @@ func (c *Check) ExecCheck(check Check, stageName string, pc *plugin.PluginContro
 		}
 	}
 
-	v1Check, v1Compomemt, err := check.CascadeValidation(check, pc, *stands, logMessage)
+	if err := ms.stepWhen("Check", check.Name, check.When, logMessage); err != nil {
+		return err
+	}
+
+	v1Check, targets, err := check.CascadeValidation(check, ms.PluginController, *ms.StandsFile, false, logMessage)
 	if err != nil {
 		return err
-	} else {
-		checkCode, err := executor.ExecCheck(ctx, *v1Compomemt, *v1Check)
-		if err == nil {
-			if !checkCode {
-				logMessage("ERROR", "checkCode is False")
-			}
-		} else {
+	}
+	if targets == nil {
+		if targets, err = ms.resolveTargets(executor, "Check", check.Name, check.Component, logMessage); err != nil {
 			return err
 		}
 	}
+	if targets, err = ms.targetsWhen("Check", check.Name, check.When, targets, logMessage); err != nil {
+		return err
+	}
+	ms.Report.Target(stageName, check.Name, check.PluginType, targets)
+
+	outputs := newStepOutputs()
+	_, err = fanOut("Check", check.Name, targets, check.Parallel, check.OnFailure, logger, func(target componentTarget, targetLogger *Logger) error {
+		pluginCheck, err := ms.targetCheck(ctx, executor, "Check", check.Name, check.Actions, *v1Check, target)
+		if err != nil {
+			return err
+		}
+		raw, attempts, err := check.pollCheck(ctx, executor, target, pluginCheck, targetLogger)
+		ms.Report.Attempts(stageName, check.Name, attempts)
+		if err != nil {
+			return err
+		}
+		return outputs.add(ms, "Check", check.Name, check.Outputs, raw, target)
+	})
+	if err != nil {
+		return err
+	}
+	ms.Outputs.Set(stageName, check.Name, outputs.result(targets))
 	return nil
 }
 
+// pollCheck повторяет проверку компонента, пока она не вернёт true или не закончатся попытки.
+// Возвращает выходные значения успешной попытки и число выполненных попыток.
+func (c *Check) pollCheck(ctx context.Context, executor v1.Executor, target componentTarget, v1Check v1.Check, logger *Logger) (map[string]interface{}, int, error) {
+
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	retries, interval, backoff := c.retryPolicy()
+	for attempt := 1; ; attempt++ {
+		logMessage := logger.With(LOG_FIELD_ATTEMPT, attempt).Log
+		logMessage("DEBUG", fmt.Sprintf("[Check > %s] Component '%s' attempt %d/%d", c.Name, target.Name, attempt, retries+1))
+
+		checkCode, outputs, err := execCheck(ctx, executor, target.Component, v1Check)
+		if err == nil && checkCode {
+			logMessage("INFO", fmt.Sprintf("[Check > %s] Component '%s' check passed", c.Name, target.Name))
+			return outputs, attempt, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("[Check > %s] checkCode is False", c.Name)
+		}
+
+		if attempt > retries {
+			return nil, attempt, fmt.Errorf("[Check > %s] failed after %d attempt(s): %w", c.Name, attempt, err)
+		}
+		// Ошибка попытки, после которой будет повтор, - предупреждение; итоговую ошибку выводит fanOut
+		logMessage("WARN", fmt.Sprintf("[Check > %s] Component '%s' attempt %d failed: %v. Retry in %s", c.Name, target.Name, attempt, err, interval))
+
+		select {
+		case <-ctx.Done():
+			return nil, attempt, fmt.Errorf("[Check > %s] timeout %s exceeded after %d attempt(s): %w", c.Name, c.Timeout, attempt, err)
+		case <-time.After(interval):
+		}
+		interval = time.Duration(float64(interval) * backoff)
+	}
+}
+
+// retryPolicy возвращает параметры повторов проверки с учётом значений по умолчанию
+func (c *Check) retryPolicy() (int, time.Duration, float64) {
+	retries := DEFAULT_CHECK_RETRIES
+	if c.Retries != nil {
+		retries = *c.Retries
+	}
+	interval := DEFAULT_CHECK_INTERVAL
+	if c.Interval != nil {
+		interval = *c.Interval
+	}
+	backoff := DEFAULT_CHECK_BACKOFF
+	if c.Backoff != 0 {
+		backoff = c.Backoff
+	}
+	return retries, interval, backoff
+}
+
 type Script struct {
 	Name       string                 `yaml:"name"`
 	PluginType string                 `yaml:"plugin"`
 	Actions    map[string]interface{} `yaml:"action"`
 	Component  map[string]interface{} `yaml:"component"`
+	When       string                 `yaml:"when"`       // Условие выполнения шага
+	Rollback   map[string]interface{} `yaml:"rollback"`   // Компенсирующее действие для отката
+	Parallel   int                    `yaml:"parallel"`   // Число компонентов группы, обрабатываемых одновременно
+	OnFailure  string                 `yaml:"on_failure"` // Политика ошибок для группы: 'stop' или 'continue'
+	Outputs    map[string]interface{} `yaml:"outputs"`    // Именованные выходные значения: шаблоны от 'output'
 }
 
-func (s *Script) CascadeValidation(script Script, pc *plugin.PluginController, stands StandsFile, logMessage func(string, string, ...interface{})) (*v1.Action, *v1.Component, error) {
+func (s *Script) CascadeValidation(script Script, pc *plugin.PluginController, stands StandsFile, install bool, logMessage func(string, string, ...interface{})) (*v1.Action, []componentTarget, error) {
 
 	validErr := s.ValidateSC(script)
 	if validErr != nil {
@@ -146,26 +268,22 @@ func (s *Script) CascadeValidation(script Script, pc *plugin.PluginController, s
 	}
 
 	var pluginAction v1.Action
-	var pluginComponent v1.Component
 	var err error
 
 	ctx := context.TODO()
 
 	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Check executor for '%s'", script.Name, script.PluginType))
-	executor, ok := pc.ExecutorPluginRegistry[script.PluginType]
-	if !ok {
-		logMessage("ERROR", fmt.Sprintf("[Script:'%s'] 'Script.Plugin' плагин для типа '%s' не найден. Попытка установки", script.Name, script.PluginType))
-		err = pc.InstallPlugin(script.PluginType)
-		if err != nil {
-			return nil, nil, err
-		}
-	} else {
-		info, _ := executor.GetInfo()
-		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Executor for '%s': %s", script.Name, script.PluginType, info))
+	executor, err := validationExecutor(pc, "Script", script.Name, script.PluginType, install, logMessage)
+	if err != nil || executor == nil {
+		return nil, nil, err
 	}
+	info, _ := executor.GetInfo()
+	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Executor for '%s': %s", script.Name, script.PluginType, info))
 
 	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] GetCheck object for %s", script.Name, script.PluginType))
-	if pluginAction, err = executor.GetAction(script.Actions); err == nil {
+	if HasTemplate(script.Actions) {
+		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] 'action' contains templates, validation deferred to execution", script.Name))
+	} else if pluginAction, err = executor.GetAction(script.Actions); err == nil {
 
 		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Validate Script object for %s", script.Name, script.PluginType))
 		if err = executor.ValidateYAMLAction(ctx, pluginAction); err != nil {
@@ -179,29 +297,25 @@ func (s *Script) CascadeValidation(script Script, pc *plugin.PluginController, s
 		return nil, nil, err
 	}
 
+	if script.Rollback != nil && !HasTemplate(script.Rollback) {
+		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Validate Rollback object for %s", script.Name, script.PluginType))
+		if err := validateRollback(executor, script.Rollback); err != nil {
+			return nil, nil, fmt.Errorf("[Script:'%s'] 'rollback' %v", script.Name, err)
+		}
+	}
+
 	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Find component for %s", script.Name, script.PluginType))
 	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Component %s", script.Name, script.Component))
-	componentConfig, err := stands.FindComponent(script.Component, logMessage)
-	if err != nil {
-
+	// Селектор с шаблонами разрешается при выполнении: targets == nil
+	var targets []componentTarget
+	if HasTemplate(script.Component) {
+		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] 'component' contains templates, resolved at execution", script.Name))
+	} else if targets, err = resolveComponents(executor, "Script", script.Name, script.Component, stands, logMessage); err != nil {
 		return nil, nil, err
 	}
-
-	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] GetComponent for Check for %s, componentConfig: %s", script.Name, script.PluginType, componentConfig))
-	if pluginComponent, err = executor.GetComponent(componentConfig); err == nil {
-
-		logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] Validate Component object for %s, pluginComponent: %s", script.Name, script.PluginType, pluginComponent))
-		componentErr := executor.ValidateYAMLComponent(pluginComponent)
-		if componentErr != nil {
-
-			return nil, nil, fmt.Errorf(" [Script:'%s']'executor.ValidateYAMLComponent' ERROR '%s'", script.Name, err)
-		}
-	} else {
-		return nil, nil, fmt.Errorf(" [Script:'%s']'executor.GetComponent' ERROR '%s'", script.Name, err)
-	}
 	logMessage("DEBUG", fmt.Sprintf("[Script:'%s'] valitation Finish!", script.Name))
 
-	return &pluginAction, &pluginComponent, nil
+	return &pluginAction, targets, nil
 }
 
 func (s *Script) ValidateSC(script Script) error {
@@ -215,48 +329,91 @@ func (s *Script) ValidateSC(script Script) error {
 	if script.Actions == nil {
 		return fmt.Errorf("[Script:'%s'] 'actions' is empty", script.Name)
 	}
+	if err := validateStepTemplates("Script", script.Name, map[string]map[string]interface{}{
+		"action":    script.Actions,
+		"component": script.Component,
+		"rollback":  script.Rollback,
+		"outputs":   script.Outputs,
+	}); err != nil {
+		return err
+	}
+	if err := ValidateWhen(script.When); err != nil {
+		return fmt.Errorf("[Script:'%s'] 'when' %v", script.Name, err)
+	}
 
-	return nil
+	return validateGroupPolicy("Script", script.Name, script.Parallel, script.OnFailure)
 }
 
-func (s *Script) ExecScript(script Script, stageName string, pc *plugin.PluginController, stands *StandsFile, logMessage func(string, string, ...interface{})) error {
+func (s *Script) ExecScript(script Script, stageName string, ms *MigrationSet, logger *Logger) ([]string, error) {
 
 	ctx := context.Background()
+	logMessage := logger.Log
 
 	logMessage("DEBUG", fmt.Sprintf("[Script > %s] Check executor", script.Name))
-	executor, ok := pc.ExecutorPluginRegistry[script.PluginType]
-	if !ok {
-		return fmt.Errorf("'Check.Plugin' плагин для типа '%s' не найден", script.PluginType)
+	executor, err := lookupExecutor(ms.PluginController, "Script", script.Name, script.PluginType, false, logMessage)
+	if err != nil {
+		return nil, err
 	} else {
 		pluginInfo, err := executor.GetInfo()
 		if err == nil {
 			logMessage("INFO", fmt.Sprintf("[Script > %s] Plugin: '%s'. Version: %s", script.Name, pluginInfo.Name, pluginInfo.Version))
 			logMessage("DEBUG", fmt.Sprintf("[Script > %s] Plugin: '%s'. Desc: %s", script.Name, pluginInfo.Name, pluginInfo.Description))
 		} else {
-			return err
+			return nil, err
 		}
 	}
 
-	v1Action, v1Compomemt, err := script.CascadeValidation(script, pc, *stands, logMessage)
+	if err := ms.stepWhen("Script", script.Name, script.When, logMessage); err != nil {
+		return nil, err
+	}
+
+	v1Action, targets, err := script.CascadeValidation(script, ms.PluginController, *ms.StandsFile, false, logMessage)
 	if err != nil {
-		return err
-	} else {
-		err := executor.ExecAction(ctx, *v1Compomemt, *v1Action)
+		return nil, err
+	}
+	if targets == nil {
+		if targets, err = ms.resolveTargets(executor, "Script", script.Name, script.Component, logMessage); err != nil {
+			return nil, err
+		}
+	}
+	if targets, err = ms.targetsWhen("Script", script.Name, script.When, targets, logMessage); err != nil {
+		return nil, err
+	}
+	ms.Report.Target(stageName, script.Name, script.PluginType, targets)
+
+	outputs := newStepOutputs()
+	done, err := fanOut("Script", script.Name, targets, script.Parallel, script.OnFailure, logger, func(target componentTarget, _ *Logger) error {
+		pluginAction, err := ms.targetAction(ctx, executor, "Script", script.Name, script.Actions, *v1Action, target)
+		if err != nil {
+			return err
+		}
+		raw, err := execAction(ctx, executor, target.Component, pluginAction)
 		if err != nil {
 			return err
 		}
+		return outputs.add(ms, "Script", script.Name, script.Outputs, raw, target)
+	})
+	if err == nil {
+		ms.Outputs.Set(stageName, script.Name, outputs.result(targets))
 	}
-	return nil
+	return done, err
 }
 
 type Task struct {
-	Name       string                 `yaml:"name"`
-	PluginType string                 `yaml:"plugin"`
-	Actions    map[string]interface{} `yaml:"action"`
-	Component  map[string]interface{} `yaml:"component"`
+	Name           string                 `yaml:"name"`
+	PluginType     string                 `yaml:"plugin"`
+	Actions        map[string]interface{} `yaml:"action"`
+	Component      map[string]interface{} `yaml:"component"`
+	When           string                 `yaml:"when"`            // Условие выполнения шага
+	Rollback       map[string]interface{} `yaml:"rollback"`        // Компенсирующее действие для отката
+	Parallel       int                    `yaml:"parallel"`        // Число компонентов группы, обрабатываемых одновременно
+	OnFailure      string                 `yaml:"on_failure"`      // Политика ошибок для группы: 'stop' или 'continue'
+	Outputs        map[string]interface{} `yaml:"outputs"`         // Именованные выходные значения: шаблоны от 'output'
+	ToVersion      string                 `yaml:"to_version"`      // Версия компонента после шага; компоненты с этой версией пропускаются
+	AllowDowngrade bool                   `yaml:"allow_downgrade"` // Разрешить понижение версии до 'to_version'
 }
 
-func (t *Task) CascadeValidation(task Task, pc *plugin.PluginController, stands StandsFile, logMessage func(string, string, ...interface{})) (*v1.Action, *v1.Component, error) {
+func (t *Task) CascadeValidation(task Task, pc *plugin.PluginController, stands StandsFile, install bool, logMessage func(string, string, ...interface{})) (*v1.Action, []componentTarget, error) {
 
 	validErr := t.ValidateT(task)
 	if validErr != nil {
@@ -264,26 +421,22 @@ func (t *Task) CascadeValidation(task Task, pc *plugin.PluginController, stands
 	}
 
 	var pluginAction v1.Action
-	var pluginComponent v1.Component
 	var err error
 
 	ctx := context.TODO()
 
 	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Check executor for '%s'", task.Name, task.PluginType))
-	executor, ok := pc.ExecutorPluginRegistry[task.PluginType]
-	if !ok {
-		logMessage("ERROR", fmt.Sprintf("[Task:'%s'] 'Task.Plugin' плагин для типа '%s' не найден", task.Name, task.PluginType))
-		err = pc.InstallPlugin(task.PluginType)
-		if err != nil {
-			return nil, nil, err
-		}
-	} else {
-		info, _ := executor.GetInfo()
-		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Executor object for '%s': %s", task.Name, task.PluginType, info))
+	executor, err := validationExecutor(pc, "Task", task.Name, task.PluginType, install, logMessage)
+	if err != nil || executor == nil {
+		return nil, nil, err
 	}
+	info, _ := executor.GetInfo()
+	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Executor object for '%s': %s", task.Name, task.PluginType, info))
 
 	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] GetAction object for %s", task.Name, task.PluginType))
-	if pluginAction, err = executor.GetAction(task.Actions); err == nil {
+	if HasTemplate(task.Actions) {
+		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] 'action' contains templates, validation deferred to execution", task.Name))
+	} else if pluginAction, err = executor.GetAction(task.Actions); err == nil {
 
 		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Validate Action object for %s", task.Name, task.PluginType))
 		if err := executor.ValidateYAMLAction(ctx, pluginAction); err != nil {
@@ -297,29 +450,25 @@ func (t *Task) CascadeValidation(task Task, pc *plugin.PluginController, stands
 		return nil, nil, err
 	}
 
+	if task.Rollback != nil && !HasTemplate(task.Rollback) {
+		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Validate Rollback object for %s", task.Name, task.PluginType))
+		if err := validateRollback(executor, task.Rollback); err != nil {
+			return nil, nil, fmt.Errorf("[Task:'%s'] 'rollback' %v", task.Name, err)
+		}
+	}
+
 	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Find component for %s", task.Name, task.PluginType))
 	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Component %s", task.Name, task.Component))
-	componentConfig, err := stands.FindComponent(task.Component, logMessage)
-	if err != nil {
-
+	// Селектор с шаблонами разрешается при выполнении: targets == nil
+	var targets []componentTarget
+	if HasTemplate(task.Component) {
+		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] 'component' contains templates, resolved at execution", task.Name))
+	} else if targets, err = resolveComponents(executor, "Task", task.Name, task.Component, stands, logMessage); err != nil {
 		return nil, nil, err
 	}
-
-	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] GetComponent for Action for %s, componentConfig: %s", task.Name, task.PluginType, componentConfig))
-	if pluginComponent, err = executor.GetComponent(componentConfig); err == nil {
-
-		logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] Validate Component object for %s, pluginComponent: %s", task.Name, task.PluginType, pluginComponent))
-		componentErr := executor.ValidateYAMLComponent(pluginComponent)
-		if componentErr != nil {
-
-			return nil, nil, fmt.Errorf(" [Task:'%s']'executor.ValidateYAMLComponent' ERROR '%s'", task.Name, err)
-		}
-	} else {
-		return nil, nil, fmt.Errorf(" [Task:'%s']'executor.GetComponent' ERROR '%s'", task.Name, err)
-	}
 	logMessage("DEBUG", fmt.Sprintf("[Task:'%s'] valitation Finish!", task.Name))
 
-	return &pluginAction, &pluginComponent, nil
+	return &pluginAction, targets, nil
 }
 
 func (t *Task) ValidateT(task Task) error {
@@ -333,36 +482,454 @@ func (t *Task) ValidateT(task Task) error {
 	if task.Actions == nil {
 		return fmt.Errorf("[Task:'%s'] 'actions' is empty", task.Name)
 	}
+	if err := validateStepTemplates("Task", task.Name, map[string]map[string]interface{}{
+		"action":    task.Actions,
+		"component": task.Component,
+		"rollback":  task.Rollback,
+		"outputs":   task.Outputs,
+	}); err != nil {
+		return err
+	}
+	if err := ValidateWhen(task.When); err != nil {
+		return fmt.Errorf("[Task:'%s'] 'when' %v", task.Name, err)
+	}
+	if task.ToVersion != "" {
+		if _, err := semver.Parse(task.ToVersion); err != nil {
+			return fmt.Errorf("[Task:'%s'] 'to_version' %v", task.Name, err)
+		}
+	}
 
-	return nil
+	return validateGroupPolicy("Task", task.Name, task.Parallel, task.OnFailure)
 }
 
-func (t *Task) ExecTask(task Task, stageName string, pc *plugin.PluginController, stands *StandsFile, logMessage func(string, string, ...interface{})) error {
+func (t *Task) ExecTask(task Task, stageName string, ms *MigrationSet, logger *Logger) ([]string, error) {
 
 	ctx := context.Background()
+	logMessage := logger.Log
 
 	logMessage("DEBUG", fmt.Sprintf("[Task > %s] Check executor", task.Name))
-	executor, ok := pc.ExecutorPluginRegistry[task.PluginType]
-	if !ok {
-		return fmt.Errorf("'Task.Plugin' плагин для типа '%s' не найден", task.PluginType)
+	executor, err := lookupExecutor(ms.PluginController, "Task", task.Name, task.PluginType, false, logMessage)
+	if err != nil {
+		return nil, err
 	} else {
 		pluginInfo, err := executor.GetInfo()
 		if err == nil {
 			logMessage("INFO", fmt.Sprintf("[Task > %s] Plugin: '%s'. Version: %s", task.Name, pluginInfo.Name, pluginInfo.Version))
 			logMessage("DEBUG", fmt.Sprintf("[Task > %s] Plugin: '%s'. Desc: %s", task.Name, pluginInfo.Name, pluginInfo.Description))
 		} else {
+			return nil, err
+		}
+	}
+
+	if err := ms.stepWhen("Task", task.Name, task.When, logMessage); err != nil {
+		return nil, err
+	}
+
+	v1Action, targets, err := task.CascadeValidation(task, ms.PluginController, *ms.StandsFile, false, logMessage)
+	if err != nil {
+		return nil, err
+	}
+	if targets == nil {
+		if targets, err = ms.resolveTargets(executor, "Task", task.Name, task.Component, logMessage); err != nil {
+			return nil, err
+		}
+	}
+	if targets, err = ms.targetsWhen("Task", task.Name, task.When, targets, logMessage); err != nil {
+		return nil, err
+	}
+	if targets, err = ms.targetsVersion(task, targets, logMessage); err != nil {
+		return nil, err
+	}
+	ms.Report.Target(stageName, task.Name, task.PluginType, targets)
+
+	outputs := newStepOutputs()
+	done, err := fanOut("Task", task.Name, targets, task.Parallel, task.OnFailure, logger, func(target componentTarget, targetLogger *Logger) error {
+		pluginAction, err := ms.targetAction(ctx, executor, "Task", task.Name, task.Actions, *v1Action, target)
+		if err != nil {
 			return err
 		}
+		raw, err := execAction(ctx, executor, target.Component, pluginAction)
+		if err != nil {
+			return err
+		}
+		if task.ToVersion != "" {
+			if err := ms.State.SetVersion(stepKey(stageName, "task", task.Name), target, task.ToVersion); err != nil {
+				return err
+			}
+			targetLogger.Log("INFO", fmt.Sprintf("[Task > %s] Component '%s' updated to version '%s'", task.Name, target.Name, task.ToVersion))
+		}
+		return outputs.add(ms, "Task", task.Name, task.Outputs, raw, target)
+	})
+	if err == nil {
+		ms.Outputs.Set(stageName, task.Name, outputs.result(targets))
 	}
+	return done, err
+}
+
+// validateRollback проверяет компенсирующее действие шага средствами плагина
+func validateRollback(executor v1.Executor, rollback map[string]interface{}) error {
 
-	v1Action, v1Compomemt, err := task.CascadeValidation(task, pc, *stands, logMessage)
+	pluginAction, err := executor.GetAction(rollback)
 	if err != nil {
 		return err
-	} else {
-		err := executor.ExecAction(ctx, *v1Compomemt, *v1Action)
+	}
+	if err := executor.ValidateYAMLAction(context.TODO(), pluginAction); err != nil {
+		return fmt.Errorf("ошибка валидации данных: %v", err)
+	}
+	return nil
+}
+
+// ExecRollback выполняет компенсирующее действие ранее выполненного шага.
+// Шаблоны в 'rollback' вычисляются в момент отката.
+func (a *Action) ExecRollback(action Action, ms *MigrationSet, logger *Logger) error {
+
+	ctx := context.Background()
+	logMessage := logger.Log
+
+	logMessage("DEBUG", fmt.Sprintf("[Rollback > %s] Check executor", action.Name))
+	executor, err := lookupExecutor(ms.PluginController, "Rollback", action.Name, action.PluginType, false, logMessage)
+	if err != nil {
+		return err
+	}
+
+	if err := ms.stepWhen("Rollback", action.Name, action.When, logMessage); err != nil {
+		return skippedAsNil(err)
+	}
+
+	var pluginAction v1.Action
+	if !HasTemplate(action.Rollback) {
+		var err error
+		if pluginAction, err = executor.GetAction(action.Rollback); err != nil {
+			return err
+		}
+	}
+
+	targets, err := ms.resolveTargets(executor, "Rollback", action.Name, action.Component, logMessage)
+	if err != nil {
+		return err
+	}
+	if targets, err = ms.targetsWhen("Rollback", action.Name, action.When, targets, logMessage); err != nil {
+		return skippedAsNil(err)
+	}
+
+	_, err = fanOut("Rollback", action.Name, targets, 0, GROUP_FAILURE_CONTINUE, logger, func(target componentTarget, _ *Logger) error {
+		targetAction, err := ms.targetAction(ctx, executor, "Rollback", action.Name, action.Rollback, pluginAction, target)
 		if err != nil {
 			return err
 		}
+		return executor.ExecAction(ctx, target.Component, targetAction)
+	})
+	if err != nil {
+		return err
+	}
+	// Откат шага с 'to_version' возвращает компоненту прежнюю версию
+	return ms.State.Restore(action.Name)
+}
+
+// componentTarget компонент стенда, подготовленный плагином к выполнению шага
+type componentTarget struct {
+	Name      string
+	Component v1.Component
+	Source    Component // Компонент из файла стендов
+	Stand     *Stand    // Стенд, в котором найден компонент
+}
+
+// resolveComponents находит компоненты по селектору и получает для каждого объект плагина
+func resolveComponents(executor v1.Executor, kind string, stepName string, selector map[string]interface{}, stands StandsFile, logMessage func(string, string, ...interface{})) ([]componentTarget, error) {
+
+	stand, components, err := stands.findComponents(selector, logMessage)
+	if err != nil {
+		return nil, fmt.Errorf("[%s:'%s'] %v", kind, stepName, err)
+	}
+
+	targets := make([]componentTarget, 0, len(components))
+	for _, component := range components {
+		logMessage("DEBUG", "[%s:'%s'] GetComponent '%s', componentConfig: %v", kind, stepName, component.Name, RedactSecrets(component.ComponentConfig))
+		// Секреты разрешаются непосредственно перед передачей компонента плагину
+		config, err := ResolveSecrets(component.ComponentConfig)
+		if err != nil {
+			return nil, fmt.Errorf("[%s:'%s'] component '%s' 'config' %v", kind, stepName, component.Name, err)
+		}
+		pluginComponent, err := executor.GetComponent(config)
+		if err != nil {
+			return nil, fmt.Errorf(" [%s:'%s']'executor.GetComponent' ERROR '%s'", kind, stepName, err)
+		}
+
+		logMessage("DEBUG", fmt.Sprintf("[%s:'%s'] Validate Component '%s'", kind, stepName, component.Name))
+		if err := executor.ValidateYAMLComponent(pluginComponent); err != nil {
+			return nil, fmt.Errorf(" [%s:'%s']'executor.ValidateYAMLComponent' ERROR '%s'", kind, stepName, err)
+		}
+
+		targets = append(targets, componentTarget{Name: component.Name, Component: pluginComponent, Source: component, Stand: stand})
+	}
+	return targets, nil
+}
+
+// resolveTargets вычисляет шаблоны селектора 'component' и находит компоненты шага
+func (ms *MigrationSet) resolveTargets(executor v1.Executor, kind string, stepName string, selector map[string]interface{}, logMessage func(string, string, ...interface{})) ([]componentTarget, error) {
+
+	rendered, err := RenderMap(selector, ms.templateScope(nil))
+	if err != nil {
+		return nil, fmt.Errorf("[%s:'%s'] 'component' %v", kind, stepName, err)
+	}
+	return resolveComponents(executor, kind, stepName, rendered, *ms.StandsFile, logMessage)
+}
+
+// stepSkipped возвращается шагом, пропущенным по условию 'when'
+type stepSkipped struct {
+	reason string
+}
+
+func (e *stepSkipped) Error() string {
+	return "skipped: " + e.reason
+}
+
+// skippedAsNil считает пропуск по 'when' успешным завершением
+func skippedAsNil(err error) error {
+	var skipped *stepSkipped
+	if errors.As(err, &skipped) {
+		return nil
+	}
+	return err
+}
+
+// stepWhen вычисляет условие 'when' шага, не зависящее от компонента и стенда, до поиска компонентов.
+// Если условие ложно, возвращает *stepSkipped.
+func (ms *MigrationSet) stepWhen(kind string, stepName string, when string, logMessage func(string, string, ...interface{})) error {
+
+	expr, err := parseWhen(when)
+	if err != nil {
+		return fmt.Errorf("[%s:'%s'] 'when' %v", kind, stepName, err)
+	}
+	if expr == nil || whenPerTarget(expr) {
+		return nil
+	}
+
+	ok, err := expr.Eval(ms.templateScope(nil))
+	if err != nil {
+		return fmt.Errorf("[%s:'%s'] 'when' %v", kind, stepName, err)
+	}
+	if !ok {
+		logMessage("INFO", fmt.Sprintf("[%s > %s] Skipped: when '%s' is false", kind, stepName, when))
+		return &stepSkipped{reason: fmt.Sprintf("when '%s' is false", when)}
 	}
 	return nil
 }
+
+// targetsWhen оставляет компоненты, для которых истинно условие 'when', зависящее от компонента
+// или его стенда. Если не осталось ни одного, возвращает *stepSkipped.
+func (ms *MigrationSet) targetsWhen(kind string, stepName string, when string, targets []componentTarget, logMessage func(string, string, ...interface{})) ([]componentTarget, error) {
+
+	expr, err := parseWhen(when)
+	if err != nil {
+		return nil, fmt.Errorf("[%s:'%s'] 'when' %v", kind, stepName, err)
+	}
+	if expr == nil || !whenPerTarget(expr) {
+		return targets, nil
+	}
+
+	var selected []componentTarget
+	for _, target := range targets {
+		ok, err := expr.Eval(ms.templateScope(&target))
+		if err != nil {
+			return nil, fmt.Errorf("[%s:'%s'] component '%s': 'when' %v", kind, stepName, target.Name, err)
+		}
+		if !ok {
+			logMessage("INFO", fmt.Sprintf("[%s > %s] Component '%s' skipped: when '%s' is false", kind, stepName, target.Name, when))
+			continue
+		}
+		selected = append(selected, target)
+	}
+	if len(selected) == 0 {
+		logMessage("INFO", fmt.Sprintf("[%s > %s] Skipped: when '%s' is false for all components", kind, stepName, when))
+		return nil, &stepSkipped{reason: fmt.Sprintf("when '%s' is false for all components", when)}
+	}
+	return selected, nil
+}
+
+// whenPerTarget сообщает, что условие вычисляется для каждого компонента:
+// 'stand' при нескольких стендах определяется стендом компонента
+func whenPerTarget(expr *Expr) bool {
+	return expr.Uses("component") || expr.Uses("stand")
+}
+
+// targetsVersion отбирает компоненты, которые нужно привести к 'to_version' задачи.
+// Компоненты, уже имеющие эту версию, пропускаются; понижение версии без
+// 'allow_downgrade' (или --allow-downgrade) - ошибка.
+func (ms *MigrationSet) targetsVersion(task Task, targets []componentTarget, logMessage func(string, string, ...interface{})) ([]componentTarget, error) {
+
+	if task.ToVersion == "" {
+		return targets, nil
+	}
+
+	var selected []componentTarget
+	for _, target := range targets {
+		pending, err := ms.versionPending(task, target)
+		if err != nil {
+			return nil, err
+		}
+		if !pending {
+			logMessage("INFO", fmt.Sprintf("[Task > %s] Component '%s' skipped: already at version '%s'", task.Name, target.Name, task.ToVersion))
+			continue
+		}
+		selected = append(selected, target)
+	}
+	if len(selected) == 0 {
+		return nil, &stepSkipped{reason: fmt.Sprintf("all components already at version '%s'", task.ToVersion)}
+	}
+	return selected, nil
+}
+
+// versionPending сообщает, нужно ли выполнять задачу для компонента target
+func (ms *MigrationSet) versionPending(task Task, target componentTarget) (bool, error) {
+
+	current := ms.State.Version(target)
+	cmp, err := semver.CompareStrings(current, task.ToVersion)
+	if err != nil {
+		return false, fmt.Errorf("[Task:'%s'] component '%s': %v", task.Name, target.Name, err)
+	}
+	if cmp > 0 && !task.AllowDowngrade && !ms.AllowDowngrade {
+		return false, fmt.Errorf("[Task:'%s'] component '%s': downgrade '%s' => '%s' is not allowed (set 'allow_downgrade')", task.Name, target.Name, current, task.ToVersion)
+	}
+	return cmp != 0, nil
+}
+
+// targetAction возвращает действие шага для компонента target. Действие без шаблонов
+// уже проверено при валидации, с шаблонами - вычисляется и проверяется плагином здесь.
+func (ms *MigrationSet) targetAction(ctx context.Context, executor v1.Executor, kind string, stepName string, action map[string]interface{}, validated v1.Action, target componentTarget) (v1.Action, error) {
+
+	if !HasTemplate(action) {
+		return validated, nil
+	}
+	rendered, err := RenderMap(action, ms.templateScope(&target))
+	if err != nil {
+		return nil, fmt.Errorf("[%s:'%s'] 'action' %v", kind, stepName, err)
+	}
+	pluginAction, err := executor.GetAction(rendered)
+	if err != nil {
+		return nil, err
+	}
+	if err := executor.ValidateYAMLAction(ctx, pluginAction); err != nil {
+		return nil, fmt.Errorf("ошибка валидации данных: %v", err)
+	}
+	return pluginAction, nil
+}
+
+// targetCheck то же, что targetAction, для проверок
+func (ms *MigrationSet) targetCheck(ctx context.Context, executor v1.Executor, kind string, stepName string, check map[string]interface{}, validated v1.Check, target componentTarget) (v1.Check, error) {
+
+	if !HasTemplate(check) {
+		return validated, nil
+	}
+	rendered, err := RenderMap(check, ms.templateScope(&target))
+	if err != nil {
+		return nil, fmt.Errorf("[%s:'%s'] 'action' %v", kind, stepName, err)
+	}
+	pluginCheck, err := executor.GetCheck(rendered)
+	if err != nil {
+		return nil, err
+	}
+	if err := executor.ValidateYAMLCheck(ctx, pluginCheck); err != nil {
+		return nil, fmt.Errorf("ошибка валидации данных: %v", err)
+	}
+	return pluginCheck, nil
+}
+
+// execAction выполняет действие; если плагин умеет, возвращает его выходные значения
+func execAction(ctx context.Context, executor v1.Executor, component v1.Component, action v1.Action) (map[string]interface{}, error) {
+	if outputExecutor, ok := executor.(plugin.OutputExecutor); ok {
+		return outputExecutor.ExecActionOutput(ctx, component, action)
+	}
+	return nil, executor.ExecAction(ctx, component, action)
+}
+
+// execCheck выполняет проверку; если плагин умеет, возвращает её выходные значения
+func execCheck(ctx context.Context, executor v1.Executor, component v1.Component, check v1.Check) (bool, map[string]interface{}, error) {
+	if outputExecutor, ok := executor.(plugin.OutputExecutor); ok {
+		return outputExecutor.ExecCheckOutput(ctx, component, check)
+	}
+	ok, err := executor.ExecCheck(ctx, component, check)
+	return ok, nil, err
+}
+
+// fanOut выполняет шаг на всех целевых компонентах, не более parallel одновременно.
+// При политике 'stop' после первой ошибки новые компоненты не запускаются.
+// Возвращает имена компонентов, на которых шаг выполнен успешно, и первую ошибку.
+// exec получает логгер с полем компонента.
+func fanOut(kind string, stepName string, targets []componentTarget, parallel int, policy string, logger *Logger, exec func(componentTarget, *Logger) error) ([]string, error) {
+
+	if parallel <= 0 {
+		parallel = DEFAULT_GROUP_PARALLEL
+	}
+	if policy == "" {
+		policy = DEFAULT_GROUP_FAILURE_POLICY
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		failed   bool
+	)
+	succeeded := make([]bool, len(targets))
+	sem := make(chan struct{}, parallel)
+
+	for i, target := range targets {
+		sem <- struct{}{}
+
+		mu.Lock()
+		stop := failed && policy == GROUP_FAILURE_STOP
+		mu.Unlock()
+		if stop {
+			<-sem
+			logger.Log("INFO", fmt.Sprintf("[%s > %s] Skip remaining components after failure", kind, stepName))
+			break
+		}
+
+		wg.Add(1)
+		go func(i int, target componentTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			targetLogger := logger.With(LOG_FIELD_COMPONENT, target.Name)
+			if len(targets) > 1 {
+				targetLogger.Log("INFO", fmt.Sprintf("[%s > %s] Component '%s'", kind, stepName, target.Name))
+			}
+			err := exec(target, targetLogger)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				targetLogger.Log("ERROR", fmt.Sprintf("[%s > %s] Component '%s' failed: %v", kind, stepName, target.Name, err))
+				failed = true
+				if firstErr == nil {
+					firstErr = fmt.Errorf("[%s > %s] component '%s': %w", kind, stepName, target.Name, err)
+				}
+				return
+			}
+			succeeded[i] = true
+		}(i, target)
+	}
+	wg.Wait()
+
+	var names []string
+	for i, target := range targets {
+		if succeeded[i] {
+			names = append(names, target.Name)
+		}
+	}
+	return names, firstErr
+}
+
+// validateGroupPolicy проверяет параметры выполнения шага на группе компонентов
+func validateGroupPolicy(kind string, stepName string, parallel int, policy string) error {
+	if parallel < 0 {
+		return fmt.Errorf("[%s:'%s'] 'parallel' must not be negative", kind, stepName)
+	}
+	switch policy {
+	case "", GROUP_FAILURE_STOP, GROUP_FAILURE_CONTINUE:
+		return nil
+	default:
+		return fmt.Errorf("[%s:'%s'] unknown 'on_failure' policy '%s'", kind, stepName, policy)
+	}
+}
@@ -0,0 +1,280 @@
+package run
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	v1 "github.com/laplasd/roller-epi/v1"
+)
+
+// testStands файл стендов для тестов выполнения: два стенда с одинаковыми компонентами
+const testStands = `msVersion: "0.0.1"
+release: "v0.0.1"
+stand:
+- name: "A"
+  components:
+  - {name: "app", version: "1.0.0", plugin: "fake", group: "g", config: {host: "a-app"}}
+  - {name: "db", version: "1.0.0", plugin: "fake", group: "g", config: {host: "a-db"}}
+- name: "B"
+  components:
+  - {name: "app", version: "1.0.0", plugin: "fake", group: "g", config: {host: "b-app"}}
+`
+
+// fakeExecutor плагин для тестов: записывает выполненные действия и проверки по ключу 'cmd'.
+// Действия из fail завершаются ошибкой, проверки из fail возвращают false.
+// Проверки из failChecks возвращают false указанное число первых вызовов.
+// Каждое действие выполняется не менее delay.
+type fakeExecutor struct {
+	mu         sync.Mutex
+	calls      []string
+	times      []time.Time
+	fail       map[string]bool
+	failChecks map[string]int
+	delay      time.Duration
+	running    int
+	maxRunning int
+}
+
+func (f *fakeExecutor) GetInfo() (v1.PluginInfo, error) {
+	return v1.PluginInfo{Name: "fake"}, nil
+}
+
+func (f *fakeExecutor) GetComponent(data map[string]interface{}) (v1.Component, error) {
+	return data, nil
+}
+
+func (f *fakeExecutor) GetAction(data map[string]interface{}) (v1.Action, error) {
+	if _, ok := data["cmd"]; !ok {
+		return nil, fmt.Errorf("'cmd' is required")
+	}
+	return data, nil
+}
+
+func (f *fakeExecutor) GetCheck(data map[string]interface{}) (v1.Check, error) {
+	return f.GetAction(data)
+}
+
+func (f *fakeExecutor) ValidateYAMLComponent(v1.Component) error {
+	return nil
+}
+
+func (f *fakeExecutor) ValidateYAMLAction(context.Context, v1.Action) error {
+	return nil
+}
+
+func (f *fakeExecutor) ValidateYAMLCheck(context.Context, v1.Check) error {
+	return nil
+}
+
+func (f *fakeExecutor) ExecAction(_ context.Context, _ v1.Component, action v1.Action) error {
+	cmd := f.record(action, "")
+	if f.delay > 0 {
+		f.track(1)
+		time.Sleep(f.delay)
+		f.track(-1)
+	}
+	if f.fail[cmd] {
+		return fmt.Errorf("%s failed", cmd)
+	}
+	return nil
+}
+
+func (f *fakeExecutor) ExecCheck(_ context.Context, _ v1.Component, check v1.Check) (bool, error) {
+	cmd := f.record(check, "check:")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.failChecks[cmd] > 0 {
+		f.failChecks[cmd]--
+		return false, nil
+	}
+	return !f.fail[cmd], nil
+}
+
+// record запоминает вызов и возвращает его 'cmd'
+func (f *fakeExecutor) record(value interface{}, prefix string) string {
+	cmd := fmt.Sprint(value.(map[string]interface{})["cmd"])
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, prefix+cmd)
+	f.times = append(f.times, time.Now())
+	return cmd
+}
+
+func (f *fakeExecutor) Calls() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.calls...)
+}
+
+// Gaps возвращает паузы между последовательными вызовами
+func (f *fakeExecutor) Gaps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var gaps []time.Duration
+	for i := 1; i < len(f.times); i++ {
+		gaps = append(gaps, f.times[i].Sub(f.times[i-1]))
+	}
+	return gaps
+}
+
+// track учитывает число одновременно выполняемых действий
+func (f *fakeExecutor) track(delta int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.running += delta
+	if f.running > f.maxRunning {
+		f.maxRunning = f.running
+	}
+}
+
+// MaxRunning возвращает наибольшее число одновременно выполнявшихся действий
+func (f *fakeExecutor) MaxRunning() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.maxRunning
+}
+
+func testLog(t *testing.T) func(string, string, ...interface{}) {
+	return func(level string, format string, args ...interface{}) {
+		t.Logf(level+" "+format, args...)
+	}
+}
+
+// newTestMigrationSet создаёт миграцию v0.0.1 => v0.0.2 над testStands с этапами из stages
+func newTestMigrationSet(t *testing.T, stages string, executor *fakeExecutor) *MigrationSet {
+	t.Helper()
+
+	dir := t.TempDir()
+	standsFile := filepath.Join(dir, "stands.yml")
+	writeTestFile(t, standsFile, testStands)
+
+	migrationFile := filepath.Join(dir, "migration.yml")
+	writeTestFile(t, migrationFile, fmt.Sprintf("msVersion: \"0.0.1\"\nfrom_release: \"v0.0.1\"\nto_release: \"v0.0.2\"\nstands: %q\n", standsFile)+stages)
+
+	pc := &plugin.PluginController{ExecutorPluginRegistry: map[string]v1.Executor{"fake": executor}}
+	var ms *MigrationSet
+	ms, err := ms.NewMigrationSet(migrationFile, pc, testLog(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+func writeTestFile(t *testing.T, path string, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStepValidationInvalidAction(t *testing.T) {
+	pc := &plugin.PluginController{ExecutorPluginRegistry: map[string]v1.Executor{"fake": &fakeExecutor{}}}
+	stands := StandsFile{Stand: []Stand{{Name: "A", Component: []Component{{Name: "app", Plugin: "fake"}}}}}
+	component := map[string]interface{}{"name": "app"}
+	action := map[string]interface{}{"command": "no cmd"}
+
+	// Ошибка GetCheck/GetAction не должна перезаписываться поиском компонентов
+	check := Check{Name: "c", PluginType: "fake", Component: component, Actions: action}
+	if _, _, err := check.CascadeValidation(check, pc, stands, true, testLog(t)); err == nil || !strings.Contains(err.Error(), "'cmd' is required") {
+		t.Errorf("Check.CascadeValidation error = %v, want 'cmd' is required", err)
+	}
+	script := Script{Name: "s", PluginType: "fake", Component: component, Actions: action}
+	if _, _, err := script.CascadeValidation(script, pc, stands, true, testLog(t)); err == nil || !strings.Contains(err.Error(), "'cmd' is required") {
+		t.Errorf("Script.CascadeValidation error = %v, want 'cmd' is required", err)
+	}
+	task := Task{Name: "t", PluginType: "fake", Component: component, Actions: action}
+	if _, _, err := task.CascadeValidation(task, pc, stands, true, testLog(t)); err == nil || !strings.Contains(err.Error(), "'cmd' is required") {
+		t.Errorf("Task.CascadeValidation error = %v, want 'cmd' is required", err)
+	}
+
+	check.Actions = map[string]interface{}{"cmd": "ok"}
+	if _, targets, err := check.CascadeValidation(check, pc, stands, true, testLog(t)); err != nil || len(targets) != 1 {
+		t.Errorf("Check.CascadeValidation = %v, %v, want one target", targets, err)
+	}
+}
+
+func TestPollCheck(t *testing.T) {
+	retries := func(n int) *int { return &n }
+	interval := func(d time.Duration) *time.Duration { return &d }
+
+	tests := []struct {
+		name         string
+		check        Check
+		failChecks   int
+		wantAttempts int
+		wantErr      string
+	}{
+		{name: "passed", check: Check{Retries: retries(2)}, wantAttempts: 1},
+		{name: "passed after retries", check: Check{Retries: retries(3), Interval: interval(time.Millisecond)}, failChecks: 2, wantAttempts: 3},
+		{name: "retries exhausted", check: Check{Retries: retries(2), Interval: interval(time.Millisecond)}, failChecks: 5, wantAttempts: 3, wantErr: "failed after 3 attempt(s): [Check > c] checkCode is False"},
+		// По умолчанию (DEFAULT_CHECK_RETRIES) проверка не повторяется
+		{name: "default retries", failChecks: 1, wantAttempts: 1, wantErr: "failed after 1 attempt(s)"},
+		// Общий лимит времени прерывает ожидание повтора
+		{name: "timeout", check: Check{Retries: retries(5), Interval: interval(time.Minute), Timeout: 50 * time.Millisecond}, failChecks: 5, wantAttempts: 1, wantErr: "timeout 50ms exceeded after 1 attempt(s)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			executor := &fakeExecutor{failChecks: map[string]int{"ready": tt.failChecks}}
+			check := tt.check
+			check.Name = "c"
+			target := componentTarget{Name: "db", Component: map[string]interface{}{}}
+
+			start := time.Now()
+			_, attempts, err := check.pollCheck(context.Background(), executor, target, map[string]interface{}{"cmd": "ready"}, NewLogger(testLog(t)))
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("pollCheck() took %s", elapsed)
+			}
+			if attempts != tt.wantAttempts || len(executor.Calls()) != tt.wantAttempts {
+				t.Errorf("pollCheck() attempts = %d, calls = %v, want %d", attempts, executor.Calls(), tt.wantAttempts)
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("pollCheck() error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("pollCheck() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPollCheckBackoff(t *testing.T) {
+	retries, interval := 3, 20*time.Millisecond
+	check := Check{Name: "c", Retries: &retries, Interval: &interval, Backoff: 2}
+	executor := &fakeExecutor{failChecks: map[string]int{"ready": 3}}
+	target := componentTarget{Name: "db", Component: map[string]interface{}{}}
+
+	if _, _, err := check.pollCheck(context.Background(), executor, target, map[string]interface{}{"cmd": "ready"}, NewLogger(testLog(t))); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пауза перед каждым следующим повтором увеличивается в backoff раз
+	gaps := executor.Gaps()
+	want := []time.Duration{20 * time.Millisecond, 40 * time.Millisecond, 80 * time.Millisecond}
+	if len(gaps) != len(want) {
+		t.Fatalf("gaps = %v, want %v", gaps, want)
+	}
+	for i := range want {
+		if gaps[i] < want[i] {
+			t.Errorf("gap %d = %s, want at least %s", i+1, gaps[i], want[i])
+		}
+	}
+
+	// Без backoff пауза не меняется
+	defaults := Check{Retries: &retries, Interval: &interval}
+	if _, gotInterval, backoff := defaults.retryPolicy(); gotInterval != interval || backoff != DEFAULT_CHECK_BACKOFF {
+		t.Errorf("retryPolicy() = %s, %v, want %s, %v", gotInterval, backoff, interval, DEFAULT_CHECK_BACKOFF)
+	}
+}
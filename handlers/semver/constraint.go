@@ -0,0 +1,140 @@
+package semver
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Constraint набор условий на версию, объединённых через И.
+// Поддерживаются операторы =, !=, >, >=, <, <=, ^ и ~; пустая строка и '*' - любая версия.
+// Условия разделяются запятой или пробелом: ">=1.2.0, <2.0.0"; оператор может быть
+// отделён от версии пробелом: ">= 1.2.0, < 2.0.0".
+type Constraint struct {
+	raw        string
+	conditions []condition
+}
+
+// operators операторы условий; более длинные проверяются раньше
+var operators = []string{">=", "<=", "!=", ">", "<", "=", "^", "~"}
+
+type condition struct {
+	op      string
+	version Version
+}
+
+// ParseConstraint разбирает строку ограничений версии
+func ParseConstraint(value string) (Constraint, error) {
+
+	constraint := Constraint{raw: strings.TrimSpace(value)}
+	if constraint.raw == "" || constraint.raw == "*" {
+		return constraint, nil
+	}
+
+	fields, err := splitConditions(constraint.raw)
+	if err != nil {
+		return Constraint{}, fmt.Errorf("invalid constraint '%s': %v", value, err)
+	}
+	for _, field := range fields {
+		op := ""
+		for _, candidate := range operators {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				break
+			}
+		}
+
+		version, err := Parse(strings.TrimPrefix(field, op))
+		if err != nil {
+			return Constraint{}, fmt.Errorf("invalid constraint '%s': %v", value, err)
+		}
+		if op == "" {
+			op = "="
+		}
+		constraint.conditions = append(constraint.conditions, condition{op: op, version: version})
+	}
+	return constraint, nil
+}
+
+// splitConditions делит строку на условия. Оператор без версии ('>=' в ">= 1.2.0")
+// присоединяется к следующему полю.
+func splitConditions(value string) ([]string, error) {
+
+	var conditions []string
+	pending := ""
+	for _, field := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if isOperator(field) {
+			if pending != "" {
+				return nil, fmt.Errorf("operator '%s' has no version", pending)
+			}
+			pending = field
+			continue
+		}
+		conditions = append(conditions, pending+field)
+		pending = ""
+	}
+	if pending != "" {
+		return nil, fmt.Errorf("operator '%s' has no version", pending)
+	}
+	return conditions, nil
+}
+
+func isOperator(field string) bool {
+	for _, op := range operators {
+		if field == op {
+			return true
+		}
+	}
+	return false
+}
+
+// Check сообщает, удовлетворяет ли версия всем условиям
+func (c Constraint) Check(version Version) bool {
+	for _, cond := range c.conditions {
+		if !cond.check(version) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c Constraint) String() string {
+	if c.raw == "" {
+		return "*"
+	}
+	return c.raw
+}
+
+func (c condition) check(version Version) bool {
+	cmp := Compare(version, c.version)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "^":
+		// Совместимые изменения: не меняется первый ненулевой компонент
+		if cmp < 0 {
+			return false
+		}
+		switch {
+		case c.version.Major > 0:
+			return version.Major == c.version.Major
+		case c.version.Minor > 0:
+			return version.Major == 0 && version.Minor == c.version.Minor
+		default:
+			return version.Major == 0 && version.Minor == 0 && version.Patch == c.version.Patch
+		}
+	case "~":
+		// Только исправления в пределах MAJOR.MINOR
+		return cmp >= 0 && version.Major == c.version.Major && version.Minor == c.version.Minor
+	}
+	return false
+}
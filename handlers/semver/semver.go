@@ -0,0 +1,130 @@
+package semver
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version семантическая версия вида [v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD]
+type Version struct {
+	Major      int
+	Minor      int
+	Patch      int
+	Prerelease string
+}
+
+// Parse разбирает строку версии. Префикс 'v' и отсутствующие MINOR/PATCH допускаются.
+func Parse(value string) (Version, error) {
+
+	s := strings.TrimPrefix(strings.TrimSpace(value), "v")
+	if s == "" {
+		return Version{}, fmt.Errorf("invalid version '%s': empty", value)
+	}
+
+	// Метаданные сборки не участвуют в сравнении
+	if i := strings.Index(s, "+"); i >= 0 {
+		s = s[:i]
+	}
+
+	var version Version
+	if i := strings.Index(s, "-"); i >= 0 {
+		version.Prerelease = s[i+1:]
+		s = s[:i]
+		if version.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid version '%s': empty prerelease", value)
+		}
+	}
+
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version '%s': too many components", value)
+	}
+	numbers := []*int{&version.Major, &version.Minor, &version.Patch}
+	for i, part := range parts {
+		number, err := strconv.Atoi(part)
+		if err != nil || number < 0 {
+			return Version{}, fmt.Errorf("invalid version '%s': '%s' is not a number", value, part)
+		}
+		*numbers[i] = number
+	}
+	return version, nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	return s
+}
+
+// Compare возвращает -1, 0 или 1, если a меньше, равна или больше b
+func Compare(a Version, b Version) int {
+	switch {
+	case a.Major != b.Major:
+		return compareInt(a.Major, b.Major)
+	case a.Minor != b.Minor:
+		return compareInt(a.Minor, b.Minor)
+	case a.Patch != b.Patch:
+		return compareInt(a.Patch, b.Patch)
+	}
+
+	// Версия без prerelease старше версии с prerelease
+	switch {
+	case a.Prerelease == b.Prerelease:
+		return 0
+	case a.Prerelease == "":
+		return 1
+	case b.Prerelease == "":
+		return -1
+	}
+	return comparePrerelease(a.Prerelease, b.Prerelease)
+}
+
+// CompareStrings сравнивает две версии, заданные строками
+func CompareStrings(a string, b string) (int, error) {
+	va, err := Parse(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := Parse(b)
+	if err != nil {
+		return 0, err
+	}
+	return Compare(va, vb), nil
+}
+
+func compareInt(a int, b int) int {
+	if a < b {
+		return -1
+	}
+	if a > b {
+		return 1
+	}
+	return 0
+}
+
+func comparePrerelease(a string, b string) int {
+	aParts := strings.Split(a, ".")
+	bParts := strings.Split(b, ".")
+	for i := 0; i < len(aParts) && i < len(bParts); i++ {
+		aNumber, aErr := strconv.Atoi(aParts[i])
+		bNumber, bErr := strconv.Atoi(bParts[i])
+		switch {
+		case aErr == nil && bErr == nil:
+			if c := compareInt(aNumber, bNumber); c != 0 {
+				return c
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		default:
+			if c := strings.Compare(aParts[i], bParts[i]); c != 0 {
+				return c
+			}
+		}
+	}
+	return compareInt(len(aParts), len(bParts))
+}
@@ -0,0 +1,170 @@
+package semver
+
+import "testing"
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    Version
+		wantErr bool
+	}{
+		{value: "1.2.3", want: Version{Major: 1, Minor: 2, Patch: 3}},
+		{value: "v1.2.3", want: Version{Major: 1, Minor: 2, Patch: 3}},
+		{value: " 1.2 ", want: Version{Major: 1, Minor: 2}},
+		{value: "2", want: Version{Major: 2}},
+		{value: "1.0.0-rc.1", want: Version{Major: 1, Prerelease: "rc.1"}},
+		{value: "1.0.0-rc.1+build.5", want: Version{Major: 1, Prerelease: "rc.1"}},
+		{value: "1.0.0+build.5", want: Version{Major: 1}},
+		{value: "", wantErr: true},
+		{value: "v", wantErr: true},
+		{value: "1.2.3.4", wantErr: true},
+		{value: "1.x", wantErr: true},
+		{value: "1.-2", wantErr: true},
+		{value: "1.0.0-", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := Parse(tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Parse(%q) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.value, err)
+			}
+			if got != tt.want {
+				t.Errorf("Parse(%q) = %+v, want %+v", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.0.0", b: "1.0.0", want: 0},
+		{a: "v1.0", b: "1.0.0", want: 0},
+		{a: "1.0.0+a", b: "1.0.0+b", want: 0},
+		{a: "1.0.0", b: "2.0.0", want: -1},
+		{a: "1.10.0", b: "1.9.0", want: 1},
+		{a: "1.0.10", b: "1.0.9", want: 1},
+		{a: "1.0.0-rc.1", b: "1.0.0", want: -1},
+		{a: "1.0.0", b: "1.0.0-rc.1", want: 1},
+		{a: "1.0.1-alpha", b: "1.0.0", want: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			got, err := CompareStrings(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("CompareStrings(%q, %q) error: %v", tt.a, tt.b, err)
+			}
+			if got != tt.want {
+				t.Errorf("CompareStrings(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := CompareStrings("1.0.0", "bad"); err == nil {
+		t.Error("CompareStrings with invalid version: want error")
+	}
+}
+
+// Порядок prerelease по SemVer 2.0.0, раздел 11
+func TestComparePrereleaseOrder(t *testing.T) {
+	ordered := []string{
+		"1.0.0-alpha",
+		"1.0.0-alpha.1",
+		"1.0.0-alpha.beta",
+		"1.0.0-beta",
+		"1.0.0-beta.2",
+		"1.0.0-beta.11",
+		"1.0.0-rc.1",
+		"1.0.0",
+	}
+
+	for i := 0; i+1 < len(ordered); i++ {
+		lower, higher := ordered[i], ordered[i+1]
+		if got, _ := CompareStrings(lower, higher); got != -1 {
+			t.Errorf("CompareStrings(%q, %q) = %d, want -1", lower, higher, got)
+		}
+		if got, _ := CompareStrings(higher, lower); got != 1 {
+			t.Errorf("CompareStrings(%q, %q) = %d, want 1", higher, lower, got)
+		}
+	}
+}
+
+func TestConstraintCheck(t *testing.T) {
+	tests := []struct {
+		constraint string
+		match      []string
+		noMatch    []string
+	}{
+		{constraint: "", match: []string{"0.0.1", "9.9.9"}},
+		{constraint: "*", match: []string{"0.0.1", "9.9.9"}},
+		{constraint: "1.2.3", match: []string{"1.2.3", "v1.2.3"}, noMatch: []string{"1.2.4"}},
+		{constraint: "=1.2.3", match: []string{"1.2.3"}, noMatch: []string{"1.2.2"}},
+		{constraint: "!=1.2.3", match: []string{"1.2.4"}, noMatch: []string{"1.2.3"}},
+		{constraint: ">1.2.3", match: []string{"1.2.4"}, noMatch: []string{"1.2.3"}},
+		{constraint: "<=1.2.3", match: []string{"1.2.3", "1.0.0"}, noMatch: []string{"1.2.4"}},
+		{constraint: ">=1.2.0, <2.0.0", match: []string{"1.2.0", "1.9.9"}, noMatch: []string{"1.1.9", "2.0.0"}},
+		{constraint: ">=1.2.0 <2.0.0", match: []string{"1.5.0"}, noMatch: []string{"2.0.0"}},
+		{constraint: ">= 1.2.0, < 2.0.0", match: []string{"1.2.0", "1.9.9"}, noMatch: []string{"1.1.9", "2.0.0"}},
+		{constraint: ">= 1.2.0 < 2.0.0", match: []string{"1.5.0"}, noMatch: []string{"2.0.0"}},
+		{constraint: "<2.0.0", match: []string{"2.0.0-rc.1"}, noMatch: []string{"2.0.0"}},
+
+		// '^': не меняется первый ненулевой компонент
+		{constraint: "^1.2.3", match: []string{"1.2.3", "1.9.0"}, noMatch: []string{"1.2.2", "2.0.0"}},
+		{constraint: "^0.2.3", match: []string{"0.2.3", "0.2.9"}, noMatch: []string{"0.2.2", "0.3.0", "1.0.0"}},
+		{constraint: "^0.0.3", match: []string{"0.0.3"}, noMatch: []string{"0.0.2", "0.0.4", "0.1.0"}},
+		{constraint: "^ 1.2.3", match: []string{"1.5.0"}, noMatch: []string{"2.0.0"}},
+
+		// '~': только исправления в пределах MAJOR.MINOR
+		{constraint: "~1.2.3", match: []string{"1.2.3", "1.2.9"}, noMatch: []string{"1.2.2", "1.3.0"}},
+		{constraint: "~0.2.3", match: []string{"0.2.3", "0.2.9"}, noMatch: []string{"0.3.0"}},
+		{constraint: "~0.0.3", match: []string{"0.0.3", "0.0.9"}, noMatch: []string{"0.0.2", "0.1.0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.constraint, func(t *testing.T) {
+			constraint, err := ParseConstraint(tt.constraint)
+			if err != nil {
+				t.Fatalf("ParseConstraint(%q) error: %v", tt.constraint, err)
+			}
+			for _, value := range tt.match {
+				if !constraint.Check(mustParse(t, value)) {
+					t.Errorf("%q does not match %s, want match", value, tt.constraint)
+				}
+			}
+			for _, value := range tt.noMatch {
+				if constraint.Check(mustParse(t, value)) {
+					t.Errorf("%q matches %s, want no match", value, tt.constraint)
+				}
+			}
+		})
+	}
+}
+
+func TestParseConstraintErrors(t *testing.T) {
+	for _, value := range []string{">=", "1.0.0 <", ">= >= 1.0.0", ">=abc", "1.0.0, ^"} {
+		t.Run(value, func(t *testing.T) {
+			if _, err := ParseConstraint(value); err == nil {
+				t.Errorf("ParseConstraint(%q): want error", value)
+			}
+		})
+	}
+}
+
+func mustParse(t *testing.T, value string) Version {
+	t.Helper()
+	version, err := Parse(value)
+	if err != nil {
+		t.Fatalf("Parse(%q) error: %v", value, err)
+	}
+	return version
+}
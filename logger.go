@@ -2,11 +2,39 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/run"
 	"github.com/sirupsen/logrus"
 )
 
+// DRY_RUN_FLAG включает префикс [DRYRUN] в логах
+var DRY_RUN_FLAG = false
+
+// Типы приёмников логов
+const (
+	LOG_SINK_CONSOLE = "console" // stderr
+	LOG_SINK_FILE    = "file"    // файл с ротацией по размеру
+	LOG_SINK_RUN     = "run"     // отдельный файл на каждый запуск миграции или патча
+)
+
+var (
+	DEFAULT_LOG_MAX_SIZE_MB = 10
+	DEFAULT_LOG_MAX_BACKUPS = 5
+	DEFAULT_RUN_LOG_DIR     = "./logs"
+
+	// LOG_SINKS приёмники, настроенные setupLogging
+	LOG_SINKS []*logSink
+	// LOG_LEVEL самый подробный уровень среди приёмников: более подробные сообщения не форматируются
+	LOG_LEVEL = DEFAULT_LOGGING_LEVEL
+)
+
 // CustomFormatter реализует интерфейс logrus.Formatter
 type CustomFormatter struct{}
 
@@ -14,75 +42,351 @@ type CustomFormatter struct{}
 func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	// Добавляем префикс [DRYRUN], если флаг установлен
 	prefix := ""
-	//if DRY_RUN_FLAG {
-	//	prefix = "[DRYRUN] "
-	//}
+	if DRY_RUN_FLAG {
+		prefix = "[DRYRUN] "
+	}
 
 	// Строковый формат, который будет выводить время, уровень и сообщение
 	return []byte(time.Now().Format("2006-01-02 15:04:05") + " " + prefix + "[" + entry.Level.String() + "] " + entry.Message + "\n"), nil
 }
 
-func setupLogging(loggingConfig LoggingConfig) {
-	/*
-		if loggingConfig == nil {
-			fmt.Println("No logging configuration provided. Using default settings.")
-			logrus.SetLevel(logrus.InfoLevel)
-			logrus.SetFormatter(&logrus.TextFormatter{})
-			return
+// nullFormatter для вывода logrus по умолчанию: записи выводят приёмники
+type nullFormatter struct{}
+
+func (f *nullFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// logSink приёмник логов со своим уровнем и форматом. Подключается к logrus как hook
+// после redactHook, поэтому в приёмники попадают уже замаскированные записи.
+type logSink struct {
+	Type      string
+	Level     logrus.Level
+	Formatter logrus.Formatter
+	Dir       string // Каталог файлов запусков для LOG_SINK_RUN
+
+	mu     sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+func (s *logSink) Levels() []logrus.Level {
+	return logrus.AllLevels[:s.Level+1]
+}
+
+func (s *logSink) Fire(entry *logrus.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Приёмник запуска пишет только между startRunLog и закрытием
+	if s.writer == nil {
+		return nil
+	}
+	data, err := s.Formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = s.writer.Write(data)
+	return err
+}
+
+// attach подключает writer к приёмнику; предыдущий закрывается
+func (s *logSink) attach(writer io.Writer, closer io.Closer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closer != nil {
+		s.closer.Close()
+	}
+	s.writer, s.closer = writer, closer
+}
+
+func (s *logSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	if s.closer != nil {
+		err = s.closer.Close()
+	}
+	s.writer, s.closer = nil, nil
+	return err
+}
+
+func setupLogging(loggingConfig LoggingConfig) error {
+
+	if loggingConfig.Level == "" {
+		loggingConfig.Level = DEFAULT_LOGGING_LEVEL
+	}
+	if loggingConfig.Formatter == "" {
+		loggingConfig.Formatter = DEFAULT_LOGGING_FORMATTER
+	}
+	// Без приёмников в конфигурации - консоль с общими уровнем и форматом
+	sinkConfigs := loggingConfig.Sinks
+	if len(sinkConfigs) == 0 {
+		sinkConfigs = []LogSinkConfig{{Type: LOG_SINK_CONSOLE}}
+	}
+
+	sinks := make([]*logSink, 0, len(sinkConfigs))
+	level := logrus.PanicLevel
+	for i, sinkConfig := range sinkConfigs {
+		sink, err := newLogSink(sinkConfig, loggingConfig)
+		if err != nil {
+			for _, opened := range sinks {
+				opened.Close()
+			}
+			return fmt.Errorf("[Logging] sink #%d: %v", i+1, err)
+		}
+		sinks = append(sinks, sink)
+		if sink.Level > level {
+			level = sink.Level
+		}
+	}
+	closeLogging()
+	LOG_SINKS = sinks
+	LOG_LEVEL = strings.ToUpper(level.String())
+
+	// Маскирование чувствительных значений: шаблоны ключей из конфигурации, поля плагинов добавляются позже.
+	// Hook маскирования регистрируется первым и выполняется до записи в приёмники.
+	LOG_REDACTOR.SetPatterns(loggingConfig.Redact)
+	logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+	logrus.AddHook(&redactHook{redactor: LOG_REDACTOR})
+	for _, sink := range sinks {
+		logrus.AddHook(sink)
+	}
+
+	// Контекстный логгер выполнения выводит поля через logrus
+	run.DEFAULT_LOG_FIELDS_FUNC = logFields
+	// Выходные значения шагов сохраняются в журнал замаскированными
+	run.DEFAULT_OUTPUTS_REDACT_FUNC = LOG_REDACTOR.Outputs
+
+	logrus.SetOutput(io.Discard)
+	logrus.SetFormatter(&nullFormatter{})
+	logrus.SetLevel(level)
+	return nil
+}
+
+// newLogSink создаёт приёмник; пустые уровень и формат берутся из общих настроек
+func newLogSink(sinkConfig LogSinkConfig, loggingConfig LoggingConfig) (*logSink, error) {
+
+	sink := &logSink{
+		Type:      sinkConfig.Type,
+		Level:     logLevel(firstNonEmpty(sinkConfig.Level, loggingConfig.Level)),
+		Formatter: logFormatter(firstNonEmpty(sinkConfig.Formatter, loggingConfig.Formatter)),
+	}
+
+	switch sinkConfig.Type {
+	case LOG_SINK_CONSOLE, "":
+		sink.Type = LOG_SINK_CONSOLE
+		sink.writer = os.Stderr
+	case LOG_SINK_FILE:
+		if sinkConfig.Path == "" {
+			return nil, fmt.Errorf("'path' is required for '%s' sink", LOG_SINK_FILE)
 		}
-	*/
-	// Устанавливаем пользовательский формат, если он указан
-	if loggingConfig.Formatter == "json" {
-		logrus.SetFormatter(&logrus.JSONFormatter{})
-	} else if loggingConfig.Formatter == "default" {
-		logrus.SetFormatter(&CustomFormatter{})
-	} else {
-		logrus.SetFormatter(&logrus.TextFormatter{})
-	}
-
-	// Устанавливаем уровень логирования
-	switch loggingConfig.Level {
-	case "DEBUG":
-		logrus.SetLevel(logrus.DebugLevel)
-	case "INFO":
-		logrus.SetLevel(logrus.InfoLevel)
-	case "ERROR":
-		logrus.SetLevel(logrus.ErrorLevel)
-	case "WARN":
-		logrus.SetLevel(logrus.WarnLevel)
+		maxSize := sinkConfig.MaxSizeMB
+		if maxSize <= 0 {
+			maxSize = DEFAULT_LOG_MAX_SIZE_MB
+		}
+		maxBackups := sinkConfig.MaxBackups
+		if maxBackups <= 0 {
+			maxBackups = DEFAULT_LOG_MAX_BACKUPS
+		}
+		file, err := openRotatingFile(sinkConfig.Path, int64(maxSize)*1024*1024, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sink.writer, sink.closer = file, file
+	case LOG_SINK_RUN:
+		sink.Dir = firstNonEmpty(sinkConfig.Path, DEFAULT_RUN_LOG_DIR)
 	default:
-		fmt.Printf("Unknown logging level '%s', defaulting to INFO\n", loggingConfig.Level)
-		logrus.SetLevel(logrus.InfoLevel)
+		return nil, fmt.Errorf("unknown sink type '%s'", sinkConfig.Type)
 	}
+	return sink, nil
 }
 
-// Функция для проверки уровня логирования
-func shouldLog(level string, logLevel string) bool {
-	switch logLevel {
+// closeLogging закрывает файлы приёмников
+func closeLogging() {
+	for _, sink := range LOG_SINKS {
+		if sink.Type != LOG_SINK_CONSOLE {
+			sink.Close()
+		}
+	}
+}
+
+// startRunLog открывает для приёмников LOG_SINK_RUN файл '<migration>_<stand>_<время>.log'.
+// Возвращаемая функция закрывает файлы запуска.
+func startRunLog(migration string, stand string) (func(), error) {
+
+	name := fmt.Sprintf("%s_%s_%s.log", logFileName(migration), logFileName(stand), time.Now().Format("20060102-150405"))
+
+	var opened []*logSink
+	closeRunLog := func() {
+		for _, sink := range opened {
+			sink.Close()
+		}
+	}
+	for _, sink := range LOG_SINKS {
+		if sink.Type != LOG_SINK_RUN {
+			continue
+		}
+		if err := os.MkdirAll(sink.Dir, 0755); err != nil {
+			closeRunLog()
+			return nil, fmt.Errorf("[Logging] run log: %v", err)
+		}
+		file, err := os.OpenFile(filepath.Join(sink.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			closeRunLog()
+			return nil, fmt.Errorf("[Logging] run log: %v", err)
+		}
+		sink.attach(file, file)
+		opened = append(opened, sink)
+	}
+	return closeRunLog, nil
+}
+
+var logFileNameRegexp = regexp.MustCompile(`[^\w.-]+`)
+
+// logFileName приводит имя миграции или стенда к безопасной части имени файла
+func logFileName(name string) string {
+	name = strings.Trim(logFileNameRegexp.ReplaceAllString(name, "_"), "_.")
+	if name == "" {
+		return "all"
+	}
+	return name
+}
+
+// logLevel переводит уровень из конфигурации в уровень logrus
+func logLevel(level string) logrus.Level {
+	if parsed, ok := parseLogLevel(level); ok {
+		return parsed
+	}
+	fmt.Printf("Unknown logging level '%s', defaulting to INFO\n", level)
+	return logrus.InfoLevel
+}
+
+func parseLogLevel(level string) (logrus.Level, bool) {
+	switch strings.ToUpper(level) {
+	case "TRACE":
+		return logrus.TraceLevel, true
 	case "DEBUG":
-		return true
+		return logrus.DebugLevel, true
 	case "INFO":
-		return level == "INFO" || level == "ERROR"
+		return logrus.InfoLevel, true
+	case "WARN", "WARNING":
+		return logrus.WarnLevel, true
 	case "ERROR":
-		return level == "ERROR"
-	default:
-		return false
+		return logrus.ErrorLevel, true
+	}
+	return logrus.InfoLevel, false
+}
+
+// logFormatter формат приёмника: default, json или text
+func logFormatter(formatter string) logrus.Formatter {
+	switch formatter {
+	case "json":
+		return &logrus.JSONFormatter{}
+	case "default":
+		return &CustomFormatter{}
 	}
+	return &logrus.TextFormatter{}
 }
 
-// Функция для вывода сообщений в консоль в зависимости от уровня логирования
+// rotatingFile файл лога с ротацией по размеру: path -> path.1 -> path.2 ... path.<maxBackups>
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	r := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file, r.size = file, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	// Самая старая копия удаляется, остальные сдвигаются; отсутствующие копии пропускаются
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	return r.file.Close()
+}
+
+// shouldLog проверяет, выводится ли сообщение level при настроенном уровне logLevel
+func shouldLog(level string, logLevel string) bool {
+	messageLevel, _ := parseLogLevel(level)
+	configuredLevel, ok := parseLogLevel(logLevel)
+	if !ok {
+		configuredLevel = logrus.InfoLevel
+	}
+	return messageLevel <= configuredLevel
+}
+
+// Функция для вывода сообщений в консоль в зависимости от уровня логирования.
+// Неизвестный уровень выводится как INFO.
 func logMessage(level string, format string, args ...interface{}) {
-	if shouldLog(level, level) {
-		// Форматируем строку с помощью fmt.Sprintf
-		message := fmt.Sprintf(format, args...)
-
-		switch level {
-		case "DEBUG":
-			logrus.Debug(message)
-		case "INFO":
-			logrus.Info(message)
-		case "ERROR":
-			logrus.Error(message)
+	logFields(level, nil, format, args...)
+}
+
+// logFields выводит сообщение с полями контекста выполнения (run.Logger):
+// JSONFormatter и TextFormatter выводят их отдельными ключами
+func logFields(level string, fields map[string]interface{}, format string, args ...interface{}) {
+	if shouldLog(level, LOG_LEVEL) {
+		// Форматируем строку с помощью fmt.Sprintf; карты и структуры в аргументах маскируются до форматирования
+		message := fmt.Sprintf(format, LOG_REDACTOR.Args(args)...)
+
+		messageLevel, _ := parseLogLevel(level)
+		logrus.WithFields(logrus.Fields(fields)).Log(messageLevel, message)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
 		}
 	}
+	return ""
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "roller.log")
+	file, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Каждая запись 6 байт: в файл 10 байт помещается одна, следующая ротирует файл
+	for i := 1; i <= 5; i++ {
+		if _, err := fmt.Fprintf(file, "line%d\n", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Хранится не более maxBackups копий, самые старые удалены
+	want := map[string]string{
+		path:        "line5\n",
+		path + ".1": "line4\n",
+		path + ".2": "line3\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 backups", path)
+	}
+}
+
+func TestRotatingFileReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "roller.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	// Размер существующего файла учитывается, первая же запись сверх лимита ротирует его
+	file, err := openRotatingFile(path, 16, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write([]byte("new run\n")); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if data, _ := os.ReadFile(path + ".1"); string(data) != "previous run\n" {
+		t.Errorf("backup = %q, want the previous run", data)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new run\n" {
+		t.Errorf("log = %q, want the new run", data)
+	}
+
+	// Запись больше лимита в пустой файл не ротирует его
+	file, err = openRotatingFile(filepath.Join(t.TempDir(), "big.log"), 4, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write([]byte("longer than limit\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(file.path + ".1"); !os.IsNotExist(err) {
+		t.Error("empty file was rotated")
+	}
+}
+
+func TestShouldLog(t *testing.T) {
+	tests := []struct {
+		level    string
+		logLevel string
+		want     bool
+	}{
+		{level: "ERROR", logLevel: "INFO", want: true},
+		{level: "WARN", logLevel: "INFO", want: true},
+		{level: "WARNING", logLevel: "WARN", want: true},
+		{level: "INFO", logLevel: "WARN", want: false},
+		{level: "WARN", logLevel: "ERROR", want: false},
+		{level: "DEBUG", logLevel: "INFO", want: false},
+		{level: "DEBUG", logLevel: "DEBUG", want: true},
+		{level: "TRACE", logLevel: "DEBUG", want: false},
+		{level: "TRACE", logLevel: "TRACE", want: true},
+		{level: "info", logLevel: "debug", want: true},
+		// Неизвестный уровень сообщения выводится как INFO
+		{level: "NOTICE", logLevel: "INFO", want: true},
+		{level: "NOTICE", logLevel: "WARN", want: false},
+		// Неизвестный настроенный уровень - INFO
+		{level: "DEBUG", logLevel: "VERBOSE", want: false},
+		{level: "INFO", logLevel: "", want: true},
+	}
+
+	for _, tt := range tests {
+		if got := shouldLog(tt.level, tt.logLevel); got != tt.want {
+			t.Errorf("shouldLog(%q, %q) = %v, want %v", tt.level, tt.logLevel, got, tt.want)
+		}
+	}
+}
+
+func TestLogSinkLevels(t *testing.T) {
+	var out strings.Builder
+	sink := &logSink{Level: logrus.WarnLevel, Formatter: &CustomFormatter{}, writer: &out}
+
+	// logrus вызывает hook только для уровней из Levels()
+	for _, level := range sink.Levels() {
+		if level > logrus.WarnLevel {
+			t.Errorf("Levels() contains %s for WARN sink", level)
+		}
+	}
+	if err := sink.Fire(&logrus.Entry{Level: logrus.WarnLevel, Message: "disk is almost full"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[warning] disk is almost full") {
+		t.Errorf("sink output = %q", out.String())
+	}
+
+	// Закрытый приёмник не пишет
+	sink.Close()
+	if err := sink.Fire(&logrus.Entry{Level: logrus.ErrorLevel, Message: "lost"}); err != nil || strings.Contains(out.String(), "lost") {
+		t.Errorf("closed sink wrote %q, %v", out.String(), err)
+	}
+}
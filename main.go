@@ -6,6 +6,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/Ilya-Guyduk/RoLLeR/handlers/inits"
 	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
@@ -36,8 +38,10 @@ var (
 var (
 	DEFAULT_CONFIG_PATH    = "./config.yml"
 	DEFAULT_MIGRATION_PATH = "./migration.yml"
+	DEFAULT_PATCH_PATH     = "./patch.yml"
 	DEFAULT_PLUGIN_DIR     = "./plugins"
 	DEFAULT_REPO_DIR       = "./repos"
+	DEFAULT_JOURNAL_DIR    = run.DEFAULT_JOURNAL_DIR
 	DEFAULT_REPO           = "https://github.com/Ilya-Guyduk/RoLLeRHub/raw/main/index.json"
 )
 
@@ -63,75 +67,358 @@ func initerCommandParser(args []string) error {
 	return nil
 }
 
-func runnerCommandParser(args []string) error {
+func runnerCommandParser(args []string) (err error) {
 
 	if len(args) < 1 {
 		log.Fatal("Please specify a plugin command (e.g., install, search)")
 	}
 
 	// Инициализация флагов
-	runCmd, migrationPath, pluginsPath, config := setupRunnerFlags()
+	runCmd, flags := setupRunnerFlags()
 	if err := runCmd.Parse(args); err != nil {
 		return fmt.Errorf(`Error parsing flags: %w`, err)
 	}
 
+	DRY_RUN_FLAG = *flags.DryRun
+
 	// Вызов логотипа
 	fmt.Printf(MainBanner)
-	rollerConfig, err := initConfig(*config)
+	rollerConfig, err := initConfig(*flags.Config)
 	if err != nil {
 		return err
 	}
 
-	setupLogging(rollerConfig.Global.Logging)
+	if logErr := setupLogging(rollerConfig.Global.Logging); logErr != nil {
+		return logErr
+	}
+	setupSecrets(rollerConfig.Global.Secrets)
 
 	logMessage("INFO", "RoLLeR Starting...")
 
-	pc := &plugin.PluginController{}
+	pluginConfig, configErr := rollerConfig.PluginConfig()
+	if configErr != nil {
+		return configErr
+	}
+	pc := &plugin.PluginController{PluginConfig: pluginConfig, OnPluginLoad: redactPluginFields}
 	logMessage("DEBUG", "[PluginController] Creating PluginController")
-	pc, pluginErr := pc.NewPluginController(*pluginsPath, DEFAULT_REPO_DIR, DEFAULT_REPO)
+	pc, pluginErr := pc.NewPluginController(*flags.PluginsPath, DEFAULT_REPO_DIR, DEFAULT_REPO)
+	if pluginErr == nil {
+		pluginErr = pc.SetVerification(rollerConfig.Global.Plugin.TrustedKeys, rollerConfig.Global.Plugin.AllowUnverified)
+	}
+	if pluginErr == nil && rollerConfig.Global.Plugin.LockFile != "" {
+		pc.LockFile = rollerConfig.Global.Plugin.LockFile
+	}
 	if pluginErr != nil {
-		logMessage("ERROR", "%s", pluginErr)
-		return nil
+		return pluginErr
 	} else {
 		logMessage("DEBUG", fmt.Sprintf("[PluginController] Version: %s, DefaultRepository: %s, LocalRepositoryPath: %s", pc.ControllerVersion, pc.DefaultRepository, pc.LocalRepositoryPath))
 	}
+	defer pc.Close()
+
+	// Цепочка миграций от текущего релиза стендов до --to
+	if *flags.ToRelease != "" {
+		return runMigrationChain(pc, flags)
+	}
 
 	var migrationSet *run.MigrationSet
 	// Инициализация MigrationSet
-	logMessage("INFO", fmt.Sprintf("Creating MigrationSet: %s", migrationPath))
-	migrationSet, migrationErr := migrationSet.NewMigrationSet(*migrationPath, pc, logMessage)
+	logMessage("INFO", fmt.Sprintf("Creating MigrationSet: %s", *flags.MigrationPath))
+	migrationSet, migrationErr := migrationSet.NewMigrationSet(*flags.MigrationPath, pc, logMessage)
 	if migrationErr != nil {
-		logMessage("ERROR", "%s", migrationErr)
-		return nil
+		return migrationErr
+	}
+	if setupErr := setupMigrationSet(migrationSet, flags); setupErr != nil {
+		return setupErr
+	}
+	migrationName := strings.TrimSuffix(filepath.Base(migrationSet.MigrationFile), filepath.Ext(migrationSet.MigrationFile))
+	closeRunLog, runLogErr := startRunLog(migrationName, *flags.Stand)
+	if runLogErr != nil {
+		return runLogErr
 	}
+	defer closeRunLog()
+
+	// Отчёт начинается до валидации и записывается при любом исходе запуска
+	migrationSet.Report.Begin(migrationSet)
+	defer func() {
+		migrationSet.Report.End(err)
+		writeRunReports(flags, migrationSet.Report)
+	}()
 
 	// Каскадная валидация миграции
 	logMessage("INFO", fmt.Sprintf("Start cascade validation"))
-	validErr := migrationSet.CascadeValidation(*migrationSet, logMessage)
+	validErr := migrationSet.CascadeValidation(*migrationSet, !*flags.DryRun, logMessage)
 	if validErr != nil {
-		logMessage("ERROR", "%s", validErr)
-		return nil
+		return validErr
 	} else {
 		logMessage("INFO", "[MigrationSet]>[Valid] Cascade validation finish!")
 	}
 
+	// В режиме dry-run выводим план выполнения без вызова плагинов
+	if *flags.DryRun {
+		plan, planErr := migrationSet.Plan(logMessage)
+		if planErr != nil {
+			return planErr
+		}
+		return run.WritePlan(os.Stdout, migrationSet, plan)
+	}
+
+	// Журнал выполнения: при --resume пропускаются успешно выполненные шаги
+	if journalErr := migrationSet.OpenJournal(*flags.JournalPath, *flags.Resume, logMessage); journalErr != nil {
+		return journalErr
+	}
+
 	logMessage("INFO", fmt.Sprintf("Starting UpdateRelease"))
 	updateErr := migrationSet.UpdateRelease(migrationSet, logMessage)
 	if updateErr != nil {
-		logMessage("ERROR", fmt.Sprintf("Error Update: %s", updateErr))
+		return fmt.Errorf("Error Update: %w", updateErr)
 	}
 
 	defer logMessage("INFO", "RoLLer runner finished")
 	return nil
 }
 
+// setupMigrationSet применяет к миграции параметры запуска из флагов
+func setupMigrationSet(migrationSet *run.MigrationSet, flags *runnerFlags) error {
+
+	migrationSet.SetExecThreads(*flags.Threads)
+	migrationSet.AllowDowngrade = *flags.AllowDowngrade
+	migrationSet.Force = *flags.Force
+
+	// Ограничиваем поиск компонентов выбранным стендом
+	if *flags.Stand != "" {
+		if standErr := migrationSet.StandsFile.SelectStand(*flags.Stand); standErr != nil {
+			return standErr
+		}
+		logMessage("INFO", fmt.Sprintf("Target stand: %s", *flags.Stand))
+	}
+	return nil
+}
+
+// runMigrationChain выполняет цепочку миграций из --migrations-dir до релиза --to
+func runMigrationChain(pc *plugin.PluginController, flags *runnerFlags) error {
+
+	var chain *run.MigrationChain
+	logMessage("INFO", fmt.Sprintf("Creating MigrationChain: %s => %s", *flags.MigrationsDir, *flags.ToRelease))
+	chain, chainErr := chain.NewMigrationChain(*flags.MigrationsDir, *flags.ToRelease, *flags.Stand, pc, logMessage)
+	if chainErr != nil {
+		return chainErr
+	}
+	if len(chain.Migrations) == 0 {
+		logMessage("INFO", fmt.Sprintf("Stands are already on release '%s'", *flags.ToRelease))
+		return nil
+	}
+	for _, migrationSet := range chain.Migrations {
+		if setupErr := setupMigrationSet(migrationSet, flags); setupErr != nil {
+			return setupErr
+		}
+	}
+	closeRunLog, runLogErr := startRunLog(chain.FromRelease+"-"+chain.ToRelease, *flags.Stand)
+	if runLogErr != nil {
+		return runLogErr
+	}
+	defer closeRunLog()
+
+	// Отчёты начатых миграций записываются при любом исходе, включая ошибку валидации
+	defer func() {
+		writeRunReports(flags, chain.Reports()...)
+	}()
+
+	// Все миграции цепочки проверяются до выполнения первой
+	logMessage("INFO", fmt.Sprintf("Start cascade validation"))
+	if validErr := chain.CascadeValidation(!*flags.DryRun, logMessage); validErr != nil {
+		return validErr
+	}
+	logMessage("INFO", "[MigrationChain]>[Valid] Cascade validation finish!")
+
+	if *flags.DryRun {
+		for _, migrationSet := range chain.Migrations {
+			plan, planErr := migrationSet.Plan(logMessage)
+			if planErr != nil {
+				return planErr
+			}
+			if writeErr := run.WritePlan(os.Stdout, migrationSet, plan); writeErr != nil {
+				return writeErr
+			}
+		}
+		return nil
+	}
+
+	logMessage("INFO", fmt.Sprintf("Starting UpdateRelease: %s => %s", chain.FromRelease, chain.ToRelease))
+	updateErr := chain.UpdateRelease(*flags.JournalPath, *flags.Resume, logMessage)
+	if updateErr != nil {
+		return fmt.Errorf("Error Update: %w", updateErr)
+	}
+
+	defer logMessage("INFO", "RoLLer runner finished")
+	return nil
+}
+
+// patchCommandParser применяет хотфикс (PatchSet) без изменения релиза стендов
+func patchCommandParser(args []string) (err error) {
+
+	patchCmd, flags := setupPatchFlags()
+	if err := patchCmd.Parse(args); err != nil {
+		return fmt.Errorf(`Error parsing flags: %w`, err)
+	}
+
+	DRY_RUN_FLAG = *flags.DryRun
+
+	fmt.Printf(MainBanner)
+	rollerConfig, err := initConfig(*flags.Config)
+	if err != nil {
+		return err
+	}
+
+	if logErr := setupLogging(rollerConfig.Global.Logging); logErr != nil {
+		return logErr
+	}
+	setupSecrets(rollerConfig.Global.Secrets)
+
+	logMessage("INFO", "RoLLeR Patch Starting...")
+
+	pluginConfig, configErr := rollerConfig.PluginConfig()
+	if configErr != nil {
+		return configErr
+	}
+	pc := &plugin.PluginController{PluginConfig: pluginConfig, OnPluginLoad: redactPluginFields}
+	pc, pluginErr := pc.NewPluginController(*flags.PluginsPath, DEFAULT_REPO_DIR, DEFAULT_REPO)
+	if pluginErr == nil {
+		pluginErr = pc.SetVerification(rollerConfig.Global.Plugin.TrustedKeys, rollerConfig.Global.Plugin.AllowUnverified)
+	}
+	if pluginErr == nil && rollerConfig.Global.Plugin.LockFile != "" {
+		pc.LockFile = rollerConfig.Global.Plugin.LockFile
+	}
+	if pluginErr != nil {
+		return pluginErr
+	}
+	defer pc.Close()
+
+	var patchSet *run.PatchSet
+	logMessage("INFO", fmt.Sprintf("Creating PatchSet: %s", *flags.PatchPath))
+	patchSet, patchErr := patchSet.NewPatchSet(*flags.PatchPath, pc, logMessage)
+	if patchErr != nil {
+		return patchErr
+	}
+	if setupErr := setupMigrationSet(patchSet.MigrationSet, flags); setupErr != nil {
+		return setupErr
+	}
+	closeRunLog, runLogErr := startRunLog(patchSet.Name, *flags.Stand)
+	if runLogErr != nil {
+		return runLogErr
+	}
+	defer closeRunLog()
+
+	// Отчёт начинается до валидации и записывается при любом исходе запуска
+	patchSet.Report.Begin(patchSet.MigrationSet)
+	defer func() {
+		patchSet.Report.End(err)
+		writeRunReports(flags, patchSet.Report)
+	}()
+
+	logMessage("INFO", fmt.Sprintf("Start cascade validation"))
+	if validErr := patchSet.CascadeValidation(*patchSet, !*flags.DryRun, logMessage); validErr != nil {
+		return validErr
+	}
+	logMessage("INFO", "[PatchSet]>[Valid] Cascade validation finish!")
+
+	if *flags.DryRun {
+		plan, planErr := patchSet.Plan(logMessage)
+		if planErr != nil {
+			return planErr
+		}
+		return run.WritePlan(os.Stdout, patchSet.MigrationSet, plan)
+	}
+
+	if journalErr := patchSet.OpenJournal(*flags.JournalPath, *flags.Resume, logMessage); journalErr != nil {
+		return journalErr
+	}
+
+	logMessage("INFO", fmt.Sprintf("Applying patch '%s' on release '%s'", patchSet.Name, patchSet.ToRelease))
+	applyErr := patchSet.Apply(patchSet, logMessage)
+	if applyErr != nil {
+		return fmt.Errorf("Error Patch: %w", applyErr)
+	}
+
+	defer logMessage("INFO", "RoLLer patch finished")
+	return nil
+}
+
+// writeRunReports записывает отчёты о выполнении в файлы --report (JSON) и --junit (JUnit XML)
+func writeRunReports(flags *runnerFlags, reports ...*run.Report) {
+
+	if *flags.ReportPath == "" && *flags.JUnitPath == "" {
+		return
+	}
+	if reportErr := run.WriteReportFiles(*flags.ReportPath, *flags.JUnitPath, reports...); reportErr != nil {
+		logMessage("ERROR", "%s", reportErr)
+		return
+	}
+	for _, path := range []string{*flags.ReportPath, *flags.JUnitPath} {
+		if path != "" {
+			logMessage("INFO", fmt.Sprintf("Report saved to '%s'", path))
+		}
+	}
+}
+
+// setupPatchFlags инициализирует флаги подкоманды 'patch'
+func setupPatchFlags() (*flag.FlagSet, *runnerFlags) {
+	patchCmd := flag.NewFlagSet("patch", flag.ExitOnError)
+	flags := &runnerFlags{
+		PatchPath:      patchCmd.String("patch", DEFAULT_PATCH_PATH, "Path to the YAML patch file"),
+		PluginsPath:    patchCmd.String("pluginsPath", DEFAULT_PLUGIN_DIR, "Path to the plugins directory"),
+		Config:         patchCmd.String("config", DEFAULT_CONFIG_PATH, "plugin to install"),
+		JournalPath:    patchCmd.String("journal", DEFAULT_JOURNAL_DIR, "Path to the execution journal directory"),
+		Resume:         patchCmd.Bool("resume", false, "Skip steps that already succeeded for the same patch and stand"),
+		Threads:        patchCmd.Int("threads", run.DEFAULT_MS_EXEC_THREADS, "Number of independent stages executed in parallel"),
+		DryRun:         patchCmd.Bool("dry-run", false, "Validate the patch and print the execution plan without running it"),
+		Stand:          patchCmd.String("stand", "", "Name of the stand to patch; component selectors resolve only inside it"),
+		AllowDowngrade: patchCmd.Bool("allow-downgrade", false, "Allow tasks to set a component 'to_version' lower than its current version"),
+		Force:          patchCmd.Bool("force", false, "Apply even if the stands release is outside 'releases' or the patch was already applied"),
+		ReportPath:     patchCmd.String("report", "", "Write a JSON report of every stage and step to this file"),
+		JUnitPath:      patchCmd.String("junit", "", "Write a JUnit XML report of every stage and step to this file"),
+	}
+	return patchCmd, flags
+}
+
+// runnerFlags флаги подкоманды 'run'
+type runnerFlags struct {
+	MigrationPath  *string
+	PluginsPath    *string
+	Config         *string
+	JournalPath    *string
+	Resume         *bool
+	Threads        *int
+	DryRun         *bool
+	Stand          *string
+	AllowDowngrade *bool
+	Force          *bool
+	ToRelease      *string
+	MigrationsDir  *string
+	PatchPath      *string
+	ReportPath     *string
+	JUnitPath      *string
+}
+
 // setupFlags инициализирует флаги командной строки
-func setupRunnerFlags() (*flag.FlagSet, *string, *string, *string) {
+func setupRunnerFlags() (*flag.FlagSet, *runnerFlags) {
 	runCmd := flag.NewFlagSet("run", flag.ExitOnError)
-	migrationPath := runCmd.String("migration", DEFAULT_MIGRATION_PATH, "Path to the YAML migration file")
-	pluginsPath := runCmd.String("pluginsPath", DEFAULT_PLUGIN_DIR, "Path to the plugins directory")
-	config := runCmd.String("config", DEFAULT_CONFIG_PATH, "plugin to install")
-	return runCmd, migrationPath, pluginsPath, config
+	flags := &runnerFlags{
+		MigrationPath:  runCmd.String("migration", DEFAULT_MIGRATION_PATH, "Path to the YAML migration file"),
+		PluginsPath:    runCmd.String("pluginsPath", DEFAULT_PLUGIN_DIR, "Path to the plugins directory"),
+		Config:         runCmd.String("config", DEFAULT_CONFIG_PATH, "plugin to install"),
+		JournalPath:    runCmd.String("journal", DEFAULT_JOURNAL_DIR, "Path to the execution journal directory"),
+		Resume:         runCmd.Bool("resume", false, "Skip steps that already succeeded for the same migration and stand"),
+		Threads:        runCmd.Int("threads", run.DEFAULT_MS_EXEC_THREADS, "Number of independent stages executed in parallel"),
+		DryRun:         runCmd.Bool("dry-run", false, "Validate the migration and print the execution plan without running it"),
+		Stand:          runCmd.String("stand", "", "Name of the stand to run against; component selectors resolve only inside it"),
+		AllowDowngrade: runCmd.Bool("allow-downgrade", false, "Allow tasks to set a component 'to_version' lower than its current version"),
+		Force:          runCmd.Bool("force", false, "Run even if the stands release does not match 'from_release' or the migration does not advance the release"),
+		ToRelease:      runCmd.String("to", "", "Target release: run the shortest chain of migrations from --migrations-dir"),
+		MigrationsDir:  runCmd.String("migrations-dir", run.DEFAULT_MIGRATIONS_DIR, "Directory with one migration file per release hop (used with --to)"),
+		ReportPath:     runCmd.String("report", "", "Write a JSON report of every stage and step to this file"),
+		JUnitPath:      runCmd.String("junit", "", "Write a JUnit XML report of every stage and step to this file"),
+	}
+	return runCmd, flags
 }
 
 func pluginCommandParser(args []string) error {
@@ -142,15 +429,24 @@ func pluginCommandParser(args []string) error {
 	installCmd := flag.NewFlagSet("plugin", flag.ExitOnError)
 	pluginName := installCmd.String("plugin", "", "plugin to install")
 	config := installCmd.String("config", DEFAULT_CONFIG_PATH, "plugin to install")
+	migrationPath := installCmd.String("migration", DEFAULT_MIGRATION_PATH, "Migration file checked for plugin references on delete")
+	force := installCmd.Bool("force", false, "Delete the plugin even if it is still referenced")
+	update := installCmd.Bool("update", false, "Install the newest matching versions, ignoring the lock file")
 
-	// Разбор флагов
-	if err := installCmd.Parse(args); err != nil {
+	// Разбор флагов после подкоманды
+	if err := installCmd.Parse(args[1:]); err != nil {
 		fmt.Printf("Error parsing flags: %v\n", err)
 		os.Exit(1)
 	}
 
+	// Имя плагина можно передать флагом --plugin или позиционным аргументом
 	if *pluginName == "" {
-		fmt.Println("Please specify a plugin to search using --plugin flag")
+		*pluginName = installCmd.Arg(0)
+	}
+
+	// Без имени 'install' устанавливает плагины из lock-файла
+	if *pluginName == "" && args[0] != "list" && args[0] != "install" {
+		fmt.Println("Please specify a plugin using --plugin flag or as an argument")
 		os.Exit(1)
 	}
 
@@ -160,22 +456,46 @@ func pluginCommandParser(args []string) error {
 		return err
 	}
 
-	setupLogging(rollerConfig.Global.Logging)
+	if logErr := setupLogging(rollerConfig.Global.Logging); logErr != nil {
+		return logErr
+	}
+	setupSecrets(rollerConfig.Global.Secrets)
 
 	logMessage("INFO", "RoLLeR PluginController")
 
-	pc := &plugin.PluginController{}
+	pluginConfig, configErr := rollerConfig.PluginConfig()
+	if configErr != nil {
+		return configErr
+	}
+	pc := &plugin.PluginController{PluginConfig: pluginConfig, OnPluginLoad: redactPluginFields}
 	pc, pluginErr := pc.NewPluginController(rollerConfig.Global.Plugin.PluginPath, rollerConfig.Global.Plugin.PluginRepoPath, DEFAULT_REPO)
 	if pluginErr != nil {
 		return pluginErr
 	}
+	if verifyErr := pc.SetVerification(rollerConfig.Global.Plugin.TrustedKeys, rollerConfig.Global.Plugin.AllowUnverified); verifyErr != nil {
+		return verifyErr
+	}
+	if rollerConfig.Global.Plugin.LockFile != "" {
+		pc.LockFile = rollerConfig.Global.Plugin.LockFile
+	}
+	defer pc.Close()
 
 	switch args[0] {
 	case "install":
-		fmt.Printf("INFO: Installing plugin: %s\n", *pluginName)
-		installErr := pc.InstallPlugin(*pluginName)
+		var installErr error
+		switch {
+		case *pluginName == "":
+			fmt.Printf("INFO: Installing plugins from lock file: %s\n", pc.LockFile)
+			installErr = pc.InstallLocked()
+		case *update:
+			fmt.Printf("INFO: Updating plugin: %s\n", *pluginName)
+			installErr = pc.UpdatePlugin(*pluginName)
+		default:
+			fmt.Printf("INFO: Installing plugin: %s\n", *pluginName)
+			installErr = pc.InstallPlugin(*pluginName)
+		}
 		if installErr != nil {
-			fmt.Println(installErr)
+			return installErr
 		}
 
 	case "search":
@@ -184,7 +504,7 @@ func pluginCommandParser(args []string) error {
 
 		PluginName, pluginVersion, pluginDescription, pluginURL, searchErr := pc.SearchPlugin(*pluginName, rollerConfig.Global.Plugin.DefaultRepo)
 		if searchErr != nil {
-			logMessage("ERROR", "%s", searchErr)
+			return searchErr
 		} else {
 			fmt.Printf("\nPlugin Found:\n")
 			fmt.Printf("  Name: %s\n", PluginName)
@@ -192,7 +512,71 @@ func pluginCommandParser(args []string) error {
 			fmt.Printf("  Description: %s\n", pluginDescription)
 			fmt.Printf("  URL: %s\n", pluginURL)
 		}
+	case "list":
+		plugins, listErr := pc.ListPlugins()
+		if listErr != nil {
+			return listErr
+		}
+		fmt.Printf("\nInstalled plugins (%s):\n", pc.PluginPath)
+		if len(plugins) == 0 {
+			fmt.Printf("  None\n")
+		}
+		for _, installed := range plugins {
+			if installed.LoadError != nil {
+				fmt.Printf("  %-24s %-10s %s (%v)\n", "-", "-", installed.File, installed.LoadError)
+				continue
+			}
+			if installed.Builtin {
+				fmt.Printf("  %-24s %-10s %s\n", installed.Name, installed.Version, "(built-in)")
+				continue
+			}
+			fmt.Printf("  %-24s %-10s %s\n", installed.Name, installed.Version, installed.File)
+		}
+
+	case "info":
+		installed, infoErr := pc.GetPluginInfo(*pluginName)
+		if infoErr != nil {
+			return infoErr
+		}
+		fmt.Printf("\nPlugin Installed:\n")
+		fmt.Printf("  Name: %s\n", installed.Name)
+		fmt.Printf("  Version: %s\n", installed.Version)
+		fmt.Printf("  Description: %s\n", installed.Description)
+		if installed.Builtin {
+			fmt.Printf("  File: (built-in)\n")
+		} else {
+			fmt.Printf("  File: %s\n", installed.File)
+		}
+		if installed.LoadError != nil {
+			fmt.Printf("  Error: %v\n", installed.LoadError)
+		}
+
 	case "delete":
+		installed, infoErr := pc.GetPluginInfo(*pluginName)
+		if infoErr != nil {
+			return infoErr
+		}
+
+		// Плагин, используемый миграцией или стендами, удаляется только с --force
+		if !*force {
+			refs, refErr := pluginReferences(*migrationPath, *pluginName, installed)
+			if refErr != nil {
+				return refErr
+			}
+			if len(refs) > 0 {
+				fmt.Printf("Plugin %s is still referenced by %s:\n", *pluginName, *migrationPath)
+				for _, ref := range refs {
+					fmt.Printf("  %s\n", ref)
+				}
+				fmt.Println("Use --force to delete it anyway")
+				return fmt.Errorf("plugin %s is still referenced", *pluginName)
+			}
+		}
+
+		fmt.Printf("INFO: Deleting plugin: %s (%s)\n", *pluginName, installed.File)
+		if deleteErr := pc.DeletePlugin(*pluginName); deleteErr != nil {
+			return deleteErr
+		}
 
 	default:
 		fmt.Printf("Unknown command: %s\n", args[0])
@@ -201,6 +585,84 @@ func pluginCommandParser(args []string) error {
 	return nil
 }
 
+// setupSecrets задаёт ключ для значений '${enc:...}' в конфигурации компонентов
+func setupSecrets(secretsConfig SecretsConfig) {
+	if secretsConfig.KeyFile != "" {
+		run.DEFAULT_SECRET_KEY_FILE = secretsConfig.KeyFile
+	}
+}
+
+// secretCommandParser создаёт ключ и шифрует значения для ссылок '${enc:...}'
+func secretCommandParser(args []string) error {
+	if len(args) < 1 {
+		return fmt.Errorf("Please specify a secret command (keygen, encrypt)")
+	}
+
+	secretCmd := flag.NewFlagSet("secret", flag.ExitOnError)
+	config := secretCmd.String("config", DEFAULT_CONFIG_PATH, "Path to the roller config")
+	keyFile := secretCmd.String("key", "", "Path to the AES-256 key file (default: global.secrets.key_file)")
+	value := secretCmd.String("value", "", "Value to encrypt; read from stdin if empty")
+	if err := secretCmd.Parse(args[1:]); err != nil {
+		return fmt.Errorf(`Error parsing flags: %w`, err)
+	}
+
+	// Ключ из конфигурации используется, если он не указан флагом
+	if *keyFile == "" {
+		if rollerConfig, err := initConfig(*config); err == nil {
+			setupSecrets(rollerConfig.Global.Secrets)
+		}
+		*keyFile = run.DEFAULT_SECRET_KEY_FILE
+	}
+
+	switch args[0] {
+	case "keygen":
+		if err := run.GenerateSecretKey(*keyFile); err != nil {
+			return err
+		}
+		fmt.Printf("INFO: Key saved to %s\n", *keyFile)
+
+	case "encrypt":
+		plaintext := *value
+		if plaintext == "" {
+			data, err := ioutil.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			plaintext = strings.TrimRight(string(data), "\r\n")
+		}
+		encrypted, err := run.EncryptSecret(*keyFile, plaintext)
+		if err != nil {
+			return err
+		}
+		fmt.Println(encrypted)
+
+	default:
+		return fmt.Errorf("Unknown secret command: %s", args[0])
+	}
+	return nil
+}
+
+// pluginReferences возвращает места использования плагина в миграции и её стендах.
+// Плагин ищется по переданному имени и по имени из реестра.
+func pluginReferences(migrationPath string, pluginName string, installed plugin.InstalledPlugin) ([]string, error) {
+	if _, err := os.Stat(migrationPath); os.IsNotExist(err) {
+		logMessage("INFO", fmt.Sprintf("Migration file %s not found, skip reference check", migrationPath))
+		return nil, nil
+	}
+
+	refs, err := run.ReferencedPlugins(migrationPath, logMessage)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []string
+	found = append(found, refs[pluginName]...)
+	if installed.Name != "" && installed.Name != pluginName {
+		found = append(found, refs[installed.Name]...)
+	}
+	return found, nil
+}
+
 func main() {
 
 	// Проверка наличия подкоманды
@@ -209,14 +671,23 @@ func main() {
 		os.Exit(1)
 	}
 	// Обработка подкоманды
+	var err error
 	switch os.Args[1] {
 	case "run":
-		runnerCommandParser(
+		err = runnerCommandParser(
+			os.Args[2:],
+		)
+	case "patch":
+		err = patchCommandParser(
 			os.Args[2:],
 		)
 	case "plugin":
 
-		pluginCommandParser(
+		err = pluginCommandParser(
+			os.Args[2:],
+		)
+	case "secret":
+		err = secretCommandParser(
 			os.Args[2:],
 		)
 	case "init":
@@ -224,7 +695,13 @@ func main() {
 			os.Args[2:],
 		)
 	default:
-		fmt.Println("Expected 'run', 'init', or 'plugin' subcommands")
+		fmt.Println("Expected 'run', 'patch', 'secret', 'init', or 'plugin' subcommands")
 		os.Exit(1)
 	}
+
+	// Код завершения зависит от категории ошибки: проверка, плагин, шаг, откат
+	if err != nil {
+		logMessage("ERROR", "%s", err)
+		os.Exit(run.ExitCode(err))
+	}
 }
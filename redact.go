@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	v1 "github.com/laplasd/roller-epi/v1"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// DEFAULT_REDACT_PATTERNS шаблоны имён ключей, значения которых маскируются в логах
+	DEFAULT_REDACT_PATTERNS = []string{"password", "token", "key", "secret"}
+	REDACTED_VALUE          = "******"
+	MAX_REDACT_DEPTH        = 32
+
+	// LOG_REDACTOR общий для всех логов: настраивается в setupLogging, поля плагинов добавляются при их загрузке (redactPluginFields)
+	LOG_REDACTOR = NewRedactor(DEFAULT_REDACT_PATTERNS)
+
+	// textPairRegexp пары 'password: x', 'token=x', '"secret":"x"', 'map[password:x]':
+	// 1 - всё до значения, 2 - ключ, 3 - значение
+	textPairRegexp = regexp.MustCompile(`((?:^|[\s{(\[,;&?"'])["']?([\w.-]+)["']?\s*[:=]\s*)("[^"]*"|'[^']*'|[^\s,;&\]})]+)`)
+)
+
+// Redactor маскирует значения чувствительных ключей в аргументах и тексте сообщений.
+// Ключ чувствителен, если его имя содержит один из шаблонов (регулярные выражения без учёта регистра)
+// или совпадает с полем, которое плагин объявил чувствительным.
+type Redactor struct {
+	mu       sync.RWMutex
+	patterns []string
+	fields   []string
+	keyRe    *regexp.Regexp
+	textRe   *regexp.Regexp
+}
+
+func NewRedactor(patterns []string) *Redactor {
+	r := &Redactor{}
+	r.SetPatterns(patterns)
+	return r
+}
+
+// SetPatterns заменяет шаблоны ключей; пустой список - DEFAULT_REDACT_PATTERNS
+func (r *Redactor) SetPatterns(patterns []string) {
+	if len(patterns) == 0 {
+		patterns = DEFAULT_REDACT_PATTERNS
+	}
+	valid := make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			fmt.Printf("WARNING: Invalid redact pattern '%s': %v\n", pattern, err)
+			continue
+		}
+		valid = append(valid, pattern)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.patterns = valid
+	r.compile()
+}
+
+// AddFields добавляет имена полей, объявленные плагинами (plugin.SchemaExecutor)
+func (r *Redactor) AddFields(fields ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, field := range fields {
+		if field == "" || containsFold(r.fields, field) {
+			continue
+		}
+		r.fields = append(r.fields, field)
+	}
+	r.compile()
+}
+
+// compile собирает регулярные выражения; вызывается под r.mu
+func (r *Redactor) compile() {
+
+	if len(r.patterns) > 0 {
+		r.keyRe = regexp.MustCompile(`(?i)` + strings.Join(r.patterns, "|"))
+	} else {
+		r.keyRe = nil
+	}
+	if r.keyRe == nil && len(r.fields) == 0 {
+		r.textRe = nil
+		return
+	}
+	// Ключ пары проверяется через Sensitive, поэтому шаблоны с '^' и '$' работают и в тексте
+	r.textRe = textPairRegexp
+}
+
+// Sensitive сообщает, нужно ли маскировать значение ключа
+func (r *Redactor) Sensitive(key string) bool {
+	if r == nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if containsFold(r.fields, key) {
+		return true
+	}
+	return r.keyRe != nil && r.keyRe.MatchString(key)
+}
+
+// Text маскирует значения пар 'ключ: значение' и 'ключ=значение' в готовом сообщении
+func (r *Redactor) Text(message string) string {
+	if r == nil {
+		return message
+	}
+	r.mu.RLock()
+	textRe := r.textRe
+	r.mu.RUnlock()
+	if textRe == nil {
+		return message
+	}
+	return textRe.ReplaceAllStringFunc(message, func(pair string) string {
+		match := textRe.FindStringSubmatch(pair)
+		if !r.Sensitive(match[2]) {
+			// Значение может содержать вложенные пары: 'config: map[password:x]'
+			return match[1] + r.Text(match[3])
+		}
+		return match[1] + REDACTED_VALUE
+	})
+}
+
+// Value возвращает копию значения для вывода в лог: в картах, срезах и структурах
+// на любой глубине значения чувствительных ключей заменяются на REDACTED_VALUE.
+// Ошибки, fmt.Stringer и скалярные значения возвращаются без изменений.
+func (r *Redactor) Value(value interface{}) interface{} {
+	if r == nil || value == nil {
+		return value
+	}
+	return r.value(reflect.ValueOf(value), 0)
+}
+
+func (r *Redactor) value(v reflect.Value, depth int) interface{} {
+
+	if !v.IsValid() {
+		return nil
+	}
+	if v.CanInterface() {
+		switch v.Interface().(type) {
+		case error, fmt.Stringer, []byte:
+			return v.Interface()
+		}
+	}
+	if depth > MAX_REDACT_DEPTH {
+		return "..."
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return r.value(v.Elem(), depth+1)
+
+	case reflect.Map:
+		redacted := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			key := fmt.Sprint(iter.Key().Interface())
+			if r.Sensitive(key) {
+				redacted[key] = REDACTED_VALUE
+				continue
+			}
+			redacted[key] = r.value(iter.Value(), depth+1)
+		}
+		return redacted
+
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			return nil
+		}
+		redacted := make([]interface{}, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			redacted[i] = r.value(v.Index(i), depth+1)
+		}
+		return redacted
+
+	case reflect.Struct:
+		// Структура выводится как карта экспортируемых полей
+		t := v.Type()
+		redacted := make(map[string]interface{}, t.NumField())
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			if r.Sensitive(field.Name) {
+				redacted[field.Name] = REDACTED_VALUE
+				continue
+			}
+			redacted[field.Name] = r.value(v.Field(i), depth+1)
+		}
+		return redacted
+	}
+
+	if v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
+
+// Outputs возвращает копию выходных значений шага для журнала выполнения: значения
+// чувствительных ключей и пары 'ключ=значение' в строках (например, в stdout) маскируются
+func (r *Redactor) Outputs(outputs map[string]interface{}) map[string]interface{} {
+	if r == nil || outputs == nil {
+		return outputs
+	}
+	redacted, _ := r.text(r.Value(outputs)).(map[string]interface{})
+	return redacted
+}
+
+// text маскирует строки в копии, которую вернул Value
+func (r *Redactor) text(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		return r.Text(v)
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = r.text(item)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = r.text(item)
+		}
+	}
+	return value
+}
+
+// Args маскирует аргументы logMessage перед форматированием
+func (r *Redactor) Args(args []interface{}) []interface{} {
+	if r == nil || len(args) == 0 {
+		return args
+	}
+	redacted := make([]interface{}, len(args))
+	for i, arg := range args {
+		redacted[i] = r.Value(arg)
+	}
+	return redacted
+}
+
+// redactHook маскирует сообщение и поля каждой записи logrus до форматирования,
+// включая записи, созданные в обход logMessage
+type redactHook struct {
+	redactor *Redactor
+}
+
+func (h *redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *redactHook) Fire(entry *logrus.Entry) error {
+	entry.Message = h.redactor.Text(entry.Message)
+	for key, value := range entry.Data {
+		if h.redactor.Sensitive(key) {
+			entry.Data[key] = REDACTED_VALUE
+			continue
+		}
+		entry.Data[key] = h.redactor.Value(value)
+	}
+	return nil
+}
+
+// redactPluginFields добавляет поля, объявленные плагином, при его загрузке или установке
+// (PluginController.OnPluginLoad), в том числе при установке во время проверки миграции
+func redactPluginFields(name string, executor v1.Executor) {
+	LOG_REDACTOR.AddFields(plugin.ExecutorSensitiveFields(executor)...)
+}
+
+func containsFold(values []string, value string) bool {
+	for _, item := range values {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Ilya-Guyduk/RoLLeR/handlers/plugin"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRedactorText(t *testing.T) {
+	redactor := NewRedactor(nil)
+	redactor.AddFields("dsn")
+
+	tests := []struct {
+		name    string
+		message string
+		want    string
+	}{
+		{name: "colon", message: "password: hunter2", want: "password: ******"},
+		{name: "equals", message: "connect user=app password=hunter2 host=db", want: "connect user=app password=****** host=db"},
+		{name: "json", message: `{"user":"app","api_token":"abc.def"}`, want: `{"user":"app","api_token":******}`},
+		{name: "json with spaces", message: `{"secret": "x y"}`, want: `{"secret": ******}`},
+		{name: "go map", message: "config: map[password:x user:app]", want: "config: map[password:****** user:app]"},
+		{name: "go map nested", message: "config: map[user:app env:map[PG_PASSWORD:x]]", want: "config: map[user:app env:map[PG_PASSWORD:******]]"},
+		{name: "single quotes", message: "token='a b' next", want: "token=****** next"},
+		{name: "case insensitive", message: "PASSWORD=x", want: "PASSWORD=******"},
+		{name: "key in name", message: "ssh_key_path=/root/.ssh/id", want: "ssh_key_path=******"},
+		{name: "query", message: "GET /api?user=app&token=abc&x=1", want: "GET /api?user=app&token=******&x=1"},
+		{name: "plugin field", message: "dsn=postgres://app:pw@db", want: "dsn=******"},
+		{name: "no pairs", message: "password is required", want: "password is required"},
+		{name: "not a key", message: "mypassword_hint is set", want: "mypassword_hint is set"},
+		{name: "not sensitive", message: "user=app, region: eu", want: "user=app, region: eu"},
+		{name: "several", message: "a=1 password=x b=2 token=y", want: "a=1 password=****** b=2 token=******"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactor.Text(tt.message); got != tt.want {
+				t.Errorf("Text(%q) = %q, want %q", tt.message, got, tt.want)
+			}
+		})
+	}
+
+	var nilRedactor *Redactor
+	if got := nilRedactor.Text("password=x"); got != "password=x" {
+		t.Errorf("nil Redactor Text() = %q", got)
+	}
+}
+
+func TestRedactorPatterns(t *testing.T) {
+	redactor := NewRedactor([]string{"^pin$", "("})
+	if !redactor.Sensitive("PIN") || redactor.Sensitive("password") {
+		t.Error("SetPatterns() did not replace the default patterns")
+	}
+	if got := redactor.Text("pin=1234 password=x"); got != "pin=****** password=x" {
+		t.Errorf("Text() = %q", got)
+	}
+
+	// Якоря шаблона относятся к имени ключа, а не ко всему сообщению
+	if got := redactor.Text("spin=1 pin=2"); got != "spin=1 pin=******" {
+		t.Errorf("Text() = %q", got)
+	}
+
+	// Пустой список - шаблоны по умолчанию
+	redactor.SetPatterns(nil)
+	if !redactor.Sensitive("db_password") {
+		t.Error("SetPatterns(nil) did not restore the default patterns")
+	}
+}
+
+type testCredentials struct {
+	User     string
+	Password string
+	Nested   *testCredentials
+	Tokens   []string
+	hidden   string
+}
+
+func TestRedactorValue(t *testing.T) {
+	redactor := NewRedactor(nil)
+	redactor.AddFields("host")
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  interface{}
+	}{
+		{name: "scalar", value: 42, want: 42},
+		{name: "string", value: "password=x", want: "password=x"},
+		{name: "nil", value: nil, want: nil},
+		{
+			name:  "map",
+			value: map[string]interface{}{"user": "app", "password": "x", "host": "db"},
+			want:  map[string]interface{}{"user": "app", "password": REDACTED_VALUE, "host": REDACTED_VALUE},
+		},
+		{
+			name: "nested map and slice",
+			value: map[string]interface{}{
+				"components": []interface{}{
+					map[string]interface{}{"name": "db", "config": map[string]interface{}{"api_token": "t", "port": 5432}},
+				},
+			},
+			want: map[string]interface{}{
+				"components": []interface{}{
+					map[string]interface{}{"name": "db", "config": map[string]interface{}{"api_token": REDACTED_VALUE, "port": 5432}},
+				},
+			},
+		},
+		{
+			name:  "typed map",
+			value: map[string]string{"secret_key": "k", "region": "eu"},
+			want:  map[string]interface{}{"secret_key": REDACTED_VALUE, "region": "eu"},
+		},
+		{
+			name: "struct",
+			value: &testCredentials{
+				User:     "app",
+				Password: "x",
+				Nested:   &testCredentials{User: "root", Password: "y"},
+				Tokens:   []string{"a"},
+				hidden:   "z",
+			},
+			want: map[string]interface{}{
+				"User":     "app",
+				"Password": REDACTED_VALUE,
+				"Nested":   map[string]interface{}{"User": "root", "Password": REDACTED_VALUE, "Nested": nil, "Tokens": REDACTED_VALUE},
+				"Tokens":   REDACTED_VALUE,
+			},
+		},
+		{name: "slice", value: []map[string]string{{"token": "t"}}, want: []interface{}{map[string]interface{}{"token": REDACTED_VALUE}}},
+		{name: "error", value: errors.New("password=x"), want: errors.New("password=x")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactor.Value(tt.value); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Value() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// Исходное значение не меняется
+	source := map[string]interface{}{"password": "x"}
+	redactor.Value(source)
+	if source["password"] != "x" {
+		t.Errorf("Value() changed the source: %v", source)
+	}
+
+	// Глубина вложенности ограничена
+	deep := map[string]interface{}{}
+	current := deep
+	for i := 0; i <= MAX_REDACT_DEPTH; i++ {
+		next := map[string]interface{}{}
+		current["next"] = next
+		current = next
+	}
+	redactor.Value(deep)
+}
+
+func TestRedactorOutputs(t *testing.T) {
+	redactor := NewRedactor(nil)
+	outputs := map[string]interface{}{
+		"stdout":    "login ok\npassword=hunter2\n",
+		"api_token": "t",
+		"exit_code": 0,
+	}
+	want := map[string]interface{}{
+		"stdout":    "login ok\npassword=******\n",
+		"api_token": REDACTED_VALUE,
+		"exit_code": 0,
+	}
+	if got := redactor.Outputs(outputs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Outputs() = %v, want %v", got, want)
+	}
+}
+
+func TestRedactHookFire(t *testing.T) {
+	hook := &redactHook{redactor: NewRedactor(nil)}
+	entry := &logrus.Entry{
+		Message: "connect password=hunter2",
+		Data: logrus.Fields{
+			"token":  "abc",
+			"stand":  "PROD",
+			"config": map[string]interface{}{"secret": "s", "host": "db"},
+		},
+	}
+
+	if err := hook.Fire(entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Message != "connect password=******" {
+		t.Errorf("Message = %q", entry.Message)
+	}
+	want := logrus.Fields{
+		"token":  REDACTED_VALUE,
+		"stand":  "PROD",
+		"config": map[string]interface{}{"secret": REDACTED_VALUE, "host": "db"},
+	}
+	if !reflect.DeepEqual(entry.Data, want) {
+		t.Errorf("Data = %v, want %v", entry.Data, want)
+	}
+	if len(hook.Levels()) != len(logrus.AllLevels) {
+		t.Errorf("Levels() = %v, want all levels", hook.Levels())
+	}
+}
+
+func TestRedactPluginFields(t *testing.T) {
+	defaultRedactor := LOG_REDACTOR
+	LOG_REDACTOR = NewRedactor(nil)
+	defer func() { LOG_REDACTOR = defaultRedactor }()
+
+	// Поля плагина маскируются, как только он загружен или установлен
+	pc := &plugin.PluginController{OnPluginLoad: redactPluginFields}
+	if _, err := pc.NewPluginController(t.TempDir(), t.TempDir(), ""); err != nil {
+		t.Fatal(err)
+	}
+	if !LOG_REDACTOR.Sensitive("env") {
+		t.Error("fields of the built-in 'local' plugin are not registered")
+	}
+}